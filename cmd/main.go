@@ -5,14 +5,27 @@ import (
 	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/ncostamagna/axul_auth/auth"
+	"github.com/ncostamagna/go-app-users-lab/internal/audit"
+	"github.com/ncostamagna/go-app-users-lab/internal/eventbus"
+	"github.com/ncostamagna/go-app-users-lab/internal/outbox"
 	"github.com/ncostamagna/go-app-users-lab/internal/user"
+	"github.com/ncostamagna/go-app-users-lab/internal/webhook"
 	"github.com/ncostamagna/go-app-users-lab/pkg/bootstrap"
+	"github.com/ncostamagna/go-app-users-lab/pkg/broker"
+	"github.com/ncostamagna/go-app-users-lab/pkg/clientinfo"
 	"github.com/ncostamagna/go-app-users-lab/pkg/handler"
+	"github.com/ncostamagna/go-app-users-lab/pkg/mail"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey"
+	"github.com/ncostamagna/go-app-users-lab/pkg/storage"
 	"github.com/ncostamagna/go-app-users-lab/pkg/twofa"
+	"github.com/ncostamagna/go-app-users-lab/pkg/uow"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+	_ "time/tzdata"
 )
 
 func main() {
@@ -36,8 +49,158 @@ func main() {
 	if err != nil {
 		os.Exit(-1)
 	}
-	userSrv := user.NewService(l, a, twofa.New(os.Getenv("TWILIO_SERVICE_SID"), os.Getenv("TWILIO_FRIENDLY_NAME"), os.Getenv("TWILIO_QR")), userRepo)
-	h := handler.NewUserHTTPServer(ctx, user.MakeEndpoints(userSrv, user.Config{LimPageDef: pagLimDef}))
+	webhookRepo := webhook.NewRepo(l, db)
+	webhookSrv := webhook.NewService(l, webhookRepo)
+	go webhook.NewDispatcher(l, webhookRepo, webhook.NewClient(10*time.Second), 100).Run(ctx, 5*time.Second)
+
+	// the outbox feeds the webhooks, the broker and the configured publisher
+	publishers := []outbox.Publisher{webhookSrv}
+	pub, err := broker.NewFromEnv()
+	if err != nil {
+		l.Fatal(err)
+	}
+	if pub != nil {
+		defer pub.Close()
+		topic := os.Getenv("BROKER_TOPIC")
+		if topic == "" {
+			topic = "users.events"
+		}
+		publishers = append(publishers, eventbus.NewNotifier(pub, topic))
+	}
+	switch os.Getenv("OUTBOX_PUBLISHER") {
+	case "http":
+		publishers = append(publishers, outbox.NewHTTPPublisher(os.Getenv("OUTBOX_HTTP_URL"), &http.Client{Timeout: 10 * time.Second}))
+	case "", "log":
+		publishers = append(publishers, outbox.NewLogPublisher(l))
+	}
+
+	trustTTL := 30 * 24 * time.Hour
+	if v := os.Getenv("TRUSTED_DEVICE_TTL"); v != "" {
+		if trustTTL, err = time.ParseDuration(v); err != nil {
+			l.Fatal(err)
+		}
+	}
+	// the devices are signed with a key of their own, not the one of the tokens
+	var trust *user.DeviceTrust
+	if trustTTL > 0 {
+		trustKey := os.Getenv("TRUSTED_DEVICE_KEY")
+		switch trustKey {
+		case "":
+			l.Fatal("TRUSTED_DEVICE_KEY is required, a TRUSTED_DEVICE_TTL of 0 turns device trust off")
+		case os.Getenv("JWT_KEY"):
+			l.Fatal("TRUSTED_DEVICE_KEY must differ from JWT_KEY")
+		}
+		trust = user.NewDeviceTrust(trustKey, trustTTL)
+	}
+
+	var passkeys passkey.Passkey
+	if rpID := os.Getenv("WEBAUTHN_RP_ID"); rpID != "" {
+		if passkeys, err = passkey.New(rpID, os.Getenv("WEBAUTHN_RP_NAME"), strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",")); err != nil {
+			l.Fatal(err)
+		}
+	}
+
+	magicLink := user.MagicLinkConfig{
+		URL:            os.Getenv("MAGIC_LINK_URL"),
+		TTL:            15 * time.Minute,
+		Throttle:       5,
+		ThrottleWindow: time.Hour,
+		BindBrowser:    os.Getenv("MAGIC_LINK_BIND_BROWSER") == "true",
+	}
+	if v := os.Getenv("MAGIC_LINK_TTL"); v != "" {
+		if magicLink.TTL, err = time.ParseDuration(v); err != nil {
+			l.Fatal(err)
+		}
+	}
+	if v := os.Getenv("MAGIC_LINK_THROTTLE"); v != "" {
+		if magicLink.Throttle, err = strconv.Atoi(v); err != nil {
+			l.Fatal(err)
+		}
+	}
+	if v := os.Getenv("MAGIC_LINK_THROTTLE_WINDOW"); v != "" {
+		if magicLink.ThrottleWindow, err = time.ParseDuration(v); err != nil {
+			l.Fatal(err)
+		}
+	}
+
+	// the links are signed with a key of their own, not the one of the tokens
+	storageKey := os.Getenv("STORAGE_SIGNING_KEY")
+	if storageKey != "" && storageKey == os.Getenv("JWT_KEY") {
+		l.Fatal("STORAGE_SIGNING_KEY must differ from JWT_KEY")
+	}
+	storageURL := os.Getenv("STORAGE_URL")
+	if storageURL == "" {
+		storageURL = "/blobs"
+	}
+	signer, err := storage.NewSigner(storageKey, storageURL)
+	if err != nil {
+		l.Fatal(err)
+	}
+	blobs, err := storage.NewFromEnv(signer)
+	if err != nil {
+		l.Fatal(err)
+	}
+	storageInterval := 10 * time.Minute
+	if v := os.Getenv("STORAGE_CLEANUP_INTERVAL"); v != "" {
+		if storageInterval, err = time.ParseDuration(v); err != nil {
+			l.Fatal(err)
+		}
+	}
+	go storage.RunCleanup(ctx, l, blobs, storageInterval)
+
+	auditSrv := audit.NewService(l, audit.NewRepo(l, db), os.Getenv("AUDIT_HASH_CHAIN") == "true")
+
+	userSrv := user.NewService(l, a, twofa.New(os.Getenv("TWILIO_SERVICE_SID"), os.Getenv("TWILIO_FRIENDLY_NAME"), os.Getenv("TWILIO_QR")), userRepo, uow.New(db), auditSrv, user.NewMailLoginNotifier(mail.NewFromEnv(l)), trust, passkeys, magicLink, blobs)
+
+	if retention := os.Getenv("USER_RETENTION"); retention != "" {
+		maxAge, err := time.ParseDuration(retention)
+		if err != nil {
+			l.Fatal(err)
+		}
+		interval := time.Hour
+		if v := os.Getenv("USER_RETENTION_INTERVAL"); v != "" {
+			if interval, err = time.ParseDuration(v); err != nil {
+				l.Fatal(err)
+			}
+		}
+		go user.RunRetention(ctx, l, userSrv, maxAge, interval)
+	}
+
+	outboxInterval := 5 * time.Second
+	if v := os.Getenv("OUTBOX_INTERVAL"); v != "" {
+		if outboxInterval, err = time.ParseDuration(v); err != nil {
+			l.Fatal(err)
+		}
+	}
+	go outbox.NewDispatcher(l, db, outbox.NewPublishers(publishers...), 100).Run(ctx, outboxInterval)
+
+	proxies, err := clientinfo.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		l.Fatal(err)
+	}
+
+	// the webhooks, the audit log and the user management routes are only for admins
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		l.Println("ADMIN_TOKEN is not set, the admin APIs reject every request")
+	}
+
+	userH := handler.NewUserHTTPServer(ctx, user.MakeEndpoints(userSrv, user.Config{LimPageDef: pagLimDef}), proxies, adminToken)
+	webhookH := handler.NewWebhookHTTPServer(ctx, webhook.MakeEndpoints(webhookSrv, webhook.Config{LimPageDef: pagLimDef}))
+	auditH := handler.NewAuditHTTPServer(ctx, audit.MakeEndpoints(auditSrv, audit.Config{LimPageDef: pagLimDef}))
+	blobH := handler.NewBlobHTTPServer(ctx, blobs, signer)
+
+	webhookH = handler.RequireAdmin(adminToken, webhookH)
+	auditH = handler.RequireAdmin(adminToken, auditH)
+
+	h := http.NewServeMux()
+	h.Handle("/users", userH)
+	h.Handle("/users/", userH)
+	h.Handle("/webhooks", webhookH)
+	h.Handle("/webhooks/", webhookH)
+	h.Handle("/audit", auditH)
+	h.Handle("/audit/", auditH)
+	h.Handle("/blobs/", blobH)
 
 	port := os.Getenv("PORT")
 	address := fmt.Sprintf("127.0.0.1:%s", port)
@@ -64,8 +227,9 @@ func main() {
 func accessControl(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS, HEAD, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept,Authorization,Cache-Control,Content-Type,DNT,If-Modified-Since,Keep-Alive,Origin,User-Agent,X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, OPTIONS, HEAD, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept,Authorization,Cache-Control,Content-Type,DNT,If-Modified-Since,Keep-Alive,Origin,User-Agent,X-Requested-With,If-Match,If-None-Match,X-Trusted-Device")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		if r.Method == "OPTIONS" {
 			return
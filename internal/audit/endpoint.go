@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-http-utils/meta"
+	"github.com/ncostamagna/go-http-utils/response"
+)
+
+type (
+	Controller func(ctx context.Context, request interface{}) (interface{}, error)
+
+	Endpoints struct {
+		GetAll Controller
+		Verify Controller
+	}
+
+	GetAllReq struct {
+		ActorID  string
+		TargetID string
+		Action   string
+		Outcome  string
+		IP       string
+		From     *time.Time
+		To       *time.Time
+		Limit    int
+		Page     int
+	}
+
+	Config struct {
+		LimPageDef string
+	}
+)
+
+func MakeEndpoints(s Service, config Config) Endpoints {
+
+	return Endpoints{
+		GetAll: makeGetAllEndpoint(s, config),
+		Verify: makeVerifyEndpoint(s),
+	}
+
+}
+
+func makeGetAllEndpoint(s Service, config Config) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetAllReq)
+
+		if req.Outcome != "" && req.Outcome != string(domain.AuditOutcomeOK) && req.Outcome != string(domain.AuditOutcomeFailed) {
+			return nil, response.BadRequest(i18n.Localize(ctx, ErrInvalidOutcome{req.Outcome}))
+		}
+
+		filters := Filters{
+			ActorID:  req.ActorID,
+			TargetID: req.TargetID,
+			Action:   req.Action,
+			Outcome:  req.Outcome,
+			IP:       req.IP,
+			From:     req.From,
+			To:       req.To,
+		}
+
+		count, err := s.Count(ctx, filters)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		meta, err := meta.New(req.Page, req.Limit, count, config.LimPageDef)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		entries, err := s.GetAll(ctx, filters, meta.Offset(), meta.Limit())
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", entries, meta), nil
+	}
+}
+
+func makeVerifyEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		v, err := s.Verify(ctx)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", v, nil), nil
+	}
+}
@@ -0,0 +1,15 @@
+package audit
+
+import "github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+
+type ErrInvalidOutcome struct {
+	Outcome string
+}
+
+func (e ErrInvalidOutcome) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidOutcome) Localized() i18n.Message {
+	return i18n.Msg("audit_invalid_outcome", e.Outcome)
+}
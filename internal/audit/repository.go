@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	Filters struct {
+		ActorID  string
+		TargetID string
+		Action   string
+		Outcome  string
+		IP       string
+		From     *time.Time
+		To       *time.Time
+	}
+
+	// Repository is append-only, entries are never updated nor deleted.
+	Repository interface {
+		Append(ctx context.Context, entry *domain.AuditEntry, chain func(prev *domain.AuditEntry) error) error
+		GetAll(ctx context.Context, filters Filters, offset, limit int) ([]domain.AuditEntry, error)
+		Count(ctx context.Context, filters Filters) (int, error)
+		Walk(ctx context.Context, fn func(entry *domain.AuditEntry) error) error
+	}
+
+	repo struct {
+		log *log.Logger
+		db  *gorm.DB
+	}
+)
+
+// NewRepo uses its own connection, never the caller's transaction,
+// so failed actions are still recorded when their transaction rolls back.
+func NewRepo(log *log.Logger, db *gorm.DB) Repository {
+	return &repo{
+		log: log,
+		db:  db,
+	}
+}
+
+// Append stores the entry. When chain is set it is called with the last
+// entry, locked until the new one is stored, so it can link to it.
+func (repo *repo) Append(ctx context.Context, entry *domain.AuditEntry, chain func(prev *domain.AuditEntry) error) error {
+	if chain == nil {
+		if err := repo.db.WithContext(ctx).Create(entry).Error; err != nil {
+			repo.log.Println(err)
+			return err
+		}
+		return nil
+	}
+
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var prev domain.AuditEntry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Order("id desc").Limit(1).Find(&prev).Error
+		if err != nil {
+			return err
+		}
+
+		var last *domain.AuditEntry
+		if prev.ID != 0 {
+			last = &prev
+		}
+		if err := chain(last); err != nil {
+			return err
+		}
+
+		return tx.Create(entry).Error
+	})
+	if err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) GetAll(ctx context.Context, filters Filters, offset, limit int) ([]domain.AuditEntry, error) {
+	var e []domain.AuditEntry
+
+	tx := applyFilters(repo.db.WithContext(ctx).Model(&e), filters)
+	if err := tx.Order("id desc").Limit(limit).Offset(offset).Find(&e).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return e, nil
+}
+
+func (repo *repo) Count(ctx context.Context, filters Filters) (int, error) {
+	var count int64
+
+	tx := applyFilters(repo.db.WithContext(ctx).Model(&domain.AuditEntry{}), filters)
+	if err := tx.Count(&count).Error; err != nil {
+		repo.log.Println(err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// Walk calls fn with every entry in insertion order, a batch at a time.
+func (repo *repo) Walk(ctx context.Context, fn func(entry *domain.AuditEntry) error) error {
+	var batch []domain.AuditEntry
+	return repo.db.WithContext(ctx).Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+func applyFilters(tx *gorm.DB, filters Filters) *gorm.DB {
+
+	if filters.ActorID != "" {
+		tx = tx.Where("actor_id = ?", filters.ActorID)
+	}
+
+	if filters.TargetID != "" {
+		tx = tx.Where("target_id = ?", filters.TargetID)
+	}
+
+	if filters.Action != "" {
+		tx = tx.Where("action = ?", filters.Action)
+	}
+
+	if filters.Outcome != "" {
+		tx = tx.Where("outcome = ?", filters.Outcome)
+	}
+
+	if filters.IP != "" {
+		tx = tx.Where("ip = ?", filters.IP)
+	}
+
+	if filters.From != nil {
+		tx = tx.Where("created_at >= ?", *filters.From)
+	}
+
+	if filters.To != nil {
+		tx = tx.Where("created_at <= ?", *filters.To)
+	}
+
+	return tx
+}
@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"strconv"
+	"time"
+)
+
+type (
+	AttributeType       string
+	AttributeVisibility string
+)
+
+const (
+	AttributeString  AttributeType = "string"
+	AttributeNumber  AttributeType = "number"
+	AttributeBoolean AttributeType = "boolean"
+	AttributeDate    AttributeType = "date"
+	AttributeEnum    AttributeType = "enum"
+
+	// AttributePublic can be read and changed by the user itself,
+	// AttributeReadOnly only read and AttributePrivate is only
+	// seen through the admin endpoints.
+	AttributePublic   AttributeVisibility = "public"
+	AttributeReadOnly AttributeVisibility = "readonly"
+	AttributePrivate  AttributeVisibility = "private"
+)
+
+// AttributeDateLayout is how date attributes are written and stored.
+const AttributeDateLayout = "2006-01-02"
+
+// Attribute is the schema of a custom profile attribute defined by an
+// admin. Min and Max bound the length of strings and the value of
+// numbers, Pattern applies to strings and Options lists the values of
+// an enum.
+type Attribute struct {
+	Name       string              `json:"name" gorm:"type:varchar(50);not null;primary_key"`
+	Label      string              `json:"label,omitempty" gorm:"type:varchar(100)"`
+	Type       AttributeType       `json:"type" gorm:"type:char(10);not null"`
+	Required   bool                `json:"required" gorm:"not null;default:false"`
+	Visibility AttributeVisibility `json:"visibility" gorm:"type:char(10);not null"`
+	Pattern    string              `json:"pattern,omitempty" gorm:"type:varchar(255)"`
+	Min        *float64            `json:"min,omitempty"`
+	Max        *float64            `json:"max,omitempty"`
+	Options    []string            `json:"options,omitempty" gorm:"type:text;serializer:json"`
+	CreatedAt  time.Time           `json:"created_at" gorm:"not null"`
+	UpdatedAt  time.Time           `json:"updated_at" gorm:"not null"`
+}
+
+// UserAttribute is the value of an attribute for a user, kept as text
+// in the canonical form of its type so it can be filtered on.
+type UserAttribute struct {
+	UserID string `gorm:"type:char(36);not null;primary_key"`
+	Name   string `gorm:"type:varchar(50);not null;primary_key;index:idx_user_attributes_value,priority:1"`
+	Value  string `gorm:"type:varchar(255);not null;index:idx_user_attributes_value,priority:2"`
+}
+
+// Decode returns the stored text of a value as its type, numbers as
+// float64, booleans as bool and the rest as string.
+func (a Attribute) Decode(text string) interface{} {
+	switch a.Type {
+	case AttributeNumber:
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case AttributeBoolean:
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+	return text
+}
+
+// AttributeText returns the stored text of a value decoded by Decode.
+func AttributeText(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return ""
+}
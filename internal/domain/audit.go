@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type (
+	AuditAction  string
+	AuditOutcome string
+)
+
+const (
+	AuditLogin         AuditAction  = "login"
+	AuditLogin2FA      AuditAction  = "login_2fa"
+	AuditEnroll2FA     AuditAction  = "enroll_2fa"
+	AuditUserCreate    AuditAction  = "user_create"
+	AuditUserUpdate    AuditAction  = "user_update"
+	AuditUserDelete    AuditAction  = "user_delete"
+	AuditUserRestore   AuditAction  = "user_restore"
+	AuditUserPurge     AuditAction  = "user_purge"
+	AuditSessionRevoke AuditAction  = "session_revoke"
+	AuditDeviceTrust   AuditAction  = "device_trust"
+	AuditDeviceRevoke  AuditAction  = "device_revoke"
+	AuditPasskeyAdd    AuditAction  = "passkey_register"
+	AuditPasskeyDelete AuditAction  = "passkey_delete"
+	AuditPasskeyLogin  AuditAction  = "login_passkey"
+	AuditMagicLink     AuditAction  = "login_magic_link"
+	AuditFactorRemove  AuditAction  = "factor_remove"
+	AuditOutcomeOK     AuditOutcome = "success"
+	AuditOutcomeFailed AuditOutcome = "failure"
+)
+
+// AuditEntry is an append-only record of a security relevant action.
+// With the hash chain enabled, Hash covers the entry and PrevHash, so
+// changing or removing an entry breaks every hash after it.
+type AuditEntry struct {
+	ID        uint64          `json:"id" gorm:"primaryKey;autoIncrement"`
+	ActorID   string          `json:"actor_id,omitempty" gorm:"type:char(36);index"`
+	TargetID  string          `json:"target_id,omitempty" gorm:"type:char(36);index"`
+	Action    AuditAction     `json:"action" gorm:"type:char(30);not null;index"`
+	Outcome   AuditOutcome    `json:"outcome" gorm:"type:char(10);not null"`
+	Reason    string          `json:"reason,omitempty" gorm:"type:varchar(255)"`
+	IP        string          `json:"ip,omitempty" gorm:"type:varchar(45)"`
+	UserAgent string          `json:"user_agent,omitempty" gorm:"type:varchar(255)"`
+	Diff      json.RawMessage `json:"diff,omitempty" gorm:"type:text"`
+	CreatedAt time.Time       `json:"created_at" gorm:"not null;index"`
+	PrevHash  string          `json:"prev_hash,omitempty" gorm:"type:char(64)"`
+	Hash      string          `json:"hash,omitempty" gorm:"type:char(64)"`
+}
+
+// FieldChange is the old and new value of a field in an audit diff.
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
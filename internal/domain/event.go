@@ -0,0 +1,107 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	UserCreated      EventType = "user.created"
+	UserUpdated      EventType = "user.updated"
+	UserDeleted      EventType = "user.deleted"
+	UserRestored     EventType = "user.restored"
+	UserPurged       EventType = "user.purged"
+	UserTwoFAEnabled EventType = "user.twofa_enabled"
+)
+
+// Event is a user lifecycle change. It is stored in the outbox table
+// in the same transaction as the change and published afterwards.
+type Event struct {
+	ID            string          `json:"id" gorm:"type:char(36);not null;primary_key"`
+	Type          EventType       `json:"type" gorm:"type:char(50);not null;index"`
+	UserID        string          `json:"user_id" gorm:"type:char(36);not null;index"`
+	Payload       json.RawMessage `json:"payload" gorm:"type:text"`
+	OccurredAt    time.Time       `json:"occurred_at" gorm:"not null;index"`
+	PublishedAt   *time.Time      `json:"-" gorm:"index"`
+	Attempts      int             `json:"-" gorm:"not null;default:0"`
+	LastError     string          `json:"-" gorm:"type:varchar(255)"`
+	NextAttemptAt *time.Time      `json:"-"`
+}
+
+type (
+	UserCreatedPayload struct {
+		Username   string                 `json:"username"`
+		FirstName  string                 `json:"first_name"`
+		LastName   string                 `json:"last_name"`
+		Email      string                 `json:"email"`
+		Phone      string                 `json:"phone"`
+		Attributes map[string]interface{} `json:"attributes,omitempty"`
+	}
+
+	// UserUpdatedPayload has the new value of every changed field.
+	UserUpdatedPayload struct {
+		Changes map[string]interface{} `json:"changes"`
+	}
+)
+
+func (Event) TableName() string {
+	return "outbox_events"
+}
+
+func NewUserCreatedEvent(u *User) Event {
+	return newEvent(UserCreated, u.ID, UserCreatedPayload{
+		Username:   u.Username,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		Email:      u.Email,
+		Phone:      u.Phone,
+		Attributes: u.Attributes,
+	})
+}
+
+func NewUserUpdatedEvent(id string, changes map[string]interface{}) Event {
+	return newEvent(UserUpdated, id, UserUpdatedPayload{Changes: changes})
+}
+
+// UserChanges returns the new value of every field being updated, nil
+// fields are left untouched.
+func UserChanges(firstName, lastName, email, phone *string) map[string]interface{} {
+	changes := make(map[string]interface{})
+
+	if firstName != nil {
+		changes["first_name"] = *firstName
+	}
+	if lastName != nil {
+		changes["last_name"] = *lastName
+	}
+	if email != nil {
+		changes["email"] = *email
+	}
+	if phone != nil {
+		changes["phone"] = *phone
+	}
+
+	return changes
+}
+
+// NewUserEvent builds the events that only carry the user ID.
+func NewUserEvent(t EventType, id string) Event {
+	return newEvent(t, id, struct{}{})
+}
+
+func newEvent(t EventType, userID string, payload interface{}) Event {
+	// the payloads are plain structs, they always marshal
+	b, _ := json.Marshal(payload)
+
+	return Event{
+		ID:         uuid.New().String(),
+		Type:       t,
+		UserID:     userID,
+		Payload:    b,
+		OccurredAt: time.Now(),
+	}
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// the statuses of a second factor, they mirror the Twilio ones,
+// the domain doesn't depend on the Twilio client.
+const (
+	FactorPending  = "pending"
+	FactorApproved = "approved"
+)
+
+// UserFactor is a second factor enrolled by a user, a user can have
+// several of them. Secret is the Twilio factor of the TOTP ones.
+type UserFactor struct {
+	ID         string     `json:"id" gorm:"type:char(36);not null;primary_key"`
+	UserID     string     `json:"-" gorm:"type:char(36);not null;index"`
+	Type       string     `json:"type" gorm:"type:char(10);not null"`
+	Label      string     `json:"label" gorm:"type:varchar(50)"`
+	Status     string     `json:"status" gorm:"type:char(10);not null;index"`
+	Secret     string     `json:"-" gorm:"type:char(34)"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"not null"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (f *UserFactor) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if f.ID == "" {
+		f.ID = uuid.New().String()
+	}
+	return
+}
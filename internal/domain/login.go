@@ -1,8 +1,125 @@
 package domain
 
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
 type Login struct {
-	Status        string `json:"status"`
-	TwoFactor     bool   `json:"two_factor"`
-	TwoFactorHash string `json:"two_factor_hash,omitempty"`
-	Token         string `json:"token,omitempty"`
+	Status    string `json:"status"`
+	TwoFactor bool   `json:"two_factor"`
+	// Factors lists the second factors the user can complete the login with.
+	Factors []LoginFactor `json:"factors,omitempty"`
+	// PreferredFactor is the ID of the factor the client should offer first.
+	PreferredFactor string `json:"preferred_factor,omitempty"`
+	TwoFactorHash   string `json:"two_factor_hash,omitempty"`
+	Token           string `json:"token,omitempty"`
+	// TrustedDevice is set when the device was remembered, presenting it
+	// on the next logins skips the 2FA step until it expires.
+	TrustedDevice          string     `json:"trusted_device,omitempty"`
+	TrustedDeviceExpiresAt *time.Time `json:"trusted_device_expires_at,omitempty"`
+}
+
+// LoginFactor is a second factor offered on login. Passkeys are offered
+// as a single factor, its ID is its type.
+type LoginFactor struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Label string `json:"label,omitempty"`
+}
+
+// UserLogin is a completed login, its ID is the session carried in the
+// token. Fingerprint identifies the device the login came from.
+type UserLogin struct {
+	ID          string     `json:"id" gorm:"type:char(36);not null;primary_key"`
+	UserID      string     `json:"user_id" gorm:"type:char(36);not null;index:idx_user_logins_fingerprint"`
+	Fingerprint string     `json:"fingerprint" gorm:"type:char(64);not null;index:idx_user_logins_fingerprint"`
+	IP          string     `json:"ip" gorm:"type:varchar(45)"`
+	UserAgent   string     `json:"user_agent" gorm:"type:varchar(255)"`
+	NewDevice   bool       `json:"new_device" gorm:"not null;default:false"`
+	NotMe       bool       `json:"not_me" gorm:"not null;default:false"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"not null;index"`
+}
+
+func (l *UserLogin) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if l.ID == "" {
+		l.ID = uuid.New().String()
+	}
+	return
+}
+
+// TrustedDevice is a device the user chose to remember after a 2FA login.
+// It is bound to the user agent it was trusted from.
+type TrustedDevice struct {
+	ID            string     `json:"id" gorm:"type:char(36);not null;primary_key"`
+	UserID        string     `json:"user_id" gorm:"type:char(36);not null;index"`
+	UserAgent     string     `json:"user_agent" gorm:"type:varchar(255)"`
+	UserAgentHash string     `json:"-" gorm:"type:char(64);not null"`
+	IP            string     `json:"ip" gorm:"type:varchar(45)"`
+	ExpiresAt     time.Time  `json:"expires_at" gorm:"not null"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt     *time.Time `json:"-"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"not null"`
+}
+
+func (d *TrustedDevice) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return
+}
+
+// MagicLink is a single use login link emailed to the user, only the
+// hash of its token is kept. BindingHash ties it to the browser that
+// asked for it, when browser binding is enabled.
+type MagicLink struct {
+	ID          string    `gorm:"type:char(36);not null;primary_key"`
+	UserID      string    `gorm:"type:char(36);not null;index"`
+	TokenHash   string    `gorm:"type:char(64);not null;uniqueIndex"`
+	BindingHash string    `gorm:"type:char(64)"`
+	IP          string    `gorm:"type:varchar(45)"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	UsedAt      *time.Time
+	CreatedAt   time.Time `gorm:"not null;index"`
+}
+
+func (m *MagicLink) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if m.ID == "" {
+		m.ID = uuid.New().String()
+	}
+	return
+}
+
+type OTPPurpose string
+
+const (
+	OTPLogin  OTPPurpose = "login"
+	OTPEnroll OTPPurpose = "enroll"
+)
+
+// OTPCode is a one-time code sent to the user, only its hash is kept.
+// It stops working once used, expired or after too many failed attempts.
+type OTPCode struct {
+	ID        string     `gorm:"type:char(36);not null;primary_key"`
+	UserID    string     `gorm:"type:char(36);not null;index:idx_otp_codes_user"`
+	Purpose   OTPPurpose `gorm:"type:char(10);not null;index:idx_otp_codes_user"`
+	CodeHash  string     `gorm:"type:char(60);not null"`
+	Attempts  int        `gorm:"not null;default:0"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+func (c *OTPCode) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	return
 }
@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PasskeyCeremony string
+
+const (
+	PasskeyRegistration PasskeyCeremony = "registration"
+	PasskeySecondFactor PasskeyCeremony = "second_factor"
+	PasskeyLogin        PasskeyCeremony = "login"
+)
+
+// Passkey is a WebAuthn credential of the user. SignCount is the last
+// counter the authenticator reported, it has to grow on every use.
+type Passkey struct {
+	ID              string     `json:"id" gorm:"type:char(36);not null;primary_key"`
+	UserID          string     `json:"user_id" gorm:"type:char(36);not null;index"`
+	Name            string     `json:"name" gorm:"type:varchar(64)"`
+	CredentialID    []byte     `json:"-" gorm:"type:varbinary(255);not null;uniqueIndex"`
+	PublicKey       []byte     `json:"-" gorm:"type:blob;not null"`
+	AttestationType string     `json:"-" gorm:"type:varchar(32)"`
+	Transports      string     `json:"transports,omitempty" gorm:"type:varchar(100)"`
+	AAGUID          []byte     `json:"-" gorm:"type:varbinary(16)"`
+	SignCount       uint32     `json:"sign_count" gorm:"not null;default:0"`
+	BackupEligible  bool       `json:"backup_eligible" gorm:"not null;default:false"`
+	BackupState     bool       `json:"backup_state" gorm:"not null;default:false"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"not null"`
+}
+
+func (p *Passkey) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if p.ID == "" {
+		p.ID = uuid.New().String()
+	}
+	return
+}
+
+// PasskeyChallenge keeps the state of a WebAuthn ceremony between its
+// begin and finish steps, it can be finished only once.
+type PasskeyChallenge struct {
+	ID        string          `gorm:"type:char(36);not null;primary_key"`
+	UserID    string          `gorm:"type:char(36);index"`
+	Ceremony  PasskeyCeremony `gorm:"type:char(20);not null"`
+	Session   []byte          `gorm:"type:blob;not null"`
+	ExpiresAt time.Time       `gorm:"not null;index"`
+}
+
+func (c *PasskeyChallenge) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	return
+}
@@ -0,0 +1,85 @@
+package domain
+
+import "time"
+
+const (
+	DefaultLocale     = "en"
+	DefaultTimezone   = "UTC"
+	DefaultDateFormat = "YYYY-MM-DD"
+)
+
+// the channels a user can opt in to be notified through
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+)
+
+// DateFormats maps the date formats a user can pick to their Go layout.
+var DateFormats = map[string]string{
+	"YYYY-MM-DD": "2006-01-02",
+	"DD/MM/YYYY": "02/01/2006",
+	"MM/DD/YYYY": "01/02/2006",
+	"DD.MM.YYYY": "02.01.2006",
+}
+
+// UserPreferences are the settings stored for a user, the empty
+// ones take the default so changing a default reaches them too.
+type UserPreferences struct {
+	UserID      string    `gorm:"type:char(36);not null;primary_key"`
+	Locale      string    `gorm:"type:varchar(35)"`
+	Timezone    string    `gorm:"type:varchar(64)"`
+	DateFormat  string    `gorm:"type:varchar(20)"`
+	NotifyEmail *bool     `gorm:"column:notify_email"`
+	NotifySMS   *bool     `gorm:"column:notify_sms"`
+	NotifyPush  *bool     `gorm:"column:notify_push"`
+	UpdatedAt   time.Time `gorm:"not null"`
+}
+
+// Preferences are the settings in effect for a user.
+type Preferences struct {
+	Locale        string          `json:"locale"`
+	Timezone      string          `json:"timezone"`
+	DateFormat    string          `json:"date_format"`
+	Notifications map[string]bool `json:"notifications"`
+}
+
+// Resolve returns the preferences in effect, the unset ones
+// take the default. Only email is opted in by default.
+func (p UserPreferences) Resolve() Preferences {
+	prefs := Preferences{
+		Locale:     p.Locale,
+		Timezone:   p.Timezone,
+		DateFormat: p.DateFormat,
+		Notifications: map[string]bool{
+			ChannelEmail: p.NotifyEmail == nil || *p.NotifyEmail,
+			ChannelSMS:   p.NotifySMS != nil && *p.NotifySMS,
+			ChannelPush:  p.NotifyPush != nil && *p.NotifyPush,
+		},
+	}
+
+	if prefs.Locale == "" {
+		prefs.Locale = DefaultLocale
+	}
+	if prefs.Timezone == "" {
+		prefs.Timezone = DefaultTimezone
+	}
+	if prefs.DateFormat == "" {
+		prefs.DateFormat = DefaultDateFormat
+	}
+	return prefs
+}
+
+// FormatTime writes t in the timezone and date format of the user.
+func (p Preferences) FormatTime(t time.Time) string {
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	layout, ok := DateFormats[p.DateFormat]
+	if !ok {
+		layout = DateFormats[DefaultDateFormat]
+	}
+	return t.In(loc).Format(layout + " 15:04 MST")
+}
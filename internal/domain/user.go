@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"path"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,19 +9,21 @@ import (
 )
 
 type User struct {
-	ID         string         `json:"id" gorm:"type:char(36);not null;primary_key;unique_index"`
-	Username   string         `json:"username" gorm:"type:char(20);not null;unique"`
-	FirstName  string         `json:"first_name" gorm:"type:char(50);not null"`
-	LastName   string         `json:"last_name" gorm:"type:char(50);not null"`
-	Email      string         `json:"email" gorm:"type:char(50)"`
-	Phone      string         `json:"phone" gorm:"type:char(30)"`
-	Password   string         `json:"password,omitempty" gorm:"type:char(150)"`
-	TwoFStatus string         `json:"twofa_status" gorm:"type:char(10)"`
-	TwoFCode   string         `json:"twofa_code" gorm:"type:char(34)"`
-	TwoFActive bool           `json:"twofa_active" gorm:"not null;default:false"`
-	CreatedAt  *time.Time     `json:"-"`
-	UpdatedAt  *time.Time     `json:"-"`
-	Deleted    gorm.DeletedAt `json:"-"`
+	ID            string                 `json:"id" gorm:"type:char(36);not null;primary_key;unique_index"`
+	Username      string                 `json:"username" gorm:"type:char(20);not null;unique;index:idx_users_search,class:FULLTEXT"`
+	FirstName     string                 `json:"first_name" gorm:"type:char(50);not null;index:idx_users_search,class:FULLTEXT"`
+	LastName      string                 `json:"last_name" gorm:"type:char(50);not null;index:idx_users_search,class:FULLTEXT"`
+	Email         string                 `json:"email" gorm:"type:char(50);index:idx_users_search,class:FULLTEXT"`
+	Phone         string                 `json:"phone" gorm:"type:char(30)"`
+	Password      string                 `json:"password,omitempty" gorm:"type:char(150)"`
+	TwoFPreferred string                 `json:"twofa_preferred,omitempty" gorm:"type:char(36)"`
+	Avatar        string                 `json:"-" gorm:"type:varchar(100)"`
+	AvatarURL     string                 `json:"avatar_url" gorm:"-"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty" gorm:"-"`
+	Version       int64                  `json:"version" gorm:"not null;default:1"`
+	CreatedAt     *time.Time             `json:"-"`
+	UpdatedAt     *time.Time             `json:"-"`
+	Deleted       gorm.DeletedAt         `json:"-"`
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
@@ -28,5 +31,26 @@ func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	if u.ID == "" {
 		u.ID = uuid.New().String()
 	}
+
+	if u.Version == 0 {
+		u.Version = 1
+	}
+
+	u.AvatarURL = AvatarURL(u.ID, u.Avatar)
 	return
 }
+
+func (u *User) AfterFind(tx *gorm.DB) (err error) {
+	u.AvatarURL = AvatarURL(u.ID, u.Avatar)
+	return
+}
+
+// AvatarURL is where the avatar of a user is served, the generated one
+// when it has none. The version of the upload keeps caches fresh.
+func AvatarURL(id, avatar string) string {
+	url := "/users/" + id + "/avatar"
+	if avatar != "" {
+		url += "?v=" + path.Base(avatar)
+	}
+	return url
+}
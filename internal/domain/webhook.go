@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+// Webhook is a partner subscription to the user events. Events holds the
+// comma separated event types it wants, "*" subscribes to all of them.
+type Webhook struct {
+	ID        string     `json:"id" gorm:"type:char(36);not null;primary_key"`
+	URL       string     `json:"url" gorm:"type:varchar(2048);not null"`
+	Events    string     `json:"events" gorm:"type:varchar(512);not null"`
+	Secret    string     `json:"secret,omitempty" gorm:"type:char(64);not null"`
+	Active    bool       `json:"active" gorm:"not null;default:true"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is an attempt to send one event to one webhook,
+// the log partners and admins can check and redeliver from.
+type WebhookDelivery struct {
+	ID             string          `json:"id" gorm:"type:char(36);not null;primary_key"`
+	WebhookID      string          `json:"webhook_id" gorm:"type:char(36);not null;index"`
+	EventID        string          `json:"event_id" gorm:"type:char(36);not null;index"`
+	EventType      EventType       `json:"event_type" gorm:"type:char(50);not null"`
+	Payload        json.RawMessage `json:"payload" gorm:"type:text"`
+	Status         DeliveryStatus  `json:"status" gorm:"type:char(10);not null;index"`
+	Attempts       int             `json:"attempts" gorm:"not null;default:0"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty" gorm:"type:varchar(255)"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty" gorm:"index"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      *time.Time      `json:"created_at"`
+}
+
+func (w *Webhook) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if w.ID == "" {
+		w.ID = uuid.New().String()
+	}
+	return
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) (err error) {
+
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return
+}
+
+// Subscribed reports whether the webhook wants events of type t.
+func (w *Webhook) Subscribed(t EventType) bool {
+	for _, e := range strings.Split(w.Events, ",") {
+		if e = strings.TrimSpace(e); e == "*" || e == string(t) {
+			return true
+		}
+	}
+	return false
+}
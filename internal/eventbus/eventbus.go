@@ -0,0 +1,109 @@
+package eventbus
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/broker"
+)
+
+// schemas has the JSON schema of every message, one file per event
+// type and version. A breaking change to a payload needs a new version.
+//
+//go:embed schemas/*.json
+var schemas embed.FS
+
+// versions is the schema version published for each event type.
+var versions = map[domain.EventType]int{
+	domain.UserCreated:      1,
+	domain.UserUpdated:      1,
+	domain.UserDeleted:      1,
+	domain.UserRestored:     1,
+	domain.UserPurged:       1,
+	domain.UserTwoFAEnabled: 1,
+}
+
+type (
+	// Envelope is the message published for an event, Schema names
+	// the JSON schema it follows, e.g. "user.created.v1".
+	Envelope struct {
+		Schema     string           `json:"schema"`
+		ID         string           `json:"id"`
+		Type       domain.EventType `json:"type"`
+		Version    int              `json:"version"`
+		UserID     string           `json:"user_id"`
+		OccurredAt time.Time        `json:"occurred_at"`
+		Data       json.RawMessage  `json:"data"`
+	}
+
+	// Notifier publishes the user events to a broker, keyed by user ID
+	// so the events of a user are consumed in order.
+	Notifier struct {
+		publisher broker.Publisher
+		topic     string
+	}
+)
+
+func NewNotifier(publisher broker.Publisher, topic string) *Notifier {
+	return &Notifier{
+		publisher: publisher,
+		topic:     topic,
+	}
+}
+
+// Publish sends the event to the broker, it is the outbox publisher of
+// the broker. The envelope keeps the ID of the event, so a consumer can
+// dedupe the ones published again after a failure.
+func (n *Notifier) Publish(ctx context.Context, e domain.Event) error {
+	env, err := NewEnvelope(e)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return n.publisher.Publish(ctx, broker.Message{
+		Topic: n.topic,
+		Key:   e.UserID,
+		Value: b,
+		Headers: map[string]string{
+			"content-type": "application/json",
+			"schema":       env.Schema,
+			"event-id":     e.ID,
+			"event-type":   string(e.Type),
+		},
+	})
+}
+
+func NewEnvelope(e domain.Event) (*Envelope, error) {
+	version, ok := versions[e.Type]
+	if !ok {
+		return nil, fmt.Errorf("event type '%s' has no schema", e.Type)
+	}
+
+	return &Envelope{
+		Schema:     SchemaName(e.Type, version),
+		ID:         e.ID,
+		Type:       e.Type,
+		Version:    version,
+		UserID:     e.UserID,
+		OccurredAt: e.OccurredAt,
+		Data:       e.Payload,
+	}, nil
+}
+
+func SchemaName(t domain.EventType, version int) string {
+	return fmt.Sprintf("%s.v%d", t, version)
+}
+
+// Schema returns the JSON schema document of a message, e.g. "user.created.v1".
+func Schema(name string) ([]byte, error) {
+	return schemas.ReadFile("schemas/" + name + ".json")
+}
@@ -0,0 +1,227 @@
+package eventbus_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/internal/eventbus"
+	"github.com/ncostamagna/go-app-users-lab/pkg/broker"
+)
+
+const topic = "users.events"
+
+func TestNotifierPublish(t *testing.T) {
+	userID := uuid.New().String()
+	user := &domain.User{
+		ID:         userID,
+		Username:   "jdoe",
+		FirstName:  "John",
+		LastName:   "Doe",
+		Email:      "jdoe@example.com",
+		Phone:      "+5491100000000",
+		Attributes: map[string]interface{}{"department": "sales"},
+	}
+	firstName := "Johnny"
+
+	tests := []struct {
+		name  string
+		event domain.Event
+	}{
+		{"created", domain.NewUserCreatedEvent(user)},
+		{"created without attributes", domain.NewUserCreatedEvent(&domain.User{ID: userID, Username: "jdoe"})},
+		{"updated", domain.NewUserUpdatedEvent(userID, domain.UserChanges(&firstName, nil, nil, nil))},
+		{"updated attributes", domain.NewUserUpdatedEvent(userID, map[string]interface{}{
+			"attributes": map[string]interface{}{"department": nil},
+			"avatar_url": "/users/" + userID + "/avatar?v=2",
+		})},
+		{"updated 2fa", domain.NewUserUpdatedEvent(userID, map[string]interface{}{
+			"twofa_status": "enabled",
+			"twofa_active": true,
+		})},
+		{"deleted", domain.NewUserEvent(domain.UserDeleted, userID)},
+		{"restored", domain.NewUserEvent(domain.UserRestored, userID)},
+		{"purged", domain.NewUserEvent(domain.UserPurged, userID)},
+		{"twofa enabled", domain.NewUserEvent(domain.UserTwoFAEnabled, userID)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := broker.NewMemory()
+			if err := eventbus.NewNotifier(mem, topic).Publish(context.Background(), tt.event); err != nil {
+				t.Fatalf("publish: %v", err)
+			}
+
+			msgs := mem.Messages()
+			if len(msgs) != 1 {
+				t.Fatalf("published %d messages, want 1", len(msgs))
+			}
+			msg := msgs[0]
+
+			schema := string(tt.event.Type) + ".v1"
+			if msg.Topic != topic {
+				t.Errorf("topic = %q, want %q", msg.Topic, topic)
+			}
+			if msg.Key != tt.event.UserID {
+				t.Errorf("key = %q, want the user ID %q", msg.Key, tt.event.UserID)
+			}
+			wantHeaders := map[string]string{
+				"content-type": "application/json",
+				"schema":       schema,
+				"event-id":     tt.event.ID,
+				"event-type":   string(tt.event.Type),
+			}
+			if !reflect.DeepEqual(msg.Headers, wantHeaders) {
+				t.Errorf("headers = %v, want %v", msg.Headers, wantHeaders)
+			}
+
+			var env eventbus.Envelope
+			if err := json.Unmarshal(msg.Value, &env); err != nil {
+				t.Fatalf("decode envelope: %v", err)
+			}
+			if env.Schema != schema || env.Version != 1 {
+				t.Errorf("schema = %q version %d, want %q version 1", env.Schema, env.Version, schema)
+			}
+			if env.ID != tt.event.ID || env.Type != tt.event.Type || env.UserID != tt.event.UserID {
+				t.Errorf("envelope = %s %s %s, want %s %s %s",
+					env.ID, env.Type, env.UserID, tt.event.ID, tt.event.Type, tt.event.UserID)
+			}
+			if !env.OccurredAt.Equal(tt.event.OccurredAt) {
+				t.Errorf("occurred_at = %v, want %v", env.OccurredAt, tt.event.OccurredAt)
+			}
+
+			assertValid(t, schema, msg.Value)
+		})
+	}
+}
+
+func TestNotifierPublishUnknownType(t *testing.T) {
+	mem := broker.NewMemory()
+	err := eventbus.NewNotifier(mem, topic).Publish(context.Background(),
+		domain.NewUserEvent("user.unknown", uuid.New().String()))
+	if err == nil {
+		t.Fatal("published an event type without a schema")
+	}
+	if n := len(mem.Messages()); n != 0 {
+		t.Errorf("published %d messages, want none", n)
+	}
+}
+
+// TestSchemasRejectDrift makes sure the validator below does check the
+// payloads, so a field added to an event without its schema fails.
+func TestSchemasRejectDrift(t *testing.T) {
+	env, err := eventbus.NewEnvelope(domain.NewUserUpdatedEvent(uuid.New().String(),
+		map[string]interface{}{"nickname": "jd"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validate(t, "user.updated.v1", b); err == nil {
+		t.Error("user.updated.v1 accepted a change it doesn't declare")
+	}
+}
+
+func assertValid(t *testing.T, name string, doc []byte) {
+	t.Helper()
+	if err := validate(t, name, doc); err != nil {
+		t.Errorf("%s: %v\n%s", name, err, doc)
+	}
+}
+
+func validate(t *testing.T, name string, doc []byte) error {
+	t.Helper()
+	b, err := eventbus.Schema(name)
+	if err != nil {
+		t.Fatalf("schema %s: %v", name, err)
+	}
+
+	var schema, value map[string]interface{}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatalf("schema %s: %v", name, err)
+	}
+	if err := json.Unmarshal(doc, &value); err != nil {
+		t.Fatalf("message: %v", err)
+	}
+	return check("$", schema, value)
+}
+
+// check validates v against the keywords the schemas use: type, const,
+// required, properties, additionalProperties and format.
+func check(path string, schema map[string]interface{}, v interface{}) error {
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, v) {
+		return fmt.Errorf("%s: %v isn't %v", path, v, c)
+	}
+
+	if typ, ok := schema["type"].(string); ok && !hasType(typ, v) {
+		return fmt.Errorf("%s: %v isn't a %s", path, v, typ)
+	}
+
+	if format, ok := schema["format"].(string); ok {
+		s, _ := v.(string)
+		switch format {
+		case "uuid":
+			if _, err := uuid.Parse(s); err != nil {
+				return fmt.Errorf("%s: %q isn't a uuid", path, s)
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %q isn't a date-time", path, s)
+			}
+		}
+	}
+
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if _, ok := obj[r.(string)]; !ok {
+				return fmt.Errorf("%s: %s is required", path, r)
+			}
+		}
+	}
+
+	props, _ := schema["properties"].(map[string]interface{})
+	for k, pv := range obj {
+		ps, ok := props[k].(map[string]interface{})
+		if !ok {
+			if schema["additionalProperties"] == false {
+				return fmt.Errorf("%s: %s isn't allowed", path, k)
+			}
+			continue
+		}
+		if err := check(path+"."+k, ps, pv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasType(typ string, v interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "integer", "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"gorm.io/gorm"
+)
+
+const (
+	maxBackoff   = time.Hour
+	maxErrorSize = 255
+)
+
+// Write stores the events with tx, the transaction of the change
+// they describe, so both are committed or rolled back together.
+func Write(tx *gorm.DB, events ...domain.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return tx.Create(&events).Error
+}
+
+type (
+	// Dispatcher publishes the outbox events with at-least-once semantics,
+	// an event is marked as published only after the publisher accepted it.
+	Dispatcher struct {
+		log       *log.Logger
+		db        *gorm.DB
+		publisher Publisher
+		batchSize int
+	}
+)
+
+func NewDispatcher(log *log.Logger, db *gorm.DB, publisher Publisher, batchSize int) *Dispatcher {
+	return &Dispatcher{
+		log:       log,
+		db:        db,
+		publisher: publisher,
+		batchSize: batchSize,
+	}
+}
+
+// Run dispatches the pending events every interval until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := d.Dispatch(ctx)
+			if err != nil {
+				d.log.Println("outbox:", err)
+			}
+			if err != nil || n < d.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch publishes a batch of pending events and returns how many it read.
+// Failed events are retried with exponential backoff, the events of the same
+// user after a failed one wait for it to keep them in order: the ones in the
+// batch are skipped, and while it waits for its retry the later ones aren't
+// read at all.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	var events []domain.Event
+
+	now := time.Now()
+	err := d.db.WithContext(ctx).Table("outbox_events AS e").
+		Where("e.published_at IS NULL AND (e.next_attempt_at IS NULL OR e.next_attempt_at <= ?)", now).
+		Where(`NOT EXISTS (SELECT 1 FROM outbox_events AS w WHERE w.user_id = e.user_id
+			AND w.published_at IS NULL AND w.occurred_at < e.occurred_at AND w.next_attempt_at > ?)`, now).
+		Order("e.occurred_at").
+		Limit(d.batchSize).
+		Find(&events).Error
+	if err != nil {
+		return 0, err
+	}
+
+	blocked := make(map[string]bool)
+	for _, e := range events {
+		if blocked[e.UserID] {
+			continue
+		}
+
+		if err := d.publisher.Publish(ctx, e); err != nil {
+			blocked[e.UserID] = true
+			d.log.Printf("outbox: event %s (%s) failed: %v", e.ID, e.Type, err)
+			if err := d.retryLater(ctx, e, err); err != nil {
+				return len(events), err
+			}
+			continue
+		}
+
+		now := time.Now()
+		if err := d.db.WithContext(ctx).Model(&domain.Event{}).Where("id = ?", e.ID).
+			Updates(map[string]interface{}{"published_at": &now, "attempts": e.Attempts + 1}).Error; err != nil {
+			return len(events), err
+		}
+	}
+
+	return len(events), nil
+}
+
+func (d *Dispatcher) retryLater(ctx context.Context, e domain.Event, cause error) error {
+	attempts := e.Attempts + 1
+
+	backoff := time.Second << uint(attempts)
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	next := time.Now().Add(backoff)
+
+	msg := cause.Error()
+	if len(msg) > maxErrorSize {
+		msg = msg[:maxErrorSize]
+	}
+
+	return d.db.WithContext(ctx).Model(&domain.Event{}).Where("id = ?", e.ID).
+		Updates(map[string]interface{}{
+			"attempts":        attempts,
+			"last_error":      msg,
+			"next_attempt_at": &next,
+		}).Error
+}
@@ -0,0 +1,93 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+)
+
+type (
+	// Publisher delivers an event to the outside world. It may be called
+	// more than once for the same event, consumers dedupe by event ID.
+	Publisher interface {
+		Publish(ctx context.Context, event domain.Event) error
+	}
+
+	publishers []Publisher
+
+	logPublisher struct {
+		log *log.Logger
+	}
+
+	httpPublisher struct {
+		url    string
+		client *http.Client
+	}
+)
+
+// NewPublishers fans every event out to all the publishers. It fails when
+// any of them does, so the event is published again to all of them.
+func NewPublishers(p ...Publisher) Publisher {
+	return publishers(p)
+}
+
+func (ps publishers) Publish(ctx context.Context, event domain.Event) error {
+	var errs []error
+	for _, p := range ps {
+		if err := p.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewLogPublisher writes the events to the log, useful in development.
+func NewLogPublisher(log *log.Logger) Publisher {
+	return &logPublisher{log: log}
+}
+
+func (p *logPublisher) Publish(_ context.Context, event domain.Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	p.log.Println("event:", string(b))
+	return nil
+}
+
+// NewHTTPPublisher posts every event as JSON to url, any 2xx is a success.
+func NewHTTPPublisher(url string, client *http.Client) Publisher {
+	return &httpPublisher{url: url, client: client}
+}
+
+func (p *httpPublisher) Publish(ctx context.Context, event domain.Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", event.ID)
+	req.Header.Set("X-Event-Type", string(event.Type))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("publish event %s: unexpected status %d", event.ID, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+package user
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+)
+
+const (
+	maxAttributeLabel = 100
+	maxAttributeValue = 255
+)
+
+var attributeName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+type adminKey struct{}
+
+// WithAdmin marks ctx as a request made with the admin token, it is
+// shown the private attributes of the users.
+func WithAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminKey{}, true)
+}
+
+func isAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
+
+func (s service) GetAttributes(ctx context.Context) ([]domain.Attribute, error) {
+	return s.repo.GetAttributes(ctx)
+}
+
+// CreateAttribute defines a new custom attribute, it is private
+// unless a visibility is given.
+func (s service) CreateAttribute(ctx context.Context, a *domain.Attribute) error {
+	if a.Visibility == "" {
+		a.Visibility = domain.AttributePrivate
+	}
+
+	if err := validateAttribute(a); err != nil {
+		return err
+	}
+	return s.repo.CreateAttribute(ctx, a)
+}
+
+// UpdateAttribute replaces the settings of an attribute, its type
+// can't change. The values already stored aren't checked again, the
+// new rules apply the next time they are set.
+func (s service) UpdateAttribute(ctx context.Context, a *domain.Attribute) error {
+	old, err := s.repo.GetAttribute(ctx, a.Name)
+	if err != nil {
+		return err
+	}
+
+	if a.Type == "" {
+		a.Type = old.Type
+	}
+	if a.Type != old.Type {
+		return ErrInvalidAttribute{a.Name, i18n.Msg("attribute_type_immutable")}
+	}
+
+	if a.Visibility == "" {
+		a.Visibility = domain.AttributePrivate
+	}
+
+	if err := validateAttribute(a); err != nil {
+		return err
+	}
+
+	a.CreatedAt = old.CreatedAt
+	return s.repo.UpdateAttribute(ctx, a)
+}
+
+// DeleteAttribute removes the attribute and its value from every user.
+func (s service) DeleteAttribute(ctx context.Context, name string) error {
+	return s.repo.DeleteAttribute(ctx, name)
+}
+
+// GetMe returns the user with the attributes it is allowed to see.
+func (s service) GetMe(ctx context.Context, user *domain.User) (*domain.User, error) {
+	defs, err := s.attributeDefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.attachAttributes(ctx, defs, true, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateMe updates the profile of the user. The email isn't part of it,
+// codes and magic links are sent there, and of the attributes only the
+// public ones can be changed.
+func (s service) UpdateMe(ctx context.Context, user *domain.User, firstName, lastName, phone *string, attributes map[string]interface{}, version *int64) error {
+	return s.update(ctx, user.ID, user.ID, firstName, lastName, nil, phone, attributes, version, true)
+}
+
+func (s service) attributeDefs(ctx context.Context) (map[string]domain.Attribute, error) {
+	attributes, err := s.repo.GetAttributes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defs := make(map[string]domain.Attribute, len(attributes))
+	for _, a := range attributes {
+		defs[a.Name] = a
+	}
+	return defs, nil
+}
+
+// attachAttributes sets the attribute values of the users, with
+// hidePrivate the private ones are left out.
+func (s service) attachAttributes(ctx context.Context, defs map[string]domain.Attribute, hidePrivate bool, users ...*domain.User) error {
+	if len(defs) == 0 || len(users) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*domain.User, len(users))
+	ids := make([]string, len(users))
+	for i, u := range users {
+		byID[u.ID] = u
+		ids[i] = u.ID
+	}
+
+	values, err := s.repo.GetUserAttributes(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		def, ok := defs[v.Name]
+		if !ok || (hidePrivate && def.Visibility == domain.AttributePrivate) {
+			continue
+		}
+
+		u := byID[v.UserID]
+		if u.Attributes == nil {
+			u.Attributes = make(map[string]interface{})
+		}
+		u.Attributes[v.Name] = def.Decode(v.Value)
+	}
+	return nil
+}
+
+// attachUsersAttributes is attachAttributes for a listing, the
+// private attributes are only shown to the admins.
+func (s service) attachUsersAttributes(ctx context.Context, users []domain.User) error {
+	defs, err := s.attributeDefs(ctx)
+	if err != nil {
+		return err
+	}
+
+	ptrs := make([]*domain.User, len(users))
+	for i := range users {
+		ptrs[i] = &users[i]
+	}
+	return s.attachAttributes(ctx, defs, !isAdmin(ctx), ptrs...)
+}
+
+// attributeFilters turns the attribute filters into the stored form of
+// their values, so they match whatever way the value was written. Only
+// the admins can filter by the private ones.
+func (s service) attributeFilters(ctx context.Context, filters Filters) (Filters, error) {
+	if len(filters.Attributes) == 0 {
+		return filters, nil
+	}
+
+	defs, err := s.attributeDefs(ctx)
+	if err != nil {
+		return filters, err
+	}
+
+	values := make(map[string]string, len(filters.Attributes))
+	for name, raw := range filters.Attributes {
+		def, ok := defs[name]
+		if !ok || (def.Visibility == domain.AttributePrivate && !isAdmin(ctx)) {
+			return filters, ErrAttributeNotFound{name}
+		}
+
+		v, err := parseAttributeValue(def, raw)
+		if err != nil {
+			return filters, err
+		}
+		values[name] = domain.AttributeText(v)
+	}
+
+	filters.Attributes = values
+	return filters, nil
+}
+
+// attributeValues checks the values being set against their definitions
+// and returns them in their canonical form, a nil value removes the
+// attribute. With self the user sets its own, only the public
+// attributes can be changed then.
+func attributeValues(defs map[string]domain.Attribute, values map[string]interface{}, self bool) (map[string]interface{}, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	parsed := make(map[string]interface{}, len(values))
+	for name, v := range values {
+		def, ok := defs[name]
+		if !ok || (self && def.Visibility == domain.AttributePrivate) {
+			return nil, ErrAttributeNotFound{name}
+		}
+
+		if self && def.Visibility != domain.AttributePublic {
+			return nil, ErrInvalidAttributeValue{name, i18n.Msg("attribute_value_readonly")}
+		}
+
+		if v == nil {
+			if def.Required {
+				return nil, ErrInvalidAttributeValue{name, i18n.Msg("attribute_value_required")}
+			}
+			parsed[name] = nil
+			continue
+		}
+
+		p, err := parseAttributeValue(def, v)
+		if err != nil {
+			return nil, err
+		}
+		parsed[name] = p
+	}
+	return parsed, nil
+}
+
+// newUserAttributes checks the attributes of a new user, every required
+// one must be set. Nil values are dropped.
+func newUserAttributes(defs map[string]domain.Attribute, values map[string]interface{}) (map[string]interface{}, error) {
+	parsed, err := attributeValues(defs, values, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, v := range parsed {
+		if v == nil {
+			delete(parsed, name)
+		}
+	}
+
+	for name, def := range defs {
+		if _, ok := parsed[name]; def.Required && !ok {
+			return nil, ErrInvalidAttributeValue{name, i18n.Msg("attribute_value_required")}
+		}
+	}
+
+	if len(parsed) == 0 {
+		return nil, nil
+	}
+	return parsed, nil
+}
+
+// parseAttributeValue validates a value as decoded from JSON, strings are
+// accepted for every type so query parameters and CSV columns can be
+// used too. It returns the value the way Attribute.Decode does.
+func parseAttributeValue(def domain.Attribute, v interface{}) (interface{}, error) {
+	invalid := func(reason i18n.Message) error {
+		return ErrInvalidAttributeValue{def.Name, reason}
+	}
+
+	switch def.Type {
+	case domain.AttributeNumber:
+		var f float64
+		switch v := v.(type) {
+		case float64:
+			f = v
+		case string:
+			var err error
+			if f, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, invalid(i18n.Msg("attribute_value_number"))
+			}
+		default:
+			return nil, invalid(i18n.Msg("attribute_value_number"))
+		}
+
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, invalid(i18n.Msg("attribute_value_number"))
+		}
+		if def.Min != nil && f < *def.Min {
+			return nil, invalid(i18n.Msg("attribute_value_min", *def.Min))
+		}
+		if def.Max != nil && f > *def.Max {
+			return nil, invalid(i18n.Msg("attribute_value_max", *def.Max))
+		}
+		return f, nil
+
+	case domain.AttributeBoolean:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, invalid(i18n.Msg("attribute_value_boolean"))
+	}
+
+	str, ok := v.(string)
+	if !ok {
+		return nil, invalid(i18n.Msg("attribute_value_string"))
+	}
+
+	switch def.Type {
+	case domain.AttributeDate:
+		t, err := time.Parse(domain.AttributeDateLayout, str)
+		if err != nil {
+			return nil, invalid(i18n.Msg("attribute_value_date", domain.AttributeDateLayout))
+		}
+		return t.Format(domain.AttributeDateLayout), nil
+
+	case domain.AttributeEnum:
+		for _, o := range def.Options {
+			if o == str {
+				return str, nil
+			}
+		}
+		return nil, invalid(i18n.Msg("attribute_value_option"))
+	}
+
+	n := utf8.RuneCountInString(str)
+	if len(str) > maxAttributeValue {
+		return nil, invalid(i18n.Msg("attribute_value_too_long"))
+	}
+	if def.Min != nil && float64(n) < *def.Min {
+		return nil, invalid(i18n.Msg("attribute_value_min_length", *def.Min))
+	}
+	if def.Max != nil && float64(n) > *def.Max {
+		return nil, invalid(i18n.Msg("attribute_value_max_length", *def.Max))
+	}
+	if def.Pattern != "" {
+		// the pattern was compiled when the attribute was defined
+		if re, err := regexp.Compile(def.Pattern); err == nil && !re.MatchString(str) {
+			return nil, invalid(i18n.Msg("attribute_value_pattern"))
+		}
+	}
+	return str, nil
+}
+
+func validateAttribute(a *domain.Attribute) error {
+	invalid := func(reason i18n.Message) error {
+		return ErrInvalidAttribute{a.Name, reason}
+	}
+
+	if !attributeName.MatchString(a.Name) {
+		return invalid(i18n.Msg("attribute_name"))
+	}
+
+	if utf8.RuneCountInString(a.Label) > maxAttributeLabel {
+		return invalid(i18n.Msg("attribute_label_too_long"))
+	}
+
+	switch a.Type {
+	case domain.AttributeString, domain.AttributeNumber, domain.AttributeBoolean, domain.AttributeDate, domain.AttributeEnum:
+	default:
+		return invalid(i18n.Msg("attribute_type"))
+	}
+
+	switch a.Visibility {
+	case domain.AttributePublic, domain.AttributeReadOnly, domain.AttributePrivate:
+	default:
+		return invalid(i18n.Msg("attribute_visibility"))
+	}
+
+	if a.Pattern != "" {
+		if a.Type != domain.AttributeString {
+			return invalid(i18n.Msg("attribute_pattern_type"))
+		}
+		if _, err := regexp.Compile(a.Pattern); err != nil {
+			return invalid(i18n.Msg("attribute_pattern"))
+		}
+	}
+
+	if a.Min != nil || a.Max != nil {
+		if a.Type != domain.AttributeString && a.Type != domain.AttributeNumber {
+			return invalid(i18n.Msg("attribute_range_type"))
+		}
+		if a.Type == domain.AttributeString && ((a.Min != nil && *a.Min < 0) || (a.Max != nil && *a.Max < 0)) {
+			return invalid(i18n.Msg("attribute_range_negative"))
+		}
+		if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+			return invalid(i18n.Msg("attribute_range"))
+		}
+	}
+
+	if a.Type != domain.AttributeEnum {
+		if len(a.Options) > 0 {
+			return invalid(i18n.Msg("attribute_options_type"))
+		}
+		return nil
+	}
+
+	if len(a.Options) == 0 {
+		return invalid(i18n.Msg("attribute_options_required"))
+	}
+
+	seen := make(map[string]bool, len(a.Options))
+	for _, o := range a.Options {
+		if o == "" || len(o) > maxAttributeValue {
+			return invalid(i18n.Msg("attribute_option_length"))
+		}
+		if seen[o] {
+			return invalid(i18n.Msg("attribute_option_repeated", o))
+		}
+		seen[o] = true
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package user_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/internal/user"
+)
+
+// newAttributeService returns a service holding jdoe with a public
+// and a private attribute.
+func newAttributeService(t *testing.T) (user.Service, domain.User) {
+	t.Helper()
+
+	u := domain.User{ID: "0b8f0f36-3c43-4a35-9f0e-0f7d1c3d7e51", Username: "jdoe"}
+	repo := newMemRepo(u)
+	repo.attributes = []domain.Attribute{
+		{Name: "team", Type: domain.AttributeString, Visibility: domain.AttributePublic},
+		{Name: "salary", Type: domain.AttributeNumber, Visibility: domain.AttributePrivate},
+	}
+	repo.values = []domain.UserAttribute{
+		{UserID: u.ID, Name: "team", Value: "core"},
+		{UserID: u.ID, Name: "salary", Value: "1000"},
+	}
+
+	return user.NewService(log.New(io.Discard, "", 0), nil, nil, repo, nil, &memAuditor{}, nil, nil, nil, user.MagicLinkConfig{}, nil), u
+}
+
+func TestPrivateAttributesHidden(t *testing.T) {
+	srv, u := newAttributeService(t)
+	ctx := context.Background()
+
+	got, err := srv.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Attributes["salary"]; ok || got.Attributes["team"] != "core" {
+		t.Errorf("get = %v, want only the public attribute", got.Attributes)
+	}
+
+	users, err := srv.GetAll(ctx, user.Filters{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := users[0].Attributes["salary"]; ok || users[0].Attributes["team"] != "core" {
+		t.Errorf("listing = %v, want only the public attribute", users[0].Attributes)
+	}
+
+	var notFound user.ErrAttributeNotFound
+	if _, err := srv.GetAll(ctx, user.Filters{Attributes: map[string]string{"salary": "1000"}}, 0, 10); !errors.As(err, &notFound) {
+		t.Errorf("filtered by a private attribute: err = %v, want ErrAttributeNotFound", err)
+	}
+}
+
+func TestPrivateAttributesAdmin(t *testing.T) {
+	srv, u := newAttributeService(t)
+	ctx := user.WithAdmin(context.Background())
+
+	got, err := srv.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Attributes["salary"] != float64(1000) || got.Attributes["team"] != "core" {
+		t.Errorf("get = %v, want every attribute", got.Attributes)
+	}
+
+	users, err := srv.GetAll(ctx, user.Filters{Attributes: map[string]string{"salary": "1000"}}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users[0].Attributes["salary"] != float64(1000) {
+		t.Errorf("listing = %v, want every attribute", users[0].Attributes)
+	}
+}
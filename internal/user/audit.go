@@ -0,0 +1,101 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/clientinfo"
+	"github.com/ncostamagna/go-app-users-lab/pkg/uow"
+)
+
+// Auditor records the security relevant actions made through the service.
+type Auditor interface {
+	Record(ctx context.Context, entry domain.AuditEntry) error
+}
+
+// audit records the outcome of an action on the target user. Successful
+// actions are recorded once the unit of work commits, failed ones right
+// away, so they are kept even when the transaction rolls back. The actor
+// is taken from the request token, actorID overrides it for the login
+// flows, where the caller isn't authenticated yet.
+func (s service) audit(ctx context.Context, action domain.AuditAction, actorID, targetID string, err error, diff map[string]domain.FieldChange) {
+	info := clientinfo.FromContext(ctx)
+
+	entry := domain.AuditEntry{
+		ActorID:   actorID,
+		TargetID:  targetID,
+		Action:    action,
+		Outcome:   domain.AuditOutcomeOK,
+		IP:        info.IP,
+		UserAgent: truncate(info.UserAgent, 255),
+	}
+
+	if entry.ActorID == "" && info.Token != "" {
+		if v, err := s.auth.Check(info.Token); err == nil {
+			entry.ActorID = v.ID
+		}
+	}
+
+	if len(diff) > 0 {
+		entry.Diff, _ = json.Marshal(diff)
+	}
+
+	if err != nil {
+		entry.Outcome = domain.AuditOutcomeFailed
+		entry.Reason = truncate(err.Error(), 255)
+		s.record(ctx, entry)
+		return
+	}
+
+	uow.AfterCommit(ctx, func(ctx context.Context) {
+		s.record(ctx, entry)
+	})
+}
+
+// record doesn't fail the action, an entry that can't be stored is logged.
+func (s service) record(ctx context.Context, entry domain.AuditEntry) {
+	if err := s.auditor.Record(ctx, entry); err != nil {
+		s.log.Println("audit", entry.Action, entry.TargetID, err)
+	}
+}
+
+// userDiff returns the old and new value of the changed fields,
+// changes holds the new values as returned by domain.UserChanges.
+func userDiff(old *domain.User, changes map[string]interface{}) map[string]domain.FieldChange {
+	current := map[string]interface{}{
+		"first_name": old.FirstName,
+		"last_name":  old.LastName,
+		"email":      old.Email,
+		"phone":      old.Phone,
+	}
+
+	diff := make(map[string]domain.FieldChange)
+	for field, value := range changes {
+		if field == "attributes" {
+			continue
+		}
+		if current[field] != value {
+			diff[field] = domain.FieldChange{Old: current[field], New: value}
+		}
+	}
+
+	attributes, _ := changes["attributes"].(map[string]interface{})
+	for name, value := range attributes {
+		if old.Attributes[name] != value {
+			diff["attributes."+name] = domain.FieldChange{Old: old.Attributes[name], New: value}
+		}
+	}
+	return diff
+}
+
+// truncate cuts s to n characters, the way the columns count them. The
+// invalid UTF-8 sequences are replaced so the database accepts it.
+func truncate(s string, n int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
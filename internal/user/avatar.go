@@ -0,0 +1,199 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"image/png"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/imaging"
+	"github.com/ncostamagna/go-app-users-lab/pkg/storage"
+	"github.com/ncostamagna/go-app-users-lab/pkg/uow"
+)
+
+const (
+	maxAvatarBytes    = 5 << 20
+	maxAvatarPixels   = 25_000_000
+	defaultAvatarSize = 128
+)
+
+// avatarSizes are the square variants made of every upload.
+var avatarSizes = []int{64, 128, 256}
+
+// avatarColors are the backgrounds of the generated avatars,
+// a user always gets the same one.
+var avatarColors = []string{"#1abc9c", "#2e86de", "#8e44ad", "#e67e22", "#c0392b", "#16a085", "#d35400", "#2c3e50"}
+
+// SetAvatar stores the variants of the image as the user's avatar. The
+// image is decoded and encoded again, so none of its metadata is kept.
+func (s service) SetAvatar(ctx context.Context, user *domain.User, r io.Reader) (*domain.User, error) {
+	avatar, err := s.setAvatar(ctx, user, r)
+
+	diff := map[string]domain.FieldChange{"avatar_url": {Old: user.AvatarURL, New: domain.AvatarURL(user.ID, avatar)}}
+	s.audit(ctx, domain.AuditUserUpdate, user.ID, user.ID, err, diff)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Avatar = avatar
+	user.AvatarURL = domain.AvatarURL(user.ID, avatar)
+	user.Version++
+	return user, nil
+}
+
+func (s service) setAvatar(ctx context.Context, user *domain.User, r io.Reader) (string, error) {
+	if s.blobs == nil {
+		return "", ErrStorageDisabled
+	}
+
+	img, orientation, err := imaging.Decode(r, maxAvatarBytes, maxAvatarPixels)
+	if err != nil {
+		return "", err
+	}
+
+	// every upload gets its own prefix, the URL of the old
+	// one keeps working until the user is saved
+	avatar := fmt.Sprintf("avatars/%s/%d", user.ID, time.Now().UnixNano())
+
+	err = s.uow.Do(ctx, func(ctx context.Context) error {
+		for _, size := range avatarSizes {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, imaging.Square(img, orientation, size)); err != nil {
+				return err
+			}
+
+			key := avatarKey(avatar, size)
+			if err := s.blobs.Put(ctx, key, &buf, storage.PutOptions{ContentType: "image/png"}); err != nil {
+				return err
+			}
+			uow.Compensate(ctx, func(ctx context.Context) error {
+				return s.blobs.Delete(ctx, key)
+			})
+		}
+
+		if err := s.repo.SetAvatar(ctx, user.ID, avatar); err != nil {
+			return err
+		}
+
+		if old := user.Avatar; old != "" {
+			uow.AfterCommit(ctx, func(ctx context.Context) {
+				s.deleteAvatarFiles(ctx, old)
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return avatar, nil
+}
+
+// DeleteAvatar goes back to the generated avatar.
+func (s service) DeleteAvatar(ctx context.Context, user *domain.User) error {
+	if user.Avatar == "" {
+		return nil
+	}
+
+	err := s.repo.SetAvatar(ctx, user.ID, "")
+	diff := map[string]domain.FieldChange{"avatar_url": {Old: user.AvatarURL, New: domain.AvatarURL(user.ID, "")}}
+	s.audit(ctx, domain.AuditUserUpdate, user.ID, user.ID, err, diff)
+	if err != nil {
+		return err
+	}
+
+	s.deleteAvatarFiles(ctx, user.Avatar)
+	return nil
+}
+
+// GetAvatar returns the avatar variant of the size, or the avatar
+// generated from the user's initials when there is none.
+func (s service) GetAvatar(ctx context.Context, id string, size int) (*storage.Object, error) {
+	if size == 0 {
+		size = defaultAvatarSize
+	}
+	if !validAvatarSize(size) {
+		return nil, ErrInvalidAvatarSize{size}
+	}
+
+	user, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Avatar != "" && s.blobs != nil {
+		o, err := s.blobs.Get(ctx, avatarKey(user.Avatar, size))
+		if err == nil {
+			return o, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	svg := initialsAvatar(user, size)
+	return &storage.Object{
+		Body:        io.NopCloser(bytes.NewReader(svg)),
+		ContentType: "image/svg+xml",
+		Size:        int64(len(svg)),
+	}, nil
+}
+
+// deleteAvatarFiles removes the variants of an avatar that is no longer
+// used, what can't be removed is only logged.
+func (s service) deleteAvatarFiles(ctx context.Context, avatar string) {
+	for _, size := range avatarSizes {
+		if err := s.blobs.Delete(ctx, avatarKey(avatar, size)); err != nil {
+			s.log.Println(err)
+		}
+	}
+}
+
+func avatarKey(avatar string, size int) string {
+	return fmt.Sprintf("%s/%d.png", avatar, size)
+}
+
+func validAvatarSize(size int) bool {
+	for _, s := range avatarSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// initialsAvatar draws the initials of the user on a colored circle.
+func initialsAvatar(user *domain.User, size int) []byte {
+	initials := initial(user.FirstName) + initial(user.LastName)
+	if initials == "" {
+		initials = initial(user.Username)
+	}
+	if initials == "" {
+		initials = "?"
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(user.ID))
+	color := avatarColors[h.Sum32()%uint32(len(avatarColors))]
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 100 100">`+
+		`<circle cx="50" cy="50" r="50" fill="%s"/>`+
+		`<text x="50" y="50" dy=".35em" text-anchor="middle" font-family="Helvetica, Arial, sans-serif" font-size="40" fill="#ffffff">%s</text>`+
+		`</svg>`, size, size, color, html.EscapeString(initials)))
+}
+
+func initial(name string) string {
+	r, _ := utf8.DecodeRuneInString(strings.TrimSpace(name))
+	if r == utf8.RuneError || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+		return ""
+	}
+	return string(unicode.ToUpper(r))
+}
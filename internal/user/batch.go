@@ -0,0 +1,143 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+)
+
+type BatchStatus string
+
+const (
+	BatchOK         BatchStatus = "ok"
+	BatchFailed     BatchStatus = "failed"
+	BatchRolledBack BatchStatus = "rolled_back"
+	BatchSkipped    BatchStatus = "skipped"
+)
+
+const maxBatchSize = 1000
+
+type (
+	// BatchOp is one operation of a batch. Data holds a CreateReq for
+	// create and an UpdateReq for update, delete only needs the ID.
+	BatchOp struct {
+		Op      string          `json:"op"`
+		ID      string          `json:"id,omitempty"`
+		Version *int64          `json:"version,omitempty"`
+		Data    json.RawMessage `json:"data,omitempty"`
+	}
+
+	BatchResult struct {
+		Index  int          `json:"index"`
+		Op     string       `json:"op"`
+		ID     string       `json:"id,omitempty"`
+		Status BatchStatus  `json:"status"`
+		Error  string       `json:"error,omitempty"`
+		User   *domain.User `json:"user,omitempty"`
+	}
+)
+
+// errBatchAborted rolls back an atomic batch once an operation failed.
+var errBatchAborted = errors.New("batch aborted")
+
+// Batch runs the operations in order. An atomic batch runs in a single
+// transaction that is rolled back on the first failure, otherwise every
+// operation is applied on its own and failures don't stop the rest.
+func (s service) Batch(ctx context.Context, ops []BatchOp, atomic bool) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = BatchResult{Index: i, Op: op.Op, ID: op.ID, Status: BatchSkipped}
+	}
+
+	if !atomic {
+		for i, op := range ops {
+			s.runBatchOp(ctx, op, &results[i])
+		}
+		return results, nil
+	}
+
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		for i, op := range ops {
+			if !s.runBatchOp(ctx, op, &results[i]) {
+				return errBatchAborted
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		for i := range results {
+			if results[i].Status == BatchOK {
+				results[i].Status = BatchRolledBack
+				results[i].User = nil
+			}
+		}
+		if !errors.Is(err, errBatchAborted) {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+func (s service) runBatchOp(ctx context.Context, op BatchOp, result *BatchResult) bool {
+	err := s.applyBatchOp(ctx, op, result)
+	if err != nil {
+		result.Status = BatchFailed
+		result.Error = i18n.Localize(ctx, err)
+		return false
+	}
+
+	result.Status = BatchOK
+	return true
+}
+
+func (s service) applyBatchOp(ctx context.Context, op BatchOp, result *BatchResult) error {
+	switch op.Op {
+	case "create":
+		var req CreateReq
+		if err := json.Unmarshal(op.Data, &req); err != nil {
+			return ErrInvalidBatchOp{i18n.Msg("batch_invalid_create", err.Error())}
+		}
+
+		if err := validateCreate(req); err != nil {
+			return err
+		}
+
+		user, err := s.Create(ctx, req.FirstName, req.LastName, req.Email, req.Phone, req.Username, req.Password, req.Attributes)
+		if err != nil {
+			return err
+		}
+
+		user.Password = ""
+		result.ID, result.User = user.ID, user
+		return nil
+
+	case "update":
+		if op.ID == "" {
+			return ErrInvalidBatchOp{i18n.Msg("batch_id_required")}
+		}
+
+		var req UpdateReq
+		if err := json.Unmarshal(op.Data, &req); err != nil {
+			return ErrInvalidBatchOp{i18n.Msg("batch_invalid_update", err.Error())}
+		}
+
+		if err := validateNames(req.FirstName, req.LastName); err != nil {
+			return err
+		}
+
+		return s.Update(ctx, op.ID, req.FirstName, req.LastName, req.Email, req.Phone, req.Attributes, op.Version)
+
+	case "delete":
+		if op.ID == "" {
+			return ErrInvalidBatchOp{i18n.Msg("batch_id_required")}
+		}
+		return s.Delete(ctx, op.ID)
+	}
+
+	return ErrInvalidBatchOp{i18n.Msg("unsupported_operation", op.Op)}
+}
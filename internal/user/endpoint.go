@@ -2,9 +2,17 @@ package user
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"fmt"
-
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-app-users-lab/pkg/imaging"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey"
 	"github.com/ncostamagna/go-http-utils/meta"
 	"github.com/ncostamagna/go-http-utils/response"
 )
@@ -13,16 +21,64 @@ type (
 	Controller func(ctx context.Context, request interface{}) (interface{}, error)
 
 	Endpoints struct {
-		Create     Controller
-		Login      Controller
-		Login2FA   Controller
-		Create2FA  Controller
-		TwoFa      Controller
-		LoginTwoFa Controller
-		Get        Controller
-		GetAll     Controller
-		Update     Controller
-		Delete     Controller
+		Create       Controller
+		Login        Controller
+		Login2FA     Controller
+		Create2FA    Controller
+		TwoFa        Controller
+		LoginTwoFa   Controller
+		Get          Controller
+		GetAll       Controller
+		Search       Controller
+		Update       Controller
+		Replace      Controller
+		Delete       Controller
+		GetDeleted   Controller
+		Restore      Controller
+		Purge        Controller
+		Import       Controller
+		GetImport    Controller
+		Export       Controller
+		Batch        Controller
+		GetLogins    Controller
+		NotMe        Controller
+		GetDevices   Controller
+		RevokeDevice Controller
+
+		BeginPasskeyRegistration  Controller
+		FinishPasskeyRegistration Controller
+		GetPasskeys               Controller
+		DeletePasskey             Controller
+		BeginPasskey2FA           Controller
+		Login2FAPasskey           Controller
+		BeginPasskeyLogin         Controller
+		LoginPasskey              Controller
+
+		RequestMagicLink Controller
+		LoginMagicLink   Controller
+
+		SendLoginCode      Controller
+		SetPreferredFactor Controller
+		GetFactors         Controller
+		AddFactor          Controller
+		ConfirmFactor      Controller
+		RenameFactor       Controller
+		RemoveFactor       Controller
+
+		SetAvatar    Controller
+		DeleteAvatar Controller
+		GetAvatar    Controller
+
+		GetMe    Controller
+		UpdateMe Controller
+
+		GetPreferences    Controller
+		UpdatePreferences Controller
+
+		GetAttributes   Controller
+		CreateAttribute Controller
+		UpdateAttribute Controller
+		DeleteAttribute Controller
 	}
 
 	Create2FAReq struct {
@@ -30,12 +86,19 @@ type (
 	}
 
 	Login2FAReq struct {
-		Token string
-		Code  string `json:"code"`
+		Token          string
+		FactorID       string `json:"factor_id"`
+		Code           string `json:"code"`
+		RememberDevice bool   `json:"remember_device"`
 	}
 
+	// Create2FARes has the QR as a base64 PNG data URI, a short
+	// lived link to it and the otpauth URI it encodes.
 	Create2FARes struct {
-		QR string
+		QR       string `json:"qr"`
+		QRURL    string `json:"qr_url,omitempty"`
+		URI      string `json:"otpauth_uri"`
+		FactorID string `json:"factor_id"`
 	}
 
 	CreateReq struct {
@@ -45,11 +108,15 @@ type (
 		Phone     string `json:"phone"`
 		Username  string `json:"username"`
 		Password  string `json:"password"`
+		// Attributes are the custom attributes, as their JSON type
+		// or as a string.
+		Attributes map[string]interface{} `json:"attributes"`
 	}
 
 	LoginReq struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		TrustedDevice string `json:"trusted_device"`
 	}
 
 	GetReq struct {
@@ -57,24 +124,118 @@ type (
 	}
 
 	GetAllReq struct {
-		FirstName string
-		LastName  string
-		Limit     int
-		Page      int
+		IDs         []string
+		FirstName   string
+		LastName    string
+		Username    string
+		Email       string
+		Phone       string
+		TwoFActive  *bool
+		TwoFStatus  string
+		Attributes  map[string]string
+		CreatedFrom *time.Time
+		CreatedTo   *time.Time
+		UpdatedFrom *time.Time
+		UpdatedTo   *time.Time
+		Deleted     *bool
+		Sort        []Sort
+		Limit       int
+		Page        int
+	}
+
+	SearchReq struct {
+		Query string
+		Limit int
+		Page  int
 	}
 
+	// UpdateReq only changes the attributes it lists, a null
+	// attribute removes its value.
 	UpdateReq struct {
-		ID        string
-		FirstName *string `json:"first_name"`
-		LastName  *string `json:"last_name"`
-		Email     *string `json:"email"`
-		Phone     *string `json:"phone"`
+		ID         string
+		Version    *int64
+		FirstName  *string                `json:"first_name"`
+		LastName   *string                `json:"last_name"`
+		Email      *string                `json:"email"`
+		Phone      *string                `json:"phone"`
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+
+	ReplaceReq struct {
+		ID         string
+		Version    *int64
+		FirstName  string                 `json:"first_name"`
+		LastName   string                 `json:"last_name"`
+		Email      string                 `json:"email"`
+		Phone      string                 `json:"phone"`
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+
+	MergePatchReq struct {
+		ID      string
+		Version *int64
+		Patch   map[string]json.RawMessage
+	}
+
+	JSONPatchReq struct {
+		ID      string
+		Version *int64
+		Ops     []PatchOp
+	}
+
+	PatchOp struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  string          `json:"from,omitempty"`
+		Value json.RawMessage `json:"value,omitempty"`
 	}
 
 	DeleteReq struct {
 		ID string
 	}
 
+	RestoreReq struct {
+		ID string
+	}
+
+	PurgeReq struct {
+		ID string
+	}
+
+	ImportReq struct {
+		Rows   []ImportRow
+		DryRun bool
+	}
+
+	GetImportReq struct {
+		ID string
+	}
+
+	BatchReq struct {
+		Atomic     bool      `json:"atomic"`
+		Operations []BatchOp `json:"operations"`
+	}
+
+	BatchRes struct {
+		Atomic    bool          `json:"atomic"`
+		Committed bool          `json:"committed"`
+		Results   []BatchResult `json:"results"`
+	}
+
+	ExportReq struct {
+		GetAllReq
+		Format  string
+		Columns []string
+	}
+
+	// ExportRes is written straight to the client by Write, so the
+	// users are never held in memory.
+	ExportRes struct {
+		ContentType string
+		Filename    string
+		Write       func(w io.Writer) error
+	}
+
 	Response struct {
 		Status int         `json:"status"`
 		Data   interface{} `json:"data,omitempty"`
@@ -82,49 +243,290 @@ type (
 		Meta   *meta.Meta  `json:"meta,omitempty"`
 	}
 
+	GetLoginsReq struct {
+		Token string
+		Limit int
+		Page  int
+	}
+
+	NotMeReq struct {
+		Token   string
+		LoginID string
+	}
+
+	GetDevicesReq struct {
+		Token string
+	}
+
+	RevokeDeviceReq struct {
+		Token    string
+		DeviceID string
+	}
+
+	PasskeyReq struct {
+		Token string
+	}
+
+	// FinishPasskeyRegistrationReq carries the raw navigator.credentials.create answer.
+	FinishPasskeyRegistrationReq struct {
+		Token       string
+		ChallengeID string
+		Name        string
+		Response    []byte
+	}
+
+	DeletePasskeyReq struct {
+		Token     string
+		PasskeyID string
+	}
+
+	// Login2FAPasskeyReq carries the raw navigator.credentials.get answer.
+	Login2FAPasskeyReq struct {
+		Token          string
+		ChallengeID    string
+		RememberDevice bool
+		Response       []byte
+	}
+
+	LoginPasskeyReq struct {
+		ChallengeID string
+		Response    []byte
+	}
+
+	MagicLinkReq struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+
+	// MagicLinkRes keeps the browser binding out of the body,
+	// the transport hands it to the browser.
+	MagicLinkRes struct {
+		Binding string `json:"-"`
+	}
+
+	LoginMagicLinkReq struct {
+		Token         string `json:"token"`
+		TrustedDevice string `json:"trusted_device"`
+		Binding       string `json:"-"`
+	}
+
+	FactorReq struct {
+		Token    string
+		FactorID string `json:"factor_id"`
+	}
+
+	AddFactorReq struct {
+		Token string
+		Type  string `json:"type"`
+		Label string `json:"label"`
+	}
+
+	ConfirmFactorReq struct {
+		Token    string
+		FactorID string
+		Code     string `json:"code"`
+	}
+
+	RenameFactorReq struct {
+		Token    string
+		FactorID string
+		Label    string `json:"label"`
+	}
+
+	RemoveFactorReq struct {
+		Token    string
+		FactorID string
+	}
+
+	// SetAvatarReq streams the uploaded image, it is read while
+	// the request is served.
+	SetAvatarReq struct {
+		Token string
+		Image io.Reader
+	}
+
+	GetAvatarReq struct {
+		ID   string
+		Size int
+	}
+
+	GetMeReq struct {
+		Token string
+	}
+
+	UpdateMeReq struct {
+		Token      string
+		Version    *int64
+		FirstName  *string                `json:"first_name"`
+		LastName   *string                `json:"last_name"`
+		Phone      *string                `json:"phone"`
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+
+	GetPreferencesReq struct {
+		Token string
+	}
+
+	// UpdatePreferencesReq leaves out the preferences it doesn't
+	// list, an empty one or a null channel resets it to the default.
+	UpdatePreferencesReq struct {
+		Token         string
+		Locale        *string          `json:"locale"`
+		Timezone      *string          `json:"timezone"`
+		DateFormat    *string          `json:"date_format"`
+		Notifications map[string]*bool `json:"notifications"`
+	}
+
+	GetAttributesReq struct{}
+
+	// AttributeReq defines an attribute, on update the name
+	// comes from the path and the type can be left out.
+	AttributeReq struct {
+		Name       string   `json:"name"`
+		Label      string   `json:"label"`
+		Type       string   `json:"type"`
+		Required   bool     `json:"required"`
+		Visibility string   `json:"visibility"`
+		Pattern    string   `json:"pattern"`
+		Min        *float64 `json:"min"`
+		Max        *float64 `json:"max"`
+		Options    []string `json:"options"`
+	}
+
+	DeleteAttributeReq struct {
+		Name string
+	}
+
 	Config struct {
 		LimPageDef string
 	}
 )
 
+func (req GetAllReq) filters() Filters {
+	return Filters{
+		IDs:         req.IDs,
+		FirstName:   req.FirstName,
+		LastName:    req.LastName,
+		Username:    req.Username,
+		Email:       req.Email,
+		Phone:       req.Phone,
+		TwoFActive:  req.TwoFActive,
+		TwoFStatus:  req.TwoFStatus,
+		Attributes:  req.Attributes,
+		CreatedFrom: req.CreatedFrom,
+		CreatedTo:   req.CreatedTo,
+		UpdatedFrom: req.UpdatedFrom,
+		UpdatedTo:   req.UpdatedTo,
+		Deleted:     req.Deleted,
+		Sort:        req.Sort,
+	}
+}
+
 func MakeEndpoints(s Service, config Config) Endpoints {
 
 	return Endpoints{
-		Create:    makeCreateEndpoint(s),
-		Login:     makeLogin(s),
-		Login2FA:  makeLogin2FA(s),
-		Create2FA: makeCreate2FA(s),
-		Get:       makeGetEndpoint(s),
-		GetAll:    makeGetAllEndpoint(s, config),
-		Update:    makeUpdateEndpoint(s),
-		Delete:    makeDeleteEndpoint(s),
+		Create:       makeCreateEndpoint(s),
+		Login:        makeLogin(s),
+		Login2FA:     makeLogin2FA(s),
+		Create2FA:    makeCreate2FA(s),
+		Get:          makeGetEndpoint(s),
+		GetAll:       makeGetAllEndpoint(s, config),
+		Search:       makeSearchEndpoint(s, config),
+		Update:       makeUpdateEndpoint(s),
+		Replace:      makeReplaceEndpoint(s),
+		Delete:       makeDeleteEndpoint(s),
+		GetDeleted:   makeGetDeletedEndpoint(s, config),
+		Restore:      makeRestoreEndpoint(s),
+		Purge:        makePurgeEndpoint(s),
+		Import:       makeImportEndpoint(s),
+		GetImport:    makeGetImportEndpoint(s),
+		Export:       makeExportEndpoint(s),
+		Batch:        makeBatchEndpoint(s),
+		GetLogins:    makeGetLoginsEndpoint(s, config),
+		NotMe:        makeNotMeEndpoint(s),
+		GetDevices:   makeGetDevicesEndpoint(s),
+		RevokeDevice: makeRevokeDeviceEndpoint(s),
+
+		BeginPasskeyRegistration:  makeBeginPasskeyRegistrationEndpoint(s),
+		FinishPasskeyRegistration: makeFinishPasskeyRegistrationEndpoint(s),
+		GetPasskeys:               makeGetPasskeysEndpoint(s),
+		DeletePasskey:             makeDeletePasskeyEndpoint(s),
+		BeginPasskey2FA:           makeBeginPasskey2FAEndpoint(s),
+		Login2FAPasskey:           makeLogin2FAPasskeyEndpoint(s),
+		BeginPasskeyLogin:         makeBeginPasskeyLoginEndpoint(s),
+		LoginPasskey:              makeLoginPasskeyEndpoint(s),
+
+		RequestMagicLink: makeRequestMagicLinkEndpoint(s),
+		LoginMagicLink:   makeLoginMagicLinkEndpoint(s),
+
+		SendLoginCode:      makeSendLoginCodeEndpoint(s),
+		SetPreferredFactor: makeSetPreferredFactorEndpoint(s),
+		GetFactors:         makeGetFactorsEndpoint(s),
+		AddFactor:          makeAddFactorEndpoint(s),
+		ConfirmFactor:      makeConfirmFactorEndpoint(s),
+		RenameFactor:       makeRenameFactorEndpoint(s),
+		RemoveFactor:       makeRemoveFactorEndpoint(s),
+
+		SetAvatar:    makeSetAvatarEndpoint(s),
+		DeleteAvatar: makeDeleteAvatarEndpoint(s),
+		GetAvatar:    makeGetAvatarEndpoint(s),
+
+		GetMe:    makeGetMeEndpoint(s),
+		UpdateMe: makeUpdateMeEndpoint(s),
+
+		GetPreferences:    makeGetPreferencesEndpoint(s),
+		UpdatePreferences: makeUpdatePreferencesEndpoint(s),
+
+		GetAttributes:   makeGetAttributesEndpoint(s),
+		CreateAttribute: makeCreateAttributeEndpoint(s),
+		UpdateAttribute: makeUpdateAttributeEndpoint(s),
+		DeleteAttribute: makeDeleteAttributeEndpoint(s),
 	}
 
 }
 
-func makeCreateEndpoint(s Service) Controller {
-	return func(ctx context.Context, request interface{}) (interface{}, error) {
+func requestTooLarge(msg string) response.Response {
+	return &response.ErrorResponse{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: msg,
+	}
+}
 
-		req := request.(CreateReq)
+func preconditionFailed(msg string) response.Response {
+	return &response.ErrorResponse{
+		Status:  http.StatusPreconditionFailed,
+		Message: msg,
+	}
+}
 
-		if req.FirstName == "" {
-			return nil, response.BadRequest(ErrFirstNameRequired.Error())
-		}
+func tooManyRequests(msg string) response.Response {
+	return &response.ErrorResponse{
+		Status:  http.StatusTooManyRequests,
+		Message: msg,
+	}
+}
 
-		if req.LastName == "" {
-			return nil, response.BadRequest(ErrLastNameRequired.Error())
-		}
+func conflict(msg string) response.Response {
+	return &response.ErrorResponse{
+		Status:  http.StatusConflict,
+		Message: msg,
+	}
+}
 
-		if req.Username == "" {
-			return nil, response.BadRequest(ErrUsernameRequired.Error())
-		}
+func makeCreateEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(CreateReq)
 
-		if req.Password == "" {
-			return nil, response.BadRequest(ErrPasswordRequired.Error())
+		if err := validateCreate(req); err != nil {
+			return nil, response.BadRequest(i18n.Localize(ctx, err))
 		}
 
-		user, err := s.Create(ctx, req.FirstName, req.LastName, req.Email, req.Phone, req.Username, req.Password)
+		user, err := s.Create(ctx, req.FirstName, req.LastName, req.Email, req.Phone, req.Username, req.Password, req.Attributes)
 		if err != nil {
+			if isAttributeError(err) {
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
 			return nil, response.InternalServerError(err.Error())
 		}
 
@@ -137,7 +539,7 @@ func makeLogin(s Service) Controller {
 
 		req := request.(LoginReq)
 
-		user, err := s.Login(ctx, req.Username, req.Password)
+		user, err := s.Login(ctx, req.Username, req.Password, req.TrustedDevice)
 		if err != nil {
 			return nil, response.InternalServerError(err.Error())
 		}
@@ -156,9 +558,9 @@ func makeLogin2FA(s Service) Controller {
 			return nil, response.InternalServerError(err.Error())
 		}
 
-		login, err := s.Login2FA(ctx, user, req.Code)
+		login, err := s.Login2FA(ctx, user, req.FactorID, req.Code, req.RememberDevice)
 		if err != nil {
-			return nil, response.InternalServerError(err.Error())
+			return nil, factorError(ctx, err)
 		}
 
 		return response.OK("success", login, nil), nil
@@ -175,12 +577,16 @@ func makeCreate2FA(s Service) Controller {
 			return nil, response.InternalServerError(err.Error())
 		}
 
-		if err := s.Create2FA(ctx, user); err != nil {
+		enrollment, err := s.Create2FA(ctx, user)
+		if err != nil {
 			return nil, response.InternalServerError(err.Error())
 		}
 		return response.OK("success",
 			Create2FARes{
-				QR: fmt.Sprintf("./files/%s.png", user.ID),
+				QR:       enrollment.QR,
+				QRURL:    enrollment.QRURL,
+				URI:      enrollment.URI,
+				FactorID: enrollment.Factor.ID,
 			}, nil), nil
 	}
 }
@@ -190,13 +596,13 @@ func makeGetAllEndpoint(s Service, config Config) Controller {
 
 		req := request.(GetAllReq)
 
-		filters := Filters{
-			FirstName: req.FirstName,
-			LastName:  req.LastName,
-		}
+		filters := req.filters()
 
 		count, err := s.Count(ctx, filters)
 		if err != nil {
+			if isAttributeError(err) {
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
 			return nil, response.InternalServerError(err.Error())
 		}
 
@@ -213,6 +619,35 @@ func makeGetAllEndpoint(s Service, config Config) Controller {
 		return response.OK("success", users, meta), nil
 	}
 }
+
+func makeSearchEndpoint(s Service, config Config) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(SearchReq)
+
+		if strings.TrimSpace(req.Query) == "" {
+			return nil, response.BadRequest(i18n.Localize(ctx, ErrQueryRequired))
+		}
+
+		count, err := s.SearchCount(ctx, req.Query)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		meta, err := meta.New(req.Page, req.Limit, count, config.LimPageDef)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		users, err := s.Search(ctx, req.Query, meta.Offset(), meta.Limit())
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", users, meta), nil
+	}
+}
+
 func makeGetEndpoint(s Service) Controller {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 
@@ -222,7 +657,7 @@ func makeGetEndpoint(s Service) Controller {
 		if err != nil {
 
 			if errors.As(err, &ErrNotFound{}) {
-				return nil, response.NotFound(err.Error())
+				return nil, response.NotFound(i18n.Localize(ctx, err))
 			}
 
 			return nil, response.InternalServerError(err.Error())
@@ -234,28 +669,160 @@ func makeGetEndpoint(s Service) Controller {
 
 func makeUpdateEndpoint(s Service) Controller {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		req := request.(UpdateReq)
 
-		if req.FirstName != nil && *req.FirstName == "" {
-			return nil, response.BadRequest(ErrFirstNameRequired.Error())
-		}
+		switch req := request.(type) {
+		case MergePatchReq:
+			doc, attributes, err := applyMergePatch(req.Patch)
+			if err != nil {
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
+
+			firstName, lastName, email, phone := doc.updateFields()
+			return updateUser(ctx, s, req.ID, req.Version, firstName, lastName, email, phone, attributes)
+
+		case JSONPatchReq:
+			user, err := s.Get(ctx, req.ID)
+			if err != nil {
+				if errors.As(err, &ErrNotFound{}) {
+					return nil, response.NotFound(i18n.Localize(ctx, err))
+				}
+				return nil, response.InternalServerError(err.Error())
+			}
 
-		if req.LastName != nil && *req.LastName == "" {
-			return nil, response.BadRequest(ErrLastNameRequired.Error())
+			if req.Version != nil && *req.Version != user.Version {
+				return nil, preconditionFailed(i18n.Localize(ctx, ErrVersionMismatch))
+			}
+
+			doc := newPatchDoc(user)
+			attributes := make(map[string]interface{}, len(user.Attributes))
+			for name, v := range user.Attributes {
+				attributes[name] = v
+			}
+			if err := applyJSONPatch(doc, attributes, req.Ops); err != nil {
+				if errors.As(err, &ErrPatchTestFailed{}) {
+					return nil, conflict(i18n.Localize(ctx, err))
+				}
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
+
+			// the patch was applied to this version, it mustn't overwrite a newer one
+			firstName, lastName, email, phone := doc.updateFields()
+			return updateUser(ctx, s, req.ID, &user.Version, firstName, lastName, email, phone, attributeChanges(user.Attributes, attributes))
+
+		default:
+			r := request.(UpdateReq)
+			return updateUser(ctx, s, r.ID, r.Version, r.FirstName, r.LastName, r.Email, r.Phone, r.Attributes)
 		}
+	}
+}
 
-		err := s.Update(ctx, req.ID, req.FirstName, req.LastName, req.Email, req.Phone, nil, nil, nil)
-		if err != nil {
+func makeReplaceEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
 
+		req := request.(ReplaceReq)
+
+		user, err := s.Get(ctx, req.ID)
+		if err != nil {
 			if errors.As(err, &ErrNotFound{}) {
-				return nil, response.NotFound(err.Error())
+				return nil, response.NotFound(i18n.Localize(ctx, err))
 			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		if req.Version != nil && *req.Version != user.Version {
+			return nil, preconditionFailed(i18n.Localize(ctx, ErrVersionMismatch))
+		}
 
+		defs, err := s.GetAttributes(ctx)
+		if err != nil {
 			return nil, response.InternalServerError(err.Error())
 		}
 
-		return response.OK("success", nil, nil), nil
+		// the private attributes the caller isn't shown are left as they are
+		if !isAdmin(ctx) {
+			var visible []domain.Attribute
+			for _, def := range defs {
+				if def.Visibility != domain.AttributePrivate {
+					visible = append(visible, def)
+				}
+			}
+			defs = visible
+		}
+
+		attributes, err := replaceAttributes(defs, user.Attributes, req.Attributes)
+		if err != nil {
+			return nil, response.BadRequest(i18n.Localize(ctx, err))
+		}
+
+		// the attributes were replaced against this version, a newer one mustn't be overwritten
+		return updateUser(ctx, s, req.ID, &user.Version, &req.FirstName, &req.LastName, &req.Email, &req.Phone, attributes)
+	}
+}
+
+func updateUser(ctx context.Context, s Service, id string, version *int64, firstName, lastName, email, phone *string, attributes map[string]interface{}) (interface{}, error) {
+
+	if err := validateNames(firstName, lastName); err != nil {
+		return nil, response.BadRequest(i18n.Localize(ctx, err))
+	}
+
+	err := s.Update(ctx, id, firstName, lastName, email, phone, attributes, version)
+	if err != nil {
+		return nil, updateError(ctx, err)
+	}
+
+	return response.OK("success", nil, nil), nil
+}
+
+func updateError(ctx context.Context, err error) error {
+	switch {
+	case errors.As(err, &ErrNotFound{}):
+		return response.NotFound(i18n.Localize(ctx, err))
+	case errors.Is(err, ErrVersionMismatch):
+		return preconditionFailed(i18n.Localize(ctx, err))
+	case isAttributeError(err):
+		return response.BadRequest(i18n.Localize(ctx, err))
+	}
+	return response.InternalServerError(err.Error())
+}
+
+// isAttributeError tells whether err is about the attribute
+// values or filters sent by the client.
+func isAttributeError(err error) bool {
+	return errors.As(err, &ErrAttributeNotFound{}) || errors.As(err, &ErrInvalidAttributeValue{})
+}
+
+// validateCreate holds the rules every new user must pass,
+// whether it comes from create or from an import.
+func validateCreate(req CreateReq) error {
+
+	if err := validateNames(&req.FirstName, &req.LastName); err != nil {
+		return err
+	}
+
+	if req.Username == "" {
+		return ErrUsernameRequired
+	}
+
+	if req.Password == "" {
+		return ErrPasswordRequired
+	}
+
+	return nil
+}
+
+// validateNames applies the create rules to the names being set,
+// nil means the name is left untouched.
+func validateNames(firstName, lastName *string) error {
+
+	if firstName != nil && *firstName == "" {
+		return ErrFirstNameRequired
+	}
+
+	if lastName != nil && *lastName == "" {
+		return ErrLastNameRequired
 	}
+
+	return nil
 }
 
 func makeDeleteEndpoint(s Service) Controller {
@@ -268,7 +835,53 @@ func makeDeleteEndpoint(s Service) Controller {
 		if err != nil {
 
 			if errors.As(err, &ErrNotFound{}) {
-				return nil, response.NotFound(err.Error())
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeGetDeletedEndpoint(s Service, config Config) Controller {
+	getAll := makeGetAllEndpoint(s, config)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetAllReq)
+		deleted := true
+		req.Deleted = &deleted
+
+		return getAll(ctx, req)
+	}
+}
+
+func makeRestoreEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(RestoreReq)
+
+		if err := s.Restore(ctx, req.ID); err != nil {
+
+			if errors.As(err, &ErrNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makePurgeEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(PurgeReq)
+
+		if err := s.Purge(ctx, req.ID); err != nil {
+
+			if errors.As(err, &ErrNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
 			}
 			return nil, response.InternalServerError(err.Error())
 		}
@@ -276,3 +889,774 @@ func makeDeleteEndpoint(s Service) Controller {
 		return response.OK("success", nil, nil), nil
 	}
 }
+
+func makeImportEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(ImportReq)
+
+		if len(req.Rows) == 0 {
+			return nil, response.BadRequest(i18n.Localize(ctx, ErrImportEmpty))
+		}
+
+		job, err := s.Import(ctx, req.Rows, req.DryRun)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.Accepted("success", job, nil), nil
+	}
+}
+
+func makeGetImportEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetImportReq)
+
+		job, err := s.GetImport(ctx, req.ID)
+		if err != nil {
+			if errors.As(err, &ErrImportNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", job, nil), nil
+	}
+}
+
+func makeExportEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(ExportReq)
+
+		columns := req.Columns
+		if len(columns) == 0 {
+			columns = defaultExportColumns
+		}
+		for _, c := range columns {
+			if _, ok := exportColumns[c]; !ok {
+				return nil, response.BadRequest(i18n.Localize(ctx, ErrInvalidExportColumn{c}))
+			}
+		}
+
+		newExporter, ok := exporters[req.Format]
+		if !ok {
+			return nil, response.BadRequest(i18n.Localize(ctx, ErrInvalidExportFormat{req.Format}))
+		}
+
+		filters := req.filters()
+		return ExportRes{
+			ContentType: exportContentTypes[req.Format],
+			Filename:    "users." + req.Format,
+			Write: func(w io.Writer) error {
+				e := newExporter(w, columns)
+				if err := e.begin(); err != nil {
+					return err
+				}
+				if err := s.Export(ctx, filters, e.write); err != nil {
+					return err
+				}
+				return e.end()
+			},
+		}, nil
+	}
+}
+
+func makeBatchEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(BatchReq)
+
+		if len(req.Operations) == 0 {
+			return nil, response.BadRequest(i18n.Localize(ctx, ErrBatchEmpty))
+		}
+
+		if len(req.Operations) > maxBatchSize {
+			return nil, response.BadRequest(i18n.Localize(ctx, ErrBatchTooLarge))
+		}
+
+		results, err := s.Batch(ctx, req.Operations, req.Atomic)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		committed := true
+		if req.Atomic {
+			for _, r := range results {
+				if r.Status != BatchOK {
+					committed = false
+					break
+				}
+			}
+		}
+
+		return response.OK("success", BatchRes{
+			Atomic:    req.Atomic,
+			Committed: committed,
+			Results:   results,
+		}, nil), nil
+	}
+}
+
+func makeGetLoginsEndpoint(s Service, config Config) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetLoginsReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		count, err := s.CountLogins(ctx, user.ID)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		meta, err := meta.New(req.Page, req.Limit, count, config.LimPageDef)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		logins, err := s.GetLogins(ctx, user.ID, meta.Offset(), meta.Limit())
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", logins, meta), nil
+	}
+}
+
+func makeNotMeEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(NotMeReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.RevokeLogin(ctx, user.ID, req.LoginID); err != nil {
+			if errors.As(err, &ErrLoginNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeGetDevicesEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetDevicesReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		devices, err := s.GetTrustedDevices(ctx, user.ID)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", devices, nil), nil
+	}
+}
+
+func makeRevokeDeviceEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(RevokeDeviceReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.RevokeTrustedDevice(ctx, user.ID, req.DeviceID); err != nil {
+			if errors.As(err, &ErrTrustedDeviceNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeBeginPasskeyRegistrationEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(PasskeyReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		ceremony, err := s.BeginPasskeyRegistration(ctx, user)
+		if err != nil {
+			return nil, passkeyError(ctx, err)
+		}
+
+		return response.OK("success", ceremony, nil), nil
+	}
+}
+
+func makeFinishPasskeyRegistrationEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(FinishPasskeyRegistrationReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		p, err := s.FinishPasskeyRegistration(ctx, user, req.ChallengeID, req.Name, req.Response)
+		if err != nil {
+			return nil, passkeyError(ctx, err)
+		}
+
+		return response.Created("success", p, nil), nil
+	}
+}
+
+func makeGetPasskeysEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(PasskeyReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		passkeys, err := s.GetPasskeys(ctx, user.ID)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", passkeys, nil), nil
+	}
+}
+
+func makeDeletePasskeyEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(DeletePasskeyReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.DeletePasskey(ctx, user.ID, req.PasskeyID); err != nil {
+			return nil, passkeyError(ctx, err)
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeBeginPasskey2FAEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(PasskeyReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, false)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		ceremony, err := s.BeginPasskey2FA(ctx, user)
+		if err != nil {
+			return nil, passkeyError(ctx, err)
+		}
+
+		return response.OK("success", ceremony, nil), nil
+	}
+}
+
+func makeLogin2FAPasskeyEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(Login2FAPasskeyReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, false)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		login, err := s.Login2FAPasskey(ctx, user, req.ChallengeID, req.Response, req.RememberDevice)
+		if err != nil {
+			return nil, passkeyError(ctx, err)
+		}
+
+		return response.OK("success", login, nil), nil
+	}
+}
+
+func makeBeginPasskeyLoginEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		ceremony, err := s.BeginPasskeyLogin(ctx)
+		if err != nil {
+			return nil, passkeyError(ctx, err)
+		}
+
+		return response.OK("success", ceremony, nil), nil
+	}
+}
+
+func makeLoginPasskeyEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(LoginPasskeyReq)
+
+		login, err := s.LoginPasskey(ctx, req.ChallengeID, req.Response)
+		if err != nil {
+			return nil, passkeyError(ctx, err)
+		}
+
+		return response.OK("success", login, nil), nil
+	}
+}
+
+// passkeyError maps the errors of the WebAuthn ceremonies, a response the
+// authenticator got wrong is the client's fault, a cloned one is rejected.
+func passkeyError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrPasskeysDisabled), errors.As(err, &ErrPasskeyNotFound{}), errors.As(err, &ErrChallengeNotFound{}):
+		return response.NotFound(i18n.Localize(ctx, err))
+	case errors.Is(err, passkey.ErrCloned):
+		return response.Unauthorized(i18n.Localize(ctx, err))
+	case errors.Is(err, ErrPasskeyInvalid):
+		return response.BadRequest(i18n.Localize(ctx, err))
+	}
+	return response.InternalServerError(err.Error())
+}
+
+func makeRequestMagicLinkEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(MagicLinkReq)
+
+		binding, err := s.RequestMagicLink(ctx, req.Username, req.Email)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrMagicLinkDisabled):
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			case errors.Is(err, ErrMagicLinkRecipientRequired):
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.Accepted("success", MagicLinkRes{Binding: binding}, nil), nil
+	}
+}
+
+func makeLoginMagicLinkEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(LoginMagicLinkReq)
+
+		login, err := s.LoginMagicLink(ctx, req.Token, req.Binding, req.TrustedDevice)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrMagicLinkDisabled):
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			case errors.Is(err, ErrMagicLinkTokenRequired):
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			case errors.Is(err, ErrMagicLinkInvalid), errors.Is(err, ErrMagicLinkBrowser):
+				return nil, response.Unauthorized(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", login, nil), nil
+	}
+}
+
+func makeSendLoginCodeEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(FactorReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, false)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.SendLoginCode(ctx, user, req.FactorID); err != nil {
+			return nil, factorError(ctx, err)
+		}
+
+		return response.Accepted("success", nil, nil), nil
+	}
+}
+
+func makeGetFactorsEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(Create2FAReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		factors, err := s.GetFactors(ctx, user)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", factors, nil), nil
+	}
+}
+
+func makeAddFactorEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(AddFactorReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		enrollment, err := s.AddFactor(ctx, user, req.Type, req.Label)
+		if err != nil {
+			return nil, factorError(ctx, err)
+		}
+
+		return response.Created("success", enrollment, nil), nil
+	}
+}
+
+func makeConfirmFactorEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(ConfirmFactorReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.ConfirmFactor(ctx, user, req.FactorID, req.Code); err != nil {
+			return nil, factorError(ctx, err)
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeRenameFactorEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(RenameFactorReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.RenameFactor(ctx, user, req.FactorID, req.Label); err != nil {
+			return nil, factorError(ctx, err)
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeRemoveFactorEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(RemoveFactorReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.RemoveFactor(ctx, user, req.FactorID); err != nil {
+			return nil, factorError(ctx, err)
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeSetPreferredFactorEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(FactorReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.SetPreferredFactor(ctx, user, req.FactorID); err != nil {
+			return nil, factorError(ctx, err)
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+// factorError maps the errors of the second factors, a wrong code is
+// rejected, asking for too many codes is throttled and a missing one or
+// an unknown factor is a bad request.
+func factorError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		return response.Unauthorized(i18n.Localize(ctx, err))
+	case errors.Is(err, ErrTooManyCodes):
+		return tooManyRequests(i18n.Localize(ctx, err))
+	case errors.As(err, &ErrFactorNotFound{}):
+		return response.NotFound(i18n.Localize(ctx, err))
+	case errors.Is(err, ErrCodeRequired), errors.Is(err, ErrEmailRequired), errors.Is(err, ErrFactorApproved),
+		errors.Is(err, ErrFactorLabelTooLong), errors.As(err, &ErrInvalidFactor{}), errors.As(err, &ErrFactorExists{}):
+		return response.BadRequest(i18n.Localize(ctx, err))
+	}
+	return response.InternalServerError(err.Error())
+}
+
+func makeSetAvatarEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(SetAvatarReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if req.Image == nil {
+			return nil, response.BadRequest(i18n.Localize(ctx, ErrAvatarRequired))
+		}
+
+		user, err = s.SetAvatar(ctx, user, req.Image)
+		if err != nil {
+			switch {
+			case errors.Is(err, imaging.ErrTooLarge):
+				return nil, requestTooLarge(i18n.Localize(ctx, err))
+			case errors.Is(err, imaging.ErrUnsupportedType), errors.Is(err, imaging.ErrTooManyPixels):
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", user, nil), nil
+	}
+}
+
+func makeDeleteAvatarEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(Create2FAReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		if err := s.DeleteAvatar(ctx, user); err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+// makeGetAvatarEndpoint returns the avatar object, the transport writes it as is.
+func makeGetAvatarEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetAvatarReq)
+
+		o, err := s.GetAvatar(ctx, req.ID, req.Size)
+		if err != nil {
+			switch {
+			case errors.As(err, &ErrNotFound{}):
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			case errors.As(err, &ErrInvalidAvatarSize{}):
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return o, nil
+	}
+}
+
+func makeGetMeEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetMeReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		user, err = s.GetMe(ctx, user)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", user, nil), nil
+	}
+}
+
+func makeUpdateMeEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(UpdateMeReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		if err := validateNames(req.FirstName, req.LastName); err != nil {
+			return nil, response.BadRequest(i18n.Localize(ctx, err))
+		}
+
+		if err := s.UpdateMe(ctx, user, req.FirstName, req.LastName, req.Phone, req.Attributes, req.Version); err != nil {
+			return nil, updateError(ctx, err)
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeGetAttributesEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		attributes, err := s.GetAttributes(ctx)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", attributes, nil), nil
+	}
+}
+
+func makeCreateAttributeEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(AttributeReq)
+
+		a := req.attribute()
+		if err := s.CreateAttribute(ctx, a); err != nil {
+			return nil, attributeError(ctx, err)
+		}
+
+		return response.Created("success", a, nil), nil
+	}
+}
+
+func makeUpdateAttributeEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(AttributeReq)
+
+		a := req.attribute()
+		if err := s.UpdateAttribute(ctx, a); err != nil {
+			return nil, attributeError(ctx, err)
+		}
+
+		return response.OK("success", a, nil), nil
+	}
+}
+
+func makeDeleteAttributeEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(DeleteAttributeReq)
+
+		if err := s.DeleteAttribute(ctx, req.Name); err != nil {
+			return nil, attributeError(ctx, err)
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func (req AttributeReq) attribute() *domain.Attribute {
+	return &domain.Attribute{
+		Name:       req.Name,
+		Label:      req.Label,
+		Type:       domain.AttributeType(req.Type),
+		Required:   req.Required,
+		Visibility: domain.AttributeVisibility(req.Visibility),
+		Pattern:    req.Pattern,
+		Min:        req.Min,
+		Max:        req.Max,
+		Options:    req.Options,
+	}
+}
+
+func attributeError(ctx context.Context, err error) error {
+	switch {
+	case errors.As(err, &ErrAttributeNotFound{}):
+		return response.NotFound(i18n.Localize(ctx, err))
+	case errors.As(err, &ErrAttributeExists{}):
+		return conflict(i18n.Localize(ctx, err))
+	case errors.As(err, &ErrInvalidAttribute{}):
+		return response.BadRequest(i18n.Localize(ctx, err))
+	}
+	return response.InternalServerError(err.Error())
+}
+
+func makeGetPreferencesEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetPreferencesReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		prefs, err := s.GetPreferences(ctx, user)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", prefs, nil), nil
+	}
+}
+
+func makeUpdatePreferencesEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(UpdatePreferencesReq)
+
+		user, err := s.GetUserByToken(ctx, req.Token, true)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		prefs, err := s.UpdatePreferences(ctx, user, req.Locale, req.Timezone, req.DateFormat, req.Notifications)
+		if err != nil {
+			switch {
+			case errors.As(err, &ErrInvalidLocale{}), errors.As(err, &ErrInvalidTimezone{}),
+				errors.As(err, &ErrInvalidDateFormat{}), errors.As(err, &ErrInvalidChannel{}):
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", prefs, nil), nil
+	}
+}
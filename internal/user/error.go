@@ -1,20 +1,321 @@
 package user
 
-import (
-	"errors"
-	"fmt"
-)
+import "github.com/ncostamagna/go-app-users-lab/pkg/i18n"
 
-var ErrFirstNameRequired = errors.New("first name is required")
-var ErrLastNameRequired = errors.New("last name is required")
-var ErrUsernameRequired = errors.New("username is required")
-var ErrPasswordRequired = errors.New("password is required")
-var ErrCodeRequired = errors.New("code is required")
+var ErrFirstNameRequired = i18n.NewError("first_name_required")
+var ErrLastNameRequired = i18n.NewError("last_name_required")
+var ErrUsernameRequired = i18n.NewError("username_required")
+var ErrPasswordRequired = i18n.NewError("password_required")
+var ErrCodeRequired = i18n.NewError("code_required")
+var ErrQueryRequired = i18n.NewError("query_required")
+var ErrImportEmpty = i18n.NewError("import_empty")
+var ErrBatchEmpty = i18n.NewError("batch_empty")
+var ErrBatchTooLarge = i18n.NewError("batch_too_large")
+var ErrVersionMismatch = i18n.NewError("version_mismatch")
+var ErrSessionRevoked = i18n.NewError("session_revoked")
+var ErrPasskeysDisabled = i18n.NewError("passkeys_disabled")
+var ErrPasskeyInvalid = i18n.NewError("passkey_invalid")
+var ErrMagicLinkDisabled = i18n.NewError("magic_link_disabled")
+var ErrMagicLinkRecipientRequired = i18n.NewError("magic_link_recipient_required")
+var ErrMagicLinkTokenRequired = i18n.NewError("magic_link_token_required")
+var ErrMagicLinkInvalid = i18n.NewError("magic_link_invalid")
+var ErrInvalidCode = i18n.NewError("invalid_code")
+var ErrTooManyCodes = i18n.NewError("too_many_codes")
+var ErrEmailRequired = i18n.NewError("email_required")
+var ErrMagicLinkBrowser = i18n.NewError("magic_link_browser")
+var ErrFactorApproved = i18n.NewError("factor_approved")
+var ErrFactorLabelTooLong = i18n.NewError("factor_label_too_long")
+var ErrAvatarRequired = i18n.NewError("avatar_required")
+var ErrStorageDisabled = i18n.NewError("storage_disabled")
+var ErrInvalidUserInfo = i18n.NewError("invalid_user_information")
+var ErrUnauthorizedUser = i18n.NewError("unauthorized_user")
 
 type ErrNotFound struct {
 	UserID string
 }
 
 func (e ErrNotFound) Error() string {
-	return fmt.Sprintf("user '%s' doesn't exist", e.UserID)
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrNotFound) Localized() i18n.Message {
+	return i18n.Msg("user_not_found", e.UserID)
+}
+
+type ErrInvalidSort struct {
+	Field string
+}
+
+func (e ErrInvalidSort) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidSort) Localized() i18n.Message {
+	return i18n.Msg("invalid_sort", e.Field)
+}
+
+type ErrInvalidPatch struct {
+	Reason i18n.Message
+}
+
+func (e ErrInvalidPatch) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidPatch) Localized() i18n.Message {
+	return i18n.Msg("invalid_patch", e.Reason)
+}
+
+type ErrPatchTestFailed struct {
+	Path string
+}
+
+func (e ErrPatchTestFailed) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrPatchTestFailed) Localized() i18n.Message {
+	return i18n.Msg("patch_test_failed", e.Path)
+}
+
+type ErrImportNotFound struct {
+	ImportID string
+}
+
+func (e ErrImportNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrImportNotFound) Localized() i18n.Message {
+	return i18n.Msg("import_not_found", e.ImportID)
+}
+
+type ErrInvalidExportColumn struct {
+	Column string
+}
+
+func (e ErrInvalidExportColumn) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidExportColumn) Localized() i18n.Message {
+	return i18n.Msg("invalid_export_column", e.Column)
+}
+
+type ErrInvalidExportFormat struct {
+	Format string
+}
+
+func (e ErrInvalidExportFormat) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidExportFormat) Localized() i18n.Message {
+	return i18n.Msg("invalid_export_format", e.Format)
+}
+
+type ErrInvalidBatchOp struct {
+	Reason i18n.Message
+}
+
+func (e ErrInvalidBatchOp) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidBatchOp) Localized() i18n.Message {
+	return i18n.Msg("invalid_batch_op", e.Reason)
+}
+
+type ErrLoginNotFound struct {
+	LoginID string
+}
+
+func (e ErrLoginNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrLoginNotFound) Localized() i18n.Message {
+	return i18n.Msg("login_not_found", e.LoginID)
+}
+
+type ErrTrustedDeviceNotFound struct {
+	DeviceID string
+}
+
+func (e ErrTrustedDeviceNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrTrustedDeviceNotFound) Localized() i18n.Message {
+	return i18n.Msg("trusted_device_not_found", e.DeviceID)
+}
+
+type ErrPasskeyNotFound struct {
+	PasskeyID string
+}
+
+func (e ErrPasskeyNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrPasskeyNotFound) Localized() i18n.Message {
+	return i18n.Msg("passkey_not_found", e.PasskeyID)
+}
+
+type ErrChallengeNotFound struct {
+	ChallengeID string
+}
+
+func (e ErrChallengeNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrChallengeNotFound) Localized() i18n.Message {
+	return i18n.Msg("challenge_not_found", e.ChallengeID)
+}
+
+type ErrInvalidFactor struct {
+	Factor string
+}
+
+func (e ErrInvalidFactor) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidFactor) Localized() i18n.Message {
+	return i18n.Msg("invalid_factor", e.Factor)
+}
+
+type ErrFactorNotFound struct {
+	FactorID string
+}
+
+func (e ErrFactorNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrFactorNotFound) Localized() i18n.Message {
+	return i18n.Msg("factor_not_found", e.FactorID)
+}
+
+type ErrFactorExists struct {
+	Type string
+}
+
+func (e ErrFactorExists) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrFactorExists) Localized() i18n.Message {
+	return i18n.Msg("factor_exists", e.Type)
+}
+
+type ErrInvalidAvatarSize struct {
+	Size int
+}
+
+func (e ErrInvalidAvatarSize) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidAvatarSize) Localized() i18n.Message {
+	return i18n.Msg("invalid_avatar_size", e.Size)
+}
+
+type ErrAttributeNotFound struct {
+	Name string
+}
+
+func (e ErrAttributeNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrAttributeNotFound) Localized() i18n.Message {
+	return i18n.Msg("attribute_not_found", e.Name)
+}
+
+type ErrAttributeExists struct {
+	Name string
+}
+
+func (e ErrAttributeExists) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrAttributeExists) Localized() i18n.Message {
+	return i18n.Msg("attribute_exists", e.Name)
+}
+
+type ErrInvalidAttribute struct {
+	Name   string
+	Reason i18n.Message
+}
+
+func (e ErrInvalidAttribute) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidAttribute) Localized() i18n.Message {
+	return i18n.Msg("invalid_attribute", e.Name, e.Reason)
+}
+
+type ErrInvalidAttributeValue struct {
+	Name   string
+	Reason i18n.Message
+}
+
+func (e ErrInvalidAttributeValue) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidAttributeValue) Localized() i18n.Message {
+	return i18n.Msg("invalid_attribute_value", e.Name, e.Reason)
+}
+
+type ErrInvalidLocale struct {
+	Locale string
+}
+
+func (e ErrInvalidLocale) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidLocale) Localized() i18n.Message {
+	return i18n.Msg("invalid_locale", e.Locale)
+}
+
+type ErrInvalidTimezone struct {
+	Timezone string
+}
+
+func (e ErrInvalidTimezone) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidTimezone) Localized() i18n.Message {
+	return i18n.Msg("invalid_timezone", e.Timezone)
+}
+
+type ErrInvalidDateFormat struct {
+	Format string
+}
+
+func (e ErrInvalidDateFormat) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidDateFormat) Localized() i18n.Message {
+	return i18n.Msg("invalid_date_format", e.Format)
+}
+
+type ErrInvalidChannel struct {
+	Channel string
+}
+
+func (e ErrInvalidChannel) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidChannel) Localized() i18n.Message {
+	return i18n.Msg("invalid_channel", e.Channel)
 }
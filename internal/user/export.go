@@ -0,0 +1,162 @@
+package user
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+)
+
+type exporter interface {
+	begin() error
+	write(u *domain.User) error
+	end() error
+}
+
+// exportColumns are the columns a client can ask for, secrets such as the
+// password are left out on purpose.
+var exportColumns = map[string]func(u *domain.User) interface{}{
+	"id":         func(u *domain.User) interface{} { return u.ID },
+	"username":   func(u *domain.User) interface{} { return u.Username },
+	"first_name": func(u *domain.User) interface{} { return u.FirstName },
+	"last_name":  func(u *domain.User) interface{} { return u.LastName },
+	"email":      func(u *domain.User) interface{} { return u.Email },
+	"phone":      func(u *domain.User) interface{} { return u.Phone },
+	"version":    func(u *domain.User) interface{} { return u.Version },
+	"created_at": func(u *domain.User) interface{} { return u.CreatedAt },
+	"updated_at": func(u *domain.User) interface{} { return u.UpdatedAt },
+}
+
+var defaultExportColumns = []string{
+	"id", "username", "first_name", "last_name", "email", "phone",
+	"version", "created_at", "updated_at",
+}
+
+var exporters = map[string]func(w io.Writer, columns []string) exporter{
+	"csv":    newCSVExporter,
+	"ndjson": newNDJSONExporter,
+	"json":   newJSONExporter,
+}
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"json":   "application/json; charset=utf-8",
+}
+
+func (s service) Export(ctx context.Context, filters Filters, fn func(user *domain.User) error) error {
+	filters, err := s.attributeFilters(ctx, filters)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Stream(ctx, filters, func(user *domain.User) error {
+		user.Password = ""
+		return fn(user)
+	})
+}
+
+type csvExporter struct {
+	w       *csv.Writer
+	columns []string
+	record  []string
+}
+
+func newCSVExporter(w io.Writer, columns []string) exporter {
+	return &csvExporter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+}
+
+func (e *csvExporter) begin() error {
+	return e.w.Write(e.columns)
+}
+
+func (e *csvExporter) write(u *domain.User) error {
+	for i, c := range e.columns {
+		switch v := exportColumns[c](u).(type) {
+		case string:
+			e.record[i] = v
+		case bool:
+			e.record[i] = strconv.FormatBool(v)
+		case int64:
+			e.record[i] = strconv.FormatInt(v, 10)
+		case *time.Time:
+			e.record[i] = ""
+			if v != nil {
+				e.record[i] = v.Format(time.RFC3339)
+			}
+		}
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExporter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func newNDJSONExporter(w io.Writer, columns []string) exporter {
+	return &ndjsonExporter{enc: json.NewEncoder(w), columns: columns}
+}
+
+func (e *ndjsonExporter) begin() error { return nil }
+
+func (e *ndjsonExporter) write(u *domain.User) error {
+	return e.enc.Encode(exportRecord(u, e.columns))
+}
+
+func (e *ndjsonExporter) end() error { return nil }
+
+// jsonExporter writes a single array, one element at a time.
+type jsonExporter struct {
+	w       io.Writer
+	columns []string
+	first   bool
+}
+
+func newJSONExporter(w io.Writer, columns []string) exporter {
+	return &jsonExporter{w: w, columns: columns, first: true}
+}
+
+func (e *jsonExporter) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonExporter) write(u *domain.User) error {
+	b, err := json.Marshal(exportRecord(u, e.columns))
+	if err != nil {
+		return err
+	}
+
+	if !e.first {
+		if _, err := io.WriteString(e.w, ",\n"); err != nil {
+			return err
+		}
+	}
+	e.first = false
+
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonExporter) end() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+func exportRecord(u *domain.User, columns []string) map[string]interface{} {
+	record := make(map[string]interface{}, len(columns))
+	for _, c := range columns {
+		record[c] = exportColumns[c](u)
+	}
+	return record
+}
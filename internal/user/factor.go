@@ -0,0 +1,498 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/storage"
+	"github.com/ncostamagna/go-app-users-lab/pkg/twofa"
+	"github.com/ncostamagna/go-app-users-lab/pkg/uow"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the second factors a login can be completed with
+const (
+	FactorTOTP    = "totp"
+	FactorEmail   = "email"
+	FactorPasskey = "passkey"
+)
+
+const (
+	otpTTL         = 10 * time.Minute
+	otpMaxAttempts = 5
+	// at most otpSendLimit codes are sent to a user per otpWindow, the
+	// attempts of a code carry over to the next one sent in the window
+	otpSendLimit   = 5
+	otpWindow      = time.Hour
+	maxLabelLength = 50
+	// qrTTL is how long the QR of a new TOTP factor can be downloaded
+	qrTTL = 10 * time.Minute
+)
+
+// Enrollment is what the user needs to set up a new factor, the TOTP
+// ones carry the otpauth URI and its QR as a base64 PNG data URI, and
+// a short lived link to it when the service has a storage.
+type Enrollment struct {
+	Factor *domain.UserFactor `json:"factor"`
+	URI    string             `json:"otpauth_uri,omitempty"`
+	QR     string             `json:"qr,omitempty"`
+	QRURL  string             `json:"qr_url,omitempty"`
+}
+
+// Factor is a type of second factor verified with a code. Passkeys
+// aren't one, they run their own WebAuthn ceremony.
+type Factor interface {
+	// Enroll sets up a new factor, it stays pending until Confirm.
+	Enroll(ctx context.Context, user *domain.User, f *domain.UserFactor) (*Enrollment, error)
+	Confirm(ctx context.Context, user *domain.User, f *domain.UserFactor, code string) error
+	// Send delivers a login code to the user, it does nothing
+	// when the user already has the code, as with an authenticator app.
+	Send(ctx context.Context, user *domain.User, f *domain.UserFactor) error
+	Verify(ctx context.Context, user *domain.User, f *domain.UserFactor, code string) error
+	// Remove releases what Enroll set up outside the database.
+	Remove(ctx context.Context, user *domain.User, f *domain.UserFactor) error
+}
+
+// totpFactor is an authenticator app factor kept in Twilio,
+// a user can enroll several of them.
+type totpFactor struct {
+	client twofa.TwoFA
+	blobs  storage.Storage
+}
+
+// Enroll creates the Twilio factor and stores its QR, both are removed
+// if the unit of work fails. The QR expires on its own, it is removed
+// as soon as the factor is confirmed.
+func (t totpFactor) Enroll(ctx context.Context, user *domain.User, f *domain.UserFactor) (*Enrollment, error) {
+	resp, err := t.client.Create(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	uow.Compensate(ctx, func(ctx context.Context) error {
+		return t.client.Delete(user.ID, resp.Hash)
+	})
+	f.Secret = resp.Hash
+
+	png, err := t.client.QR(resp.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Enrollment{
+		URI: resp.Url,
+		QR:  "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}
+
+	if t.blobs == nil {
+		return e, nil
+	}
+
+	key := qrKey(f)
+	if err := t.blobs.Put(ctx, key, bytes.NewReader(png), storage.PutOptions{ContentType: "image/png", TTL: qrTTL}); err != nil {
+		return nil, err
+	}
+
+	uow.Compensate(ctx, func(ctx context.Context) error {
+		return t.blobs.Delete(ctx, key)
+	})
+
+	if e.QRURL, err = t.blobs.URL(ctx, key, qrTTL); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// qrKey is the storage key of the QR of a TOTP factor.
+func qrKey(f *domain.UserFactor) string {
+	return "2fa/" + f.ID + ".png"
+}
+
+// deleteQR removes the QR once it isn't needed, it expires anyway.
+func (t totpFactor) deleteQR(ctx context.Context, f *domain.UserFactor) error {
+	if t.blobs == nil {
+		return nil
+	}
+	return t.blobs.Delete(ctx, qrKey(f))
+}
+
+// Confirm verifies the factor in Twilio, it should run last in the unit
+// of work, so a failed verification rolls the approval back. If the
+// commit fails afterwards the factor is removed, it has to be enrolled again.
+func (t totpFactor) Confirm(ctx context.Context, user *domain.User, f *domain.UserFactor, code string) error {
+	if err := t.client.Verify(user.ID, code, f.Secret); err != nil {
+		return err
+	}
+
+	uow.Compensate(ctx, func(ctx context.Context) error {
+		return t.client.Delete(user.ID, f.Secret)
+	})
+
+	// the QR expires anyway, failing to remove it isn't worth failing for
+	uow.AfterCommit(ctx, func(ctx context.Context) {
+		_ = t.deleteQR(ctx, f)
+	})
+	return nil
+}
+
+func (t totpFactor) Send(ctx context.Context, user *domain.User, f *domain.UserFactor) error {
+	return nil
+}
+
+func (t totpFactor) Verify(ctx context.Context, user *domain.User, f *domain.UserFactor, code string) error {
+	return t.client.Check(user.ID, code, f.Secret)
+}
+
+func (t totpFactor) Remove(ctx context.Context, user *domain.User, f *domain.UserFactor) error {
+	if err := t.client.Delete(user.ID, f.Secret); err != nil {
+		return err
+	}
+	return t.deleteQR(ctx, f)
+}
+
+// emailFactor sends a 6 digit code to the user's email. The codes are
+// hashed, expire and only accept a few attempts, and a user is only sent
+// a few of them per hour.
+type emailFactor struct {
+	repo     Repository
+	notifier LoginNotifier
+}
+
+func (e emailFactor) Enroll(ctx context.Context, user *domain.User, f *domain.UserFactor) (*Enrollment, error) {
+	if err := e.send(ctx, user, domain.OTPEnroll); err != nil {
+		return nil, err
+	}
+	return &Enrollment{}, nil
+}
+
+func (e emailFactor) Confirm(ctx context.Context, user *domain.User, f *domain.UserFactor, code string) error {
+	return e.verify(ctx, user, domain.OTPEnroll, code)
+}
+
+func (e emailFactor) Send(ctx context.Context, user *domain.User, f *domain.UserFactor) error {
+	return e.send(ctx, user, domain.OTPLogin)
+}
+
+func (e emailFactor) Verify(ctx context.Context, user *domain.User, f *domain.UserFactor, code string) error {
+	return e.verify(ctx, user, domain.OTPLogin, code)
+}
+
+func (e emailFactor) Remove(ctx context.Context, user *domain.User, f *domain.UserFactor) error {
+	return nil
+}
+
+func (e emailFactor) send(ctx context.Context, user *domain.User, purpose domain.OTPPurpose) error {
+	if user.Email == "" {
+		return ErrEmailRequired
+	}
+
+	since := time.Now().Add(-otpWindow)
+	sent, err := e.repo.CountOTPs(ctx, user.ID, since)
+	if err != nil {
+		return err
+	}
+	if sent >= otpSendLimit {
+		return ErrTooManyCodes
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	otp := domain.OTPCode{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		CodeHash:  string(hash),
+		ExpiresAt: time.Now().Add(otpTTL),
+	}
+	if err := e.repo.CreateOTP(ctx, &otp, since); err != nil {
+		return err
+	}
+
+	return e.notifier.OTPCode(ctx, *user, preferences(ctx, e.repo, user.ID), code, otp.ExpiresAt)
+}
+
+func (e emailFactor) verify(ctx context.Context, user *domain.User, purpose domain.OTPPurpose, code string) error {
+	otp, err := e.repo.GetOTP(ctx, user.ID, purpose)
+	if err != nil {
+		return err
+	}
+
+	if err := e.repo.AttemptOTP(ctx, otp.ID, otpMaxAttempts); err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(otp.CodeHash), []byte(code)); err != nil {
+		return ErrInvalidCode
+	}
+
+	return e.repo.UseOTP(ctx, otp.ID)
+}
+
+// secondFactors returns the factors the user has to complete the login
+// with, the preferred one first.
+func (s service) secondFactors(ctx context.Context, user *domain.User) ([]domain.LoginFactor, error) {
+	userFactors, err := s.repo.GetFactors(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var factors []domain.LoginFactor
+	for _, f := range userFactors {
+		if f.Status == domain.FactorApproved {
+			factors = append(factors, domain.LoginFactor{ID: f.ID, Type: f.Type, Label: f.Label})
+		}
+	}
+
+	if s.passkeys != nil {
+		count, err := s.repo.CountPasskeys(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			factors = append(factors, domain.LoginFactor{ID: FactorPasskey, Type: FactorPasskey})
+		}
+	}
+
+	for i, f := range factors {
+		if f.ID == user.TwoFPreferred {
+			factors[0], factors[i] = factors[i], factors[0]
+			break
+		}
+	}
+
+	return factors, nil
+}
+
+// loginFactor returns the factor a login code is checked against. Without
+// an ID it is the preferred factor, or the first approved one, or for
+// the clients that confirm a new TOTP factor through the login, the
+// last pending one.
+func (s service) loginFactor(ctx context.Context, user *domain.User, id string) (*domain.UserFactor, error) {
+	if id != "" {
+		return s.repo.GetFactor(ctx, user.ID, id)
+	}
+
+	factors, err := s.repo.GetFactors(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var approved, pending *domain.UserFactor
+	for i := range factors {
+		f := &factors[i]
+		switch {
+		case f.Status == domain.FactorApproved && f.ID == user.TwoFPreferred:
+			return f, nil
+		case f.Status == domain.FactorApproved && approved == nil:
+			approved = f
+		case f.Status == domain.FactorPending && f.Type == FactorTOTP:
+			pending = f
+		}
+	}
+
+	if approved != nil {
+		return approved, nil
+	}
+	if pending != nil {
+		return pending, nil
+	}
+	return nil, ErrInvalidFactor{id}
+}
+
+func (s service) GetFactors(ctx context.Context, user *domain.User) ([]domain.UserFactor, error) {
+	return s.repo.GetFactors(ctx, user.ID)
+}
+
+// AddFactor enrolls a new factor of the type, it has to be confirmed
+// with a code before it can be used. Only one email factor is allowed,
+// they would all send the code to the same address.
+func (s service) AddFactor(ctx context.Context, user *domain.User, factorType, label string) (*Enrollment, error) {
+	e, err := s.addFactor(ctx, user, factorType, label)
+	s.audit(ctx, domain.AuditEnroll2FA, user.ID, user.ID, err, nil)
+	return e, err
+}
+
+func (s service) addFactor(ctx context.Context, user *domain.User, factorType, label string) (*Enrollment, error) {
+	factor, ok := s.factors[factorType]
+	if !ok {
+		return nil, ErrInvalidFactor{factorType}
+	}
+
+	if len(label) > maxLabelLength {
+		return nil, ErrFactorLabelTooLong
+	}
+
+	if factorType == FactorEmail {
+		factors, err := s.repo.GetFactors(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range factors {
+			if f.Type == FactorEmail {
+				return nil, ErrFactorExists{factorType}
+			}
+		}
+	}
+
+	if label == "" {
+		label = defaultLabel(user, factorType)
+	}
+
+	f := &domain.UserFactor{
+		ID:     uuid.New().String(),
+		UserID: user.ID,
+		Type:   factorType,
+		Label:  label,
+		Status: domain.FactorPending,
+	}
+
+	var e *Enrollment
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		if e, err = factor.Enroll(ctx, user, f); err != nil {
+			return err
+		}
+		return s.repo.CreateFactor(ctx, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e.Factor = f
+	return e, nil
+}
+
+func defaultLabel(user *domain.User, factorType string) string {
+	if factorType == FactorEmail {
+		return truncate(user.Email, maxLabelLength)
+	}
+	return "Authenticator app"
+}
+
+func (s service) ConfirmFactor(ctx context.Context, user *domain.User, id, code string) error {
+	err := s.confirmFactor(ctx, user, id, code)
+	s.audit(ctx, domain.AuditEnroll2FA, user.ID, user.ID, err, nil)
+	return err
+}
+
+func (s service) confirmFactor(ctx context.Context, user *domain.User, id, code string) error {
+	if code == "" {
+		return ErrCodeRequired
+	}
+
+	f, err := s.repo.GetFactor(ctx, user.ID, id)
+	if err != nil {
+		return err
+	}
+
+	return s.approveFactor(ctx, user, f, code)
+}
+
+// approveFactor confirms the code and approves the factor in one unit of work.
+func (s service) approveFactor(ctx context.Context, user *domain.User, f *domain.UserFactor, code string) error {
+	if f.Status != domain.FactorPending {
+		return ErrFactorApproved
+	}
+
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.repo.ApproveFactor(ctx, user.ID, f.ID); err != nil {
+			return err
+		}
+		return s.factors[f.Type].Confirm(ctx, user, f, code)
+	})
+	if err != nil {
+		return err
+	}
+
+	f.Status = domain.FactorApproved
+	return nil
+}
+
+func (s service) RenameFactor(ctx context.Context, user *domain.User, id, label string) error {
+	if len(label) > maxLabelLength {
+		return ErrFactorLabelTooLong
+	}
+
+	if _, err := s.repo.GetFactor(ctx, user.ID, id); err != nil {
+		return err
+	}
+
+	return s.repo.RenameFactor(ctx, user.ID, id, label)
+}
+
+// RemoveFactor deletes the factor, and the preference
+// for it when it was the preferred one.
+func (s service) RemoveFactor(ctx context.Context, user *domain.User, id string) error {
+	err := s.removeFactor(ctx, user, id)
+	s.audit(ctx, domain.AuditFactorRemove, user.ID, user.ID, err, nil)
+	return err
+}
+
+func (s service) removeFactor(ctx context.Context, user *domain.User, id string) error {
+	f, err := s.repo.GetFactor(ctx, user.ID, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.DeleteFactor(ctx, user.ID, f.ID); err != nil {
+		return err
+	}
+
+	if user.TwoFPreferred == f.ID {
+		if err := s.repo.SetPreferredFactor(ctx, user.ID, ""); err != nil {
+			return err
+		}
+	}
+
+	// the factor is gone for the login already,
+	// what can't be released is only logged
+	if err := s.factors[f.Type].Remove(ctx, user, f); err != nil {
+		s.log.Println(err)
+	}
+	return nil
+}
+
+// SendLoginCode sends the code of the factor to a user halfway through
+// a login, factors with no code to send are accepted and do nothing.
+func (s service) SendLoginCode(ctx context.Context, user *domain.User, id string) error {
+	f, err := s.loginFactor(ctx, user, id)
+	if err != nil {
+		return err
+	}
+
+	if f.Status != domain.FactorApproved {
+		return ErrInvalidFactor{f.ID}
+	}
+	return s.factors[f.Type].Send(ctx, user, f)
+}
+
+// SetPreferredFactor sets the factor offered first on login,
+// it must be one the user can already log in with.
+func (s service) SetPreferredFactor(ctx context.Context, user *domain.User, id string) error {
+	factors, err := s.secondFactors(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range factors {
+		if f.ID == id {
+			return s.repo.SetPreferredFactor(ctx, user.ID, id)
+		}
+	}
+	return ErrInvalidFactor{id}
+}
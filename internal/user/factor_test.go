@@ -0,0 +1,181 @@
+package user_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncostamagna/axul_auth/auth"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/internal/user"
+)
+
+const emailFactorID = "9d2c4f5e-1b7a-4c3e-8e6f-2a1d0c9b8a70"
+
+// memNotifier keeps the codes sent to the users.
+type memNotifier struct {
+	mu    sync.Mutex
+	codes []string
+}
+
+func (n *memNotifier) NewDevice(context.Context, domain.User, domain.Preferences, domain.UserLogin) error {
+	return nil
+}
+
+func (n *memNotifier) MagicLink(context.Context, domain.User, domain.Preferences, string, time.Time) error {
+	return nil
+}
+
+func (n *memNotifier) OTPCode(_ context.Context, _ domain.User, _ domain.Preferences, code string, _ time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.codes = append(n.codes, code)
+	return nil
+}
+
+func (n *memNotifier) last() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.codes[len(n.codes)-1]
+}
+
+type emailEnv struct {
+	srv      user.Service
+	notifier *memNotifier
+	user     domain.User
+}
+
+// newEmailEnv returns a service holding one user with an approved email factor.
+func newEmailEnv(t *testing.T) *emailEnv {
+	t.Helper()
+
+	u := domain.User{
+		ID:       "0b8f0f36-3c43-4a35-9f0e-0f7d1c3d7e51",
+		Username: "jdoe",
+		Email:    "jdoe@example.com",
+	}
+	repo := newMemRepo(u)
+	repo.factors = []domain.UserFactor{{
+		ID:     emailFactorID,
+		UserID: u.ID,
+		Type:   user.FactorEmail,
+		Status: domain.FactorApproved,
+	}}
+
+	a, err := auth.New("test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &emailEnv{notifier: &memNotifier{}, user: u}
+	env.srv = user.NewService(log.New(io.Discard, "", 0), a, nil, repo, nil, &memAuditor{}, env.notifier, nil, nil, user.MagicLinkConfig{}, nil)
+	return env
+}
+
+func (env *emailEnv) send(t *testing.T) {
+	t.Helper()
+	if err := env.srv.SendLoginCode(context.Background(), &env.user, emailFactorID); err != nil {
+		t.Fatalf("send code: %v", err)
+	}
+}
+
+func (env *emailEnv) login(code string) error {
+	_, err := env.srv.Login2FA(context.Background(), &env.user, emailFactorID, code, false)
+	return err
+}
+
+// wrong returns a code other than the one sent.
+func wrong(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestLogin2FAEmail(t *testing.T) {
+	env := newEmailEnv(t)
+	env.send(t)
+	code := env.notifier.last()
+
+	if err := env.login(code); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if err := env.login(code); !errors.Is(err, user.ErrInvalidCode) {
+		t.Errorf("used the code twice: err = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestLogin2FAEmailReplacedCode(t *testing.T) {
+	env := newEmailEnv(t)
+	env.send(t)
+	first := env.notifier.last()
+	env.send(t)
+
+	if err := env.login(first); !errors.Is(err, user.ErrInvalidCode) {
+		t.Errorf("logged in with a replaced code: err = %v, want ErrInvalidCode", err)
+	}
+	if err := env.login(env.notifier.last()); err != nil {
+		t.Errorf("login with the last code: %v", err)
+	}
+}
+
+func TestLogin2FAEmailAttempts(t *testing.T) {
+	env := newEmailEnv(t)
+	env.send(t)
+	code := env.notifier.last()
+
+	for i := 0; i < 5; i++ {
+		if err := env.login(wrong(code)); !errors.Is(err, user.ErrInvalidCode) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidCode", i+1, err)
+		}
+	}
+
+	if err := env.login(code); !errors.Is(err, user.ErrInvalidCode) {
+		t.Errorf("logged in after running out of attempts: err = %v", err)
+	}
+
+	// another code doesn't grant more attempts
+	env.send(t)
+	if err := env.login(env.notifier.last()); !errors.Is(err, user.ErrInvalidCode) {
+		t.Errorf("a new code reset the attempts: err = %v", err)
+	}
+}
+
+func TestLogin2FAEmailAttemptsCarryOver(t *testing.T) {
+	env := newEmailEnv(t)
+	env.send(t)
+
+	for i := 0; i < 4; i++ {
+		if err := env.login(wrong(env.notifier.last())); !errors.Is(err, user.ErrInvalidCode) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidCode", i+1, err)
+		}
+	}
+
+	// the new code has the one attempt left
+	env.send(t)
+	code := env.notifier.last()
+	if err := env.login(wrong(code)); !errors.Is(err, user.ErrInvalidCode) {
+		t.Fatalf("err = %v, want ErrInvalidCode", err)
+	}
+	if err := env.login(code); !errors.Is(err, user.ErrInvalidCode) {
+		t.Errorf("logged in past the attempts: err = %v", err)
+	}
+}
+
+func TestSendLoginCodeThrottle(t *testing.T) {
+	env := newEmailEnv(t)
+	for i := 0; i < 5; i++ {
+		env.send(t)
+	}
+
+	if err := env.srv.SendLoginCode(context.Background(), &env.user, emailFactorID); !errors.Is(err, user.ErrTooManyCodes) {
+		t.Errorf("err = %v, want ErrTooManyCodes", err)
+	}
+	if n := len(env.notifier.codes); n != 5 {
+		t.Errorf("sent %d codes, want 5", n)
+	}
+}
@@ -0,0 +1,288 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ImportStatus string
+
+const (
+	ImportRunning ImportStatus = "running"
+	ImportDone    ImportStatus = "done"
+	ImportFailed  ImportStatus = "failed"
+)
+
+const (
+	importBatchSize = 500
+	importJobTTL    = 24 * time.Hour
+)
+
+type (
+	// ImportRow is a user read from the import file, Err is set
+	// when the row couldn't be parsed.
+	ImportRow struct {
+		Line int
+		User CreateReq
+		Err  string
+	}
+
+	ImportJob struct {
+		ID         string           `json:"id"`
+		Status     ImportStatus     `json:"status"`
+		DryRun     bool             `json:"dry_run"`
+		Total      int              `json:"total"`
+		Processed  int              `json:"processed"`
+		Imported   int              `json:"imported"`
+		Failed     int              `json:"failed"`
+		Error      string           `json:"error,omitempty"`
+		Errors     []ImportRowError `json:"errors,omitempty"`
+		CreatedAt  time.Time        `json:"created_at"`
+		FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	}
+
+	ImportRowError struct {
+		Line     int    `json:"line"`
+		Username string `json:"username,omitempty"`
+		Error    string `json:"error"`
+	}
+
+	// importStore keeps the import jobs in memory, so a job is only
+	// visible on the instance that runs it.
+	importStore struct {
+		mu   sync.Mutex
+		jobs map[string]*ImportJob
+	}
+)
+
+func newImportStore() *importStore {
+	return &importStore{jobs: make(map[string]*ImportJob)}
+}
+
+func (st *importStore) add(job *ImportJob) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for id, j := range st.jobs {
+		if j.FinishedAt != nil && time.Since(*j.FinishedAt) > importJobTTL {
+			delete(st.jobs, id)
+		}
+	}
+	st.jobs[job.ID] = job
+}
+
+func (st *importStore) get(id string) (*ImportJob, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	job, ok := st.jobs[id]
+	if !ok {
+		return nil, false
+	}
+
+	cp := *job
+	cp.Errors = append([]ImportRowError(nil), job.Errors...)
+	return &cp, true
+}
+
+func (st *importStore) update(job *ImportJob, fn func(j *ImportJob)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	fn(job)
+}
+
+// Import validates the rows and, unless it is a dry run, creates the users
+// in batched transactions. It runs in the background, the returned job
+// can be followed with GetImport.
+func (s service) Import(ctx context.Context, rows []ImportRow, dryRun bool) (*ImportJob, error) {
+	job := &ImportJob{
+		ID:        uuid.New().String(),
+		Status:    ImportRunning,
+		DryRun:    dryRun,
+		Total:     len(rows),
+		CreatedAt: time.Now(),
+	}
+	s.imports.add(job)
+
+	// the job outlives the request that started it, it keeps the
+	// client info and the language of the request but not its deadline
+	jobCtx := i18n.WithLang(context.WithoutCancel(ctx), i18n.FromContext(ctx))
+	go s.runImport(jobCtx, job, rows)
+
+	job, _ = s.imports.get(job.ID)
+	return job, nil
+}
+
+func (s service) GetImport(ctx context.Context, id string) (*ImportJob, error) {
+	job, ok := s.imports.get(id)
+	if !ok {
+		return nil, ErrImportNotFound{id}
+	}
+	return job, nil
+}
+
+func (s service) runImport(ctx context.Context, job *ImportJob, rows []ImportRow) {
+	seen := make(map[string]bool)
+
+	for start := 0; start < len(rows); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		imported, errs, err := s.importBatch(ctx, rows[start:end], seen, job.DryRun)
+		if err != nil {
+			s.log.Println("import", job.ID, err)
+		}
+
+		s.imports.update(job, func(j *ImportJob) {
+			j.Processed += end - start
+			j.Imported += imported
+			j.Failed += len(errs)
+			j.Errors = append(j.Errors, errs...)
+			if err != nil {
+				j.Status = ImportFailed
+				j.Error = i18n.Localize(ctx, err)
+			}
+		})
+
+		if err != nil {
+			break
+		}
+	}
+
+	s.imports.update(job, func(j *ImportJob) {
+		now := time.Now()
+		j.FinishedAt = &now
+		if j.Status == ImportRunning {
+			j.Status = ImportDone
+		}
+	})
+}
+
+// importBatch creates the valid rows of the batch in one transaction. When
+// the transaction fails the rows are retried one by one, to report which
+// of them caused it. It returns an error only if the job can't go on.
+func (s service) importBatch(ctx context.Context, rows []ImportRow, seen map[string]bool, dryRun bool) (int, []ImportRowError, error) {
+	var errs []ImportRowError
+	var valid []ImportRow
+
+	defs, err := s.attributeDefs(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, row := range rows {
+		if row.Err == "" {
+			if err := validateCreate(row.User); err != nil {
+				row.Err = i18n.Localize(ctx, err)
+			}
+		}
+
+		if row.Err == "" {
+			attributes, err := newUserAttributes(defs, row.User.Attributes)
+			if err != nil {
+				row.Err = i18n.Localize(ctx, err)
+			}
+			row.User.Attributes = attributes
+		}
+
+		key := strings.ToLower(row.User.Username)
+		if row.Err == "" && seen[key] {
+			row.Err = i18n.T(ctx, "import_username_duplicated")
+		}
+
+		if row.Err != "" {
+			errs = append(errs, ImportRowError{Line: row.Line, Username: row.User.Username, Error: row.Err})
+			continue
+		}
+
+		seen[key] = true
+		valid = append(valid, row)
+	}
+
+	usernames := make([]string, len(valid))
+	for i, row := range valid {
+		usernames[i] = row.User.Username
+	}
+
+	taken, err := s.repo.UsernamesTaken(ctx, usernames)
+	if err != nil {
+		return 0, errs, err
+	}
+
+	takenSet := make(map[string]bool, len(taken))
+	for _, u := range taken {
+		takenSet[strings.ToLower(u)] = true
+	}
+
+	var users []domain.User
+	var lines []ImportRow
+	for _, row := range valid {
+		if takenSet[strings.ToLower(row.User.Username)] {
+			errs = append(errs, ImportRowError{Line: row.Line, Username: row.User.Username, Error: i18n.T(ctx, "username_taken")})
+			continue
+		}
+
+		if dryRun {
+			lines = append(lines, row)
+			continue
+		}
+
+		password, err := importPassword(row.User.Password)
+		if err != nil {
+			errs = append(errs, ImportRowError{Line: row.Line, Username: row.User.Username, Error: i18n.Localize(ctx, err)})
+			continue
+		}
+
+		users = append(users, domain.User{
+			FirstName:  row.User.FirstName,
+			LastName:   row.User.LastName,
+			Email:      row.User.Email,
+			Phone:      row.User.Phone,
+			Username:   row.User.Username,
+			Password:   password,
+			Attributes: row.User.Attributes,
+		})
+		lines = append(lines, row)
+	}
+
+	if dryRun {
+		return len(lines), errs, nil
+	}
+
+	if err := s.repo.CreateBatch(ctx, users); err == nil {
+		return len(users), errs, nil
+	}
+
+	imported := 0
+	for i := range users {
+		if err := s.repo.Create(ctx, &users[i]); err != nil {
+			errs = append(errs, ImportRowError{Line: lines[i].Line, Username: users[i].Username, Error: i18n.Localize(ctx, err)})
+			continue
+		}
+		imported++
+	}
+	return imported, errs, nil
+}
+
+// importPassword keeps passwords that are already bcrypt hashes,
+// plain ones are hashed as in create.
+func importPassword(password string) (string, error) {
+	if _, err := bcrypt.Cost([]byte(password)); err == nil {
+		return password, nil
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
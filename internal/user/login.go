@@ -0,0 +1,218 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/clientinfo"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-app-users-lab/pkg/mail"
+)
+
+// LoginNotifier sends the user the messages of the login flows: a login
+// from a device their account wasn't used from before, and magic links.
+// They are written in the locale, timezone and date format of prefs.
+type LoginNotifier interface {
+	NewDevice(ctx context.Context, user domain.User, prefs domain.Preferences, login domain.UserLogin) error
+	MagicLink(ctx context.Context, user domain.User, prefs domain.Preferences, link string, expiresAt time.Time) error
+	OTPCode(ctx context.Context, user domain.User, prefs domain.Preferences, code string, expiresAt time.Time) error
+}
+
+type mailLoginNotifier struct {
+	sender mail.Sender
+}
+
+// mailTemplate is a message in one language, body is
+// a format taking the arguments of the message.
+type mailTemplate struct {
+	subject string
+	body    string
+}
+
+var (
+	newDeviceMail = map[string]mailTemplate{
+		i18n.English: {
+			subject: "New sign-in to your account",
+			body: "Hi %s,\n\nYour account was used to sign in from a new device.\n\n" +
+				"Time: %s\nIP address: %s\nDevice: %s\n\n" +
+				"If this wasn't you, mark the login %s as not you from your login history and change your password.\n",
+		},
+		i18n.Spanish: {
+			subject: "Nuevo inicio de sesión en tu cuenta",
+			body: "Hola %s,\n\nSe inició sesión en tu cuenta desde un dispositivo nuevo.\n\n" +
+				"Fecha: %s\nDirección IP: %s\nDispositivo: %s\n\n" +
+				"Si no fuiste tú, marca el inicio de sesión %s como no reconocido en tu historial y cambia tu contraseña.\n",
+		},
+	}
+
+	magicLinkMail = map[string]mailTemplate{
+		i18n.English: {
+			subject: "Your sign-in link",
+			body: "Hi %s,\n\nUse this link to sign in as %s, it works once and until %s:\n\n%s\n\n" +
+				"If you didn't ask for it you can ignore this email.\n",
+		},
+		i18n.Spanish: {
+			subject: "Tu enlace para iniciar sesión",
+			body: "Hola %s,\n\nUsa este enlace para iniciar sesión como %s, sirve una sola vez y hasta el %s:\n\n%s\n\n" +
+				"Si no lo pediste puedes ignorar este correo.\n",
+		},
+	}
+
+	otpCodeMail = map[string]mailTemplate{
+		i18n.English: {
+			subject: "Your verification code",
+			body: "Hi %s,\n\nYour verification code is %s, it expires at %s.\n\n" +
+				"If you didn't ask for it, someone may know your password, change it.\n",
+		},
+		i18n.Spanish: {
+			subject: "Tu código de verificación",
+			body: "Hola %s,\n\nTu código de verificación es %s, vence el %s.\n\n" +
+				"Si no lo pediste, alguien podría conocer tu contraseña, cámbiala.\n",
+		},
+	}
+)
+
+// NewMailLoginNotifier emails the new device logins to the user.
+func NewMailLoginNotifier(sender mail.Sender) LoginNotifier {
+	return &mailLoginNotifier{sender: sender}
+}
+
+// NewDevice is only sent to the users opted in to email notifications,
+// the magic links and codes are always sent as the user asked for them.
+func (n *mailLoginNotifier) NewDevice(ctx context.Context, user domain.User, prefs domain.Preferences, login domain.UserLogin) error {
+	if user.Email == "" || !prefs.Notifications[domain.ChannelEmail] {
+		return nil
+	}
+
+	return n.send(ctx, user, prefs, newDeviceMail,
+		user.FirstName, prefs.FormatTime(login.CreatedAt), login.IP, login.UserAgent, login.ID)
+}
+
+func (n *mailLoginNotifier) MagicLink(ctx context.Context, user domain.User, prefs domain.Preferences, link string, expiresAt time.Time) error {
+	return n.send(ctx, user, prefs, magicLinkMail, user.FirstName, user.Username, prefs.FormatTime(expiresAt), link)
+}
+
+func (n *mailLoginNotifier) OTPCode(ctx context.Context, user domain.User, prefs domain.Preferences, code string, expiresAt time.Time) error {
+	return n.send(ctx, user, prefs, otpCodeMail, user.FirstName, code, prefs.FormatTime(expiresAt))
+}
+
+func (n *mailLoginNotifier) send(ctx context.Context, user domain.User, prefs domain.Preferences, templates map[string]mailTemplate, args ...interface{}) error {
+	t := templates[i18n.Lang(prefs.Locale)]
+	return n.sender.Send(ctx, user.Email, t.subject, fmt.Sprintf(t.body, args...))
+}
+
+// startSession records the login of the user and returns its ID, which
+// is the session carried in the token. The user is notified the first
+// time a device shows up, unless it is the first login of the account.
+func (s service) startSession(ctx context.Context, user *domain.User) (string, error) {
+	info := clientinfo.FromContext(ctx)
+
+	login := domain.UserLogin{
+		UserID:      user.ID,
+		Fingerprint: fingerprint(info.UserAgent, info.IP),
+		IP:          info.IP,
+		UserAgent:   truncate(info.UserAgent, 255),
+	}
+
+	seen, err := s.repo.FingerprintSeen(ctx, user.ID, login.Fingerprint)
+	if err != nil {
+		return "", err
+	}
+
+	previous := 0
+	if !seen {
+		if previous, err = s.repo.CountLogins(ctx, user.ID); err != nil {
+			return "", err
+		}
+	}
+
+	login.NewDevice = !seen && previous > 0
+	if err := s.repo.CreateLogin(ctx, &login); err != nil {
+		return "", err
+	}
+
+	if login.NewDevice {
+		u := *user
+		u.Password = ""
+		// sending the email must not hold the login back
+		go func(ctx context.Context) {
+			if err := s.loginNotifier.NewDevice(ctx, u, preferences(ctx, s.repo, u.ID), login); err != nil {
+				s.log.Println("new device notification", u.ID, err)
+			}
+		}(context.WithoutCancel(ctx))
+	}
+
+	return login.ID, nil
+}
+
+// checkSession fails when the session of the token has been revoked. Tokens
+// issued before the sessions were recorded carry none and are accepted.
+func (s service) checkSession(ctx context.Context, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	login, err := s.repo.GetLogin(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if login.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+func (s service) GetLogins(ctx context.Context, userID string, offset, limit int) ([]domain.UserLogin, error) {
+	return s.repo.GetLogins(ctx, userID, offset, limit)
+}
+
+func (s service) CountLogins(ctx context.Context, userID string) (int, error) {
+	return s.repo.CountLogins(ctx, userID)
+}
+
+// RevokeLogin flags a login of the user as not made by them,
+// its session can't be used anymore.
+func (s service) RevokeLogin(ctx context.Context, userID, loginID string) error {
+	err := s.revokeLogin(ctx, userID, loginID)
+	s.audit(ctx, domain.AuditSessionRevoke, userID, userID, err, nil)
+	return err
+}
+
+func (s service) revokeLogin(ctx context.Context, userID, loginID string) error {
+	login, err := s.repo.GetLogin(ctx, loginID)
+	if err != nil {
+		return err
+	}
+
+	if login.UserID != userID {
+		return ErrLoginNotFound{loginID}
+	}
+
+	return s.repo.RevokeLogin(ctx, loginID)
+}
+
+// fingerprint identifies a device by its user agent and network, only
+// the IP prefix is used so a changing address in the same network
+// doesn't look like a new device.
+func fingerprint(userAgent, ip string) string {
+	sum := sha256.Sum256([]byte(userAgent + "|" + ipPrefix(ip)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ipPrefix returns the /24 network of an IPv4 address and the /48 of an IPv6 one.
+func ipPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,169 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/clientinfo"
+)
+
+// MagicLinkConfig sets up the passwordless login links. They are
+// disabled while URL is empty, the token is appended to it.
+type MagicLinkConfig struct {
+	URL string
+	TTL time.Duration
+	// Throttle is how many links a user can be sent per ThrottleWindow,
+	// zero means no limit.
+	Throttle       int
+	ThrottleWindow time.Duration
+	// BindBrowser only accepts the link in the browser it was requested from.
+	BindBrowser bool
+}
+
+// RequestMagicLink emails a login link to the account with the username,
+// or to every account with the email. It answers the same whether an
+// account exists or not, and returns the browser binding to hand back
+// when the link is used.
+func (s service) RequestMagicLink(ctx context.Context, username, email string) (string, error) {
+	if s.magicLink.URL == "" {
+		return "", ErrMagicLinkDisabled
+	}
+
+	if username == "" && email == "" {
+		return "", ErrMagicLinkRecipientRequired
+	}
+
+	binding, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	var users []domain.User
+	if username != "" {
+		users, err = s.repo.GetAll(ctx, Filters{Username: username}, 0, 1)
+	} else {
+		users, err = s.repo.GetByEmail(ctx, email)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for i := range users {
+		if users[i].Email == "" {
+			continue
+		}
+		if err := s.sendMagicLink(ctx, &users[i], binding); err != nil {
+			return "", err
+		}
+	}
+
+	return binding, nil
+}
+
+func (s service) sendMagicLink(ctx context.Context, user *domain.User, binding string) error {
+	if s.magicLink.Throttle > 0 {
+		sent, err := s.repo.CountMagicLinks(ctx, user.ID, time.Now().Add(-s.magicLink.ThrottleWindow))
+		if err != nil {
+			return err
+		}
+		if sent >= s.magicLink.Throttle {
+			// not an error, the caller must not learn the account exists
+			s.log.Println("magic link throttled for user", user.ID)
+			return nil
+		}
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	link := domain.MagicLink{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		IP:        clientinfo.FromContext(ctx).IP,
+		ExpiresAt: time.Now().Add(s.magicLink.TTL),
+	}
+	if s.magicLink.BindBrowser {
+		link.BindingHash = hashToken(binding)
+	}
+
+	if err := s.repo.CreateMagicLink(ctx, &link); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(s.magicLink.URL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+
+	return s.loginNotifier.MagicLink(ctx, *user, preferences(ctx, s.repo, user.ID), u.String(), link.ExpiresAt)
+}
+
+// LoginMagicLink uses the link and logs the user in as Login does,
+// asking for the second factor when the account has one.
+func (s service) LoginMagicLink(ctx context.Context, token, binding, trustedDevice string) (*domain.Login, error) {
+	userID, err := s.useMagicLink(ctx, token, binding)
+	if err != nil {
+		s.audit(ctx, domain.AuditMagicLink, userID, userID, err, nil)
+		return nil, err
+	}
+
+	user, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		s.audit(ctx, domain.AuditMagicLink, userID, userID, err, nil)
+		return nil, err
+	}
+
+	return s.completeLogin(ctx, user, trustedDevice, domain.AuditMagicLink)
+}
+
+// useMagicLink marks the link as used and returns its user,
+// which is also returned when the link is rejected.
+func (s service) useMagicLink(ctx context.Context, token, binding string) (string, error) {
+	if s.magicLink.URL == "" {
+		return "", ErrMagicLinkDisabled
+	}
+
+	if token == "" {
+		return "", ErrMagicLinkTokenRequired
+	}
+
+	var userID string
+	_, err := s.repo.UseMagicLink(ctx, hashToken(token), func(link *domain.MagicLink) error {
+		userID = link.UserID
+
+		if link.UsedAt != nil || time.Now().After(link.ExpiresAt) {
+			return ErrMagicLinkInvalid
+		}
+
+		if link.BindingHash != "" && subtle.ConstantTimeCompare([]byte(link.BindingHash), []byte(hashToken(binding))) != 1 {
+			return ErrMagicLinkBrowser
+		}
+		return nil
+	})
+	return userID, err
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
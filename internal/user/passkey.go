@@ -0,0 +1,323 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey"
+)
+
+const passkeyChallengeTTL = 5 * time.Minute
+
+// PasskeyCeremony is the first step of a WebAuthn ceremony, Options go to
+// navigator.credentials and the answer is sent back with ChallengeID.
+type PasskeyCeremony struct {
+	ChallengeID string      `json:"challenge_id"`
+	Options     interface{} `json:"options"`
+}
+
+func (s service) BeginPasskeyRegistration(ctx context.Context, user *domain.User) (*PasskeyCeremony, error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	pu, _, err := s.passkeyUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	options, session, err := s.passkeys.BeginRegistration(pu)
+	if err != nil {
+		return nil, err
+	}
+	return s.beginCeremony(ctx, user.ID, domain.PasskeyRegistration, options, session)
+}
+
+func (s service) FinishPasskeyRegistration(ctx context.Context, user *domain.User, challengeID, name string, response []byte) (*domain.Passkey, error) {
+	p, err := s.finishPasskeyRegistration(ctx, user, challengeID, name, response)
+	s.audit(ctx, domain.AuditPasskeyAdd, user.ID, user.ID, err, nil)
+	return p, err
+}
+
+func (s service) finishPasskeyRegistration(ctx context.Context, user *domain.User, challengeID, name string, response []byte) (*domain.Passkey, error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	challenge, err := s.takeChallenge(ctx, challengeID, domain.PasskeyRegistration, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pu, _, err := s.passkeyUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := s.passkeys.FinishRegistration(pu, challenge.Session, response)
+	if err != nil {
+		return nil, s.passkeyFailed(err)
+	}
+
+	transports := make([]string, len(cred.Transport))
+	for i, t := range cred.Transport {
+		transports[i] = string(t)
+	}
+
+	p := domain.Passkey{
+		UserID:          user.ID,
+		Name:            truncate(name, 64),
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	}
+	if err := s.repo.CreatePasskey(ctx, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s service) GetPasskeys(ctx context.Context, userID string) ([]domain.Passkey, error) {
+	return s.repo.GetPasskeys(ctx, userID)
+}
+
+func (s service) DeletePasskey(ctx context.Context, userID, passkeyID string) error {
+	err := s.repo.DeletePasskey(ctx, userID, passkeyID)
+	s.audit(ctx, domain.AuditPasskeyDelete, userID, userID, err, nil)
+	return err
+}
+
+// BeginPasskey2FA starts the assertion of one of the user's
+// passkeys as the second factor of a login.
+func (s service) BeginPasskey2FA(ctx context.Context, user *domain.User) (*PasskeyCeremony, error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	pu, _, err := s.passkeyUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	options, session, err := s.passkeys.BeginLogin(pu)
+	if err != nil {
+		return nil, err
+	}
+	return s.beginCeremony(ctx, user.ID, domain.PasskeySecondFactor, options, session)
+}
+
+func (s service) Login2FAPasskey(ctx context.Context, user *domain.User, challengeID string, response []byte, rememberDevice bool) (*domain.Login, error) {
+	if err := s.verifyPasskey2FA(ctx, user, challengeID, response); err != nil {
+		s.audit(ctx, domain.AuditLogin2FA, user.ID, user.ID, err, nil)
+		return nil, err
+	}
+	return s.completeLogin2FA(ctx, user, rememberDevice)
+}
+
+func (s service) verifyPasskey2FA(ctx context.Context, user *domain.User, challengeID string, response []byte) error {
+	if s.passkeys == nil {
+		return ErrPasskeysDisabled
+	}
+
+	challenge, err := s.takeChallenge(ctx, challengeID, domain.PasskeySecondFactor, user.ID)
+	if err != nil {
+		return err
+	}
+
+	pu, passkeys, err := s.passkeyUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	cred, err := s.passkeys.FinishLogin(pu, challenge.Session, response)
+	if err != nil {
+		return s.passkeyFailed(err)
+	}
+	return s.usePasskey(ctx, passkeys, cred)
+}
+
+// BeginPasskeyLogin starts a passwordless login, the passkey
+// chosen by the user tells who is logging in.
+func (s service) BeginPasskeyLogin(ctx context.Context) (*PasskeyCeremony, error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	options, session, err := s.passkeys.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, err
+	}
+	return s.beginCeremony(ctx, "", domain.PasskeyLogin, options, session)
+}
+
+// LoginPasskey completes a passwordless login. The authenticator verified
+// the user, so the passkey counts as both factors.
+func (s service) LoginPasskey(ctx context.Context, challengeID string, response []byte) (*domain.Login, error) {
+	user, err := s.verifyPasskeyLogin(ctx, challengeID, response)
+	if err != nil {
+		userID := ""
+		if user != nil {
+			userID = user.ID
+		}
+		s.audit(ctx, domain.AuditPasskeyLogin, userID, userID, err, nil)
+		return nil, err
+	}
+
+	sessionID, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.auth.Create(user.ID, user.Username, sessionID, true, 3000)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, domain.AuditPasskeyLogin, user.ID, user.ID, nil, nil)
+	return &domain.Login{
+		Status: "ok",
+		Token:  token,
+	}, nil
+}
+
+func (s service) verifyPasskeyLogin(ctx context.Context, challengeID string, response []byte) (*domain.User, error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	challenge, err := s.takeChallenge(ctx, challengeID, domain.PasskeyLogin, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var user *domain.User
+	var passkeys []domain.Passkey
+	_, cred, err := s.passkeys.FinishDiscoverableLogin(challenge.Session, response, func(userID string) (*passkey.User, error) {
+		u, err := s.repo.Get(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		pu, pks, err := s.passkeyUser(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+
+		user, passkeys = u, pks
+		return pu, nil
+	})
+	if err != nil {
+		return user, s.passkeyFailed(err)
+	}
+
+	return user, s.usePasskey(ctx, passkeys, cred)
+}
+
+// passkeyFailed logs why a WebAuthn response was rejected, the client
+// only learns that it's invalid.
+func (s service) passkeyFailed(err error) error {
+	var protoErr *protocol.Error
+	if !errors.As(err, &protoErr) {
+		return err
+	}
+
+	s.log.Println("passkey:", protoErr.Type, protoErr.Details, protoErr.DevInfo)
+	return ErrPasskeyInvalid
+}
+
+// passkeyUser returns the user as seen by the WebAuthn ceremonies,
+// together with its stored passkeys.
+func (s service) passkeyUser(ctx context.Context, user *domain.User) (*passkey.User, []domain.Passkey, error) {
+	passkeys, err := s.repo.GetPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pu := &passkey.User{
+		ID:          user.ID,
+		Name:        user.Username,
+		DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Credentials: make([]webauthn.Credential, len(passkeys)),
+	}
+	if pu.DisplayName == "" {
+		pu.DisplayName = user.Username
+	}
+
+	for i, p := range passkeys {
+		var transports []protocol.AuthenticatorTransport
+		for _, t := range strings.Split(p.Transports, ",") {
+			if t != "" {
+				transports = append(transports, protocol.AuthenticatorTransport(t))
+			}
+		}
+
+		pu.Credentials[i] = webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		}
+	}
+	return pu, passkeys, nil
+}
+
+// usePasskey stores the sign counter of the passkey the assertion was made with.
+func (s service) usePasskey(ctx context.Context, passkeys []domain.Passkey, cred *webauthn.Credential) error {
+	for _, p := range passkeys {
+		if bytes.Equal(p.CredentialID, cred.ID) {
+			return s.repo.UsePasskey(ctx, p.ID, cred.Authenticator.SignCount, cred.Flags.BackupState)
+		}
+	}
+	return ErrPasskeyNotFound{base64.RawURLEncoding.EncodeToString(cred.ID)}
+}
+
+func (s service) beginCeremony(ctx context.Context, userID string, ceremony domain.PasskeyCeremony, options interface{}, session []byte) (*PasskeyCeremony, error) {
+	challenge := domain.PasskeyChallenge{
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Session:   session,
+		ExpiresAt: time.Now().Add(passkeyChallengeTTL),
+	}
+	if err := s.repo.CreateChallenge(ctx, &challenge); err != nil {
+		return nil, err
+	}
+
+	return &PasskeyCeremony{
+		ChallengeID: challenge.ID,
+		Options:     options,
+	}, nil
+}
+
+// takeChallenge consumes the challenge, which must belong to the user
+// it was started for and not be expired.
+func (s service) takeChallenge(ctx context.Context, id string, ceremony domain.PasskeyCeremony, userID string) (*domain.PasskeyChallenge, error) {
+	challenge, err := s.repo.TakeChallenge(ctx, id, ceremony)
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge.UserID != userID || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrChallengeNotFound{id}
+	}
+	return challenge, nil
+}
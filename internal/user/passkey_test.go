@@ -0,0 +1,301 @@
+package user_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/ncostamagna/axul_auth/auth"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/internal/user"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey/passkeytest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passkeyOrigin = "https://users.example.com"
+
+type passkeyEnv struct {
+	srv     user.Service
+	repo    *memRepo
+	auditor *memAuditor
+	auth    auth.Auth
+	user    domain.User
+	authn   *passkeytest.Authenticator
+}
+
+// newPasskeyEnv returns a service holding one user, jdoe with the
+// password "secret", who has registered the software authenticator.
+func newPasskeyEnv(t *testing.T) *passkeyEnv {
+	t.Helper()
+
+	password, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := domain.User{
+		ID:        "0b8f0f36-3c43-4a35-9f0e-0f7d1c3d7e51",
+		Username:  "jdoe",
+		FirstName: "John",
+		LastName:  "Doe",
+		Password:  string(password),
+	}
+
+	a, err := auth.New("test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passkeys, err := passkey.New("users.example.com", "Users", []string{passkeyOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &passkeyEnv{
+		repo:    newMemRepo(u),
+		auditor: &memAuditor{},
+		auth:    a,
+		user:    u,
+	}
+	env.srv = user.NewService(log.New(io.Discard, "", 0), a, nil, env.repo, nil, env.auditor, nil, nil, passkeys, user.MagicLinkConfig{}, nil)
+
+	if env.authn, err = passkeytest.New(passkeyOrigin); err != nil {
+		t.Fatal(err)
+	}
+	env.register(t)
+	return env
+}
+
+func (env *passkeyEnv) register(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+
+	ceremony, err := env.srv.BeginPasskeyRegistration(ctx, &env.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := env.authn.Register(ceremony.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := env.srv.FinishPasskeyRegistration(ctx, &env.user, ceremony.ChallengeID, "laptop", response)
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	if p.UserID != env.user.ID || p.Name != "laptop" || p.SignCount != 0 {
+		t.Fatalf("registered %+v", p)
+	}
+}
+
+// login2FA logs in with the password and completes the
+// login with a passkey assertion.
+func (env *passkeyEnv) login2FA(t *testing.T) (*domain.Login, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	login, err := env.srv.Login(ctx, "jdoe", "secret", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !login.TwoFactor || login.PreferredFactor != user.FactorPasskey || login.Token != "" {
+		t.Fatalf("login = %+v, want the passkey as second factor", login)
+	}
+
+	ceremony, err := env.srv.BeginPasskey2FA(ctx, &env.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := env.authn.Assert(ceremony.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env.srv.Login2FAPasskey(ctx, &env.user, ceremony.ChallengeID, response, false)
+}
+
+func (env *passkeyEnv) assertToken(t *testing.T, token string) {
+	t.Helper()
+	claims, err := env.auth.Check(token)
+	if err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	if claims.ID != env.user.ID || !claims.Authorized {
+		t.Errorf("token of %q authorized %v, want an authorized token of %q", claims.ID, claims.Authorized, env.user.ID)
+	}
+}
+
+func TestLogin2FAPasskey(t *testing.T) {
+	env := newPasskeyEnv(t)
+
+	for want := uint32(1); want <= 2; want++ {
+		login, err := env.login2FA(t)
+		if err != nil {
+			t.Fatalf("login %d: %v", want, err)
+		}
+		if !login.TwoFactor {
+			t.Errorf("login = %+v, want it completed with 2FA", login)
+		}
+		env.assertToken(t, login.Token)
+
+		if got := env.repo.passkey(env.authn.CredentialID).SignCount; got != want {
+			t.Errorf("stored sign count = %d, want %d", got, want)
+		}
+	}
+
+	if entry := env.auditor.last(); entry.Action != domain.AuditLogin2FA || entry.Outcome != domain.AuditOutcomeOK {
+		t.Errorf("audited %s %s, want a successful %s", entry.Action, entry.Outcome, domain.AuditLogin2FA)
+	}
+}
+
+func TestLogin2FAPasskeyCloned(t *testing.T) {
+	env := newPasskeyEnv(t)
+
+	if _, err := env.login2FA(t); err != nil {
+		t.Fatal(err)
+	}
+	logins, _ := env.repo.CountLogins(context.Background(), env.user.ID)
+
+	// a clone still at the counter of the first login
+	env.authn.Counter = 1
+	if _, err := env.login2FA(t); !errors.Is(err, passkey.ErrCloned) {
+		t.Fatalf("err = %v, want ErrCloned", err)
+	}
+
+	if got := env.repo.passkey(env.authn.CredentialID).SignCount; got != 1 {
+		t.Errorf("stored sign count = %d, want it left at 1", got)
+	}
+	if n, _ := env.repo.CountLogins(context.Background(), env.user.ID); n != logins {
+		t.Errorf("%d sessions started, want none", n-logins)
+	}
+	if entry := env.auditor.last(); entry.Action != domain.AuditLogin2FA || entry.Outcome != domain.AuditOutcomeFailed {
+		t.Errorf("audited %s %s, want a failed %s", entry.Action, entry.Outcome, domain.AuditLogin2FA)
+	}
+}
+
+func TestLogin2FAPasskeyChallengeReused(t *testing.T) {
+	env := newPasskeyEnv(t)
+	ctx := context.Background()
+
+	ceremony, err := env.srv.BeginPasskey2FA(ctx, &env.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := env.authn.Assert(ceremony.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.srv.Login2FAPasskey(ctx, &env.user, ceremony.ChallengeID, response, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var notFound user.ErrChallengeNotFound
+	if _, err := env.srv.Login2FAPasskey(ctx, &env.user, ceremony.ChallengeID, response, false); !errors.As(err, &notFound) {
+		t.Errorf("replayed the assertion: err = %v, want ErrChallengeNotFound", err)
+	}
+}
+
+func TestLogin2FAPasskeyOtherUser(t *testing.T) {
+	env := newPasskeyEnv(t)
+	ctx := context.Background()
+
+	ceremony, err := env.srv.BeginPasskey2FA(ctx, &env.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := env.authn.Assert(ceremony.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the challenge was started for jdoe
+	other := &domain.User{ID: "5a3b2f1e-7c10-4f1c-8f0e-4d439a556a5e", Username: "other"}
+	var notFound user.ErrChallengeNotFound
+	if _, err := env.srv.Login2FAPasskey(ctx, other, ceremony.ChallengeID, response, false); !errors.As(err, &notFound) {
+		t.Errorf("err = %v, want ErrChallengeNotFound", err)
+	}
+}
+
+func TestLogin2FAPasskeyInvalid(t *testing.T) {
+	env := newPasskeyEnv(t)
+
+	// a phishing site relaying the ceremony
+	env.authn.Origin = "https://users.example.com.evil.test"
+	if _, err := env.login2FA(t); !errors.Is(err, user.ErrPasskeyInvalid) {
+		t.Errorf("err = %v, want ErrPasskeyInvalid", err)
+	}
+}
+
+func TestLoginPasskey(t *testing.T) {
+	env := newPasskeyEnv(t)
+	ctx := context.Background()
+
+	ceremony, err := env.srv.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := env.authn.Assert(ceremony.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login, err := env.srv.LoginPasskey(ctx, ceremony.ChallengeID, response)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	env.assertToken(t, login.Token)
+
+	if got := env.repo.passkey(env.authn.CredentialID).SignCount; got != 1 {
+		t.Errorf("stored sign count = %d, want 1", got)
+	}
+	if entry := env.auditor.last(); entry.Action != domain.AuditPasskeyLogin || entry.Outcome != domain.AuditOutcomeOK || entry.TargetID != env.user.ID {
+		t.Errorf("audited %+v, want a successful %s of the user", entry, domain.AuditPasskeyLogin)
+	}
+}
+
+func TestLoginPasskeyCloned(t *testing.T) {
+	env := newPasskeyEnv(t)
+	ctx := context.Background()
+
+	if err := env.repo.UsePasskey(ctx, env.repo.passkey(env.authn.CredentialID).ID, 10, false); err != nil {
+		t.Fatal(err)
+	}
+	env.authn.Counter = 3
+
+	ceremony, err := env.srv.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := env.authn.Assert(ceremony.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.srv.LoginPasskey(ctx, ceremony.ChallengeID, response); !errors.Is(err, passkey.ErrCloned) {
+		t.Errorf("err = %v, want ErrCloned", err)
+	}
+	if entry := env.auditor.last(); entry.Action != domain.AuditPasskeyLogin || entry.Outcome != domain.AuditOutcomeFailed || entry.TargetID != env.user.ID {
+		t.Errorf("audited %+v, want a failed %s of the user", entry, domain.AuditPasskeyLogin)
+	}
+}
+
+func TestLoginPasskeyUnknownUser(t *testing.T) {
+	env := newPasskeyEnv(t)
+	ctx := context.Background()
+
+	ceremony, err := env.srv.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the account was removed since the passkey was registered
+	env.authn.UserHandle = []byte("5a3b2f1e-7c10-4f1c-8f0e-4d439a556a5e")
+	response, err := env.authn.Assert(ceremony.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := env.srv.LoginPasskey(ctx, ceremony.ChallengeID, response); err == nil {
+		t.Error("logged in a user that doesn't exist")
+	}
+}
@@ -0,0 +1,229 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+)
+
+// patchDoc is the patchable representation of a user, the members a
+// client can change through PUT and PATCH. Clearing a member sets it to "".
+type patchDoc map[string]string
+
+var patchFields = []string{"first_name", "last_name", "email", "phone"}
+
+func newPatchDoc(u *domain.User) patchDoc {
+	return patchDoc{
+		"first_name": u.FirstName,
+		"last_name":  u.LastName,
+		"email":      u.Email,
+		"phone":      u.Phone,
+	}
+}
+
+func isPatchField(name string) bool {
+	for _, f := range patchFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// patchValue decodes a member value, null clears the member.
+func patchValue(member string, raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", ErrInvalidPatch{i18n.Msg("patch_missing_value", member)}
+	}
+
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return "", nil
+	}
+
+	var v string
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", ErrInvalidPatch{i18n.Msg("patch_not_string", member)}
+	}
+	return v, nil
+}
+
+// applyMergePatch applies an RFC 7386 merge patch and returns the
+// members and attributes it touched, a null attribute removes it.
+func applyMergePatch(patch map[string]json.RawMessage) (patchDoc, map[string]interface{}, error) {
+	doc := patchDoc{}
+	var attributes map[string]interface{}
+	for member, raw := range patch {
+		if member == "attributes" {
+			if err := json.Unmarshal(raw, &attributes); err != nil || attributes == nil {
+				return nil, nil, ErrInvalidPatch{i18n.Msg("patch_attributes_not_object")}
+			}
+			continue
+		}
+
+		if !isPatchField(member) {
+			return nil, nil, ErrInvalidPatch{i18n.Msg("patch_not_patchable", member)}
+		}
+
+		v, err := patchValue(member, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		doc[member] = v
+	}
+	return doc, attributes, nil
+}
+
+// applyJSONPatch applies RFC 6902 operations to doc and to the user
+// attributes in attrs. Every path must point to a member of the user or
+// to one of its attributes, as /attributes/{name}, a removed attribute
+// is left as nil.
+func applyJSONPatch(doc patchDoc, attrs map[string]interface{}, ops []PatchOp) error {
+	for _, op := range ops {
+		path, attr, err := patchPath(op.Path)
+		if err != nil {
+			return err
+		}
+
+		if attr {
+			if err := applyAttributeOp(attrs, path, op); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch op.Op {
+		case "add", "replace":
+			v, err := patchValue(path, op.Value)
+			if err != nil {
+				return err
+			}
+			doc[path] = v
+
+		case "remove":
+			doc[path] = ""
+
+		case "test":
+			v, err := patchValue(path, op.Value)
+			if err != nil {
+				return err
+			}
+			if doc[path] != v {
+				return ErrPatchTestFailed{op.Path}
+			}
+
+		case "copy", "move":
+			from, fromAttr, err := patchPath(op.From)
+			if err != nil {
+				return err
+			}
+			if fromAttr {
+				return ErrInvalidPatch{i18n.Msg("patch_attributes_copy")}
+			}
+			v := doc[from]
+			if op.Op == "move" {
+				doc[from] = ""
+			}
+			doc[path] = v
+
+		default:
+			return ErrInvalidPatch{i18n.Msg("unsupported_operation", op.Op)}
+		}
+	}
+	return nil
+}
+
+func applyAttributeOp(attrs map[string]interface{}, name string, op PatchOp) error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return ErrInvalidPatch{i18n.Msg("patch_missing_value", op.Path)}
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return ErrInvalidPatch{i18n.Msg("patch_invalid_value", op.Path)}
+		}
+
+		if op.Op != "test" {
+			attrs[name] = v
+		} else if !reflect.DeepEqual(attrs[name], v) {
+			return ErrPatchTestFailed{op.Path}
+		}
+
+	case "remove":
+		attrs[name] = nil
+
+	case "copy", "move":
+		return ErrInvalidPatch{i18n.Msg("patch_attributes_copy")}
+
+	default:
+		return ErrInvalidPatch{i18n.Msg("unsupported_operation", op.Op)}
+	}
+	return nil
+}
+
+// patchPath returns the member the pointer points to, attr is set when
+// it is an attribute.
+func patchPath(pointer string) (member string, attr bool, err error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return "", false, ErrInvalidPatch{i18n.Msg("patch_invalid_path", pointer)}
+	}
+
+	unescape := strings.NewReplacer("~1", "/", "~0", "~").Replace
+	if name, ok := strings.CutPrefix(pointer, "/attributes/"); ok && name != "" && !strings.Contains(name, "/") {
+		return unescape(name), true, nil
+	}
+
+	member = unescape(pointer[1:])
+	if !isPatchField(member) {
+		return "", false, ErrInvalidPatch{i18n.Msg("patch_not_patchable", pointer)}
+	}
+	return member, false, nil
+}
+
+// attributeChanges returns the attributes whose value differs from
+// the one in before.
+func attributeChanges(before, after map[string]interface{}) map[string]interface{} {
+	changes := make(map[string]interface{})
+	for name, v := range after {
+		if !reflect.DeepEqual(before[name], v) {
+			changes[name] = v
+		}
+	}
+	return changes
+}
+
+// replaceAttributes returns the changes that leave values as the
+// attributes of a user holding before, the omitted ones are removed.
+// Every required attribute must be given.
+func replaceAttributes(defs []domain.Attribute, before, values map[string]interface{}) (map[string]interface{}, error) {
+	for _, def := range defs {
+		if def.Required && values[def.Name] == nil {
+			return nil, ErrInvalidAttributeValue{def.Name, i18n.Msg("attribute_value_required")}
+		}
+	}
+
+	after := make(map[string]interface{}, len(before)+len(values))
+	for name := range before {
+		after[name] = nil
+	}
+	for name, v := range values {
+		after[name] = v
+	}
+	return attributeChanges(before, after), nil
+}
+
+// updateFields returns the values to update, nil for untouched members.
+func (d patchDoc) updateFields() (firstName, lastName, email, phone *string) {
+	field := func(name string) *string {
+		if v, ok := d[name]; ok {
+			return &v
+		}
+		return nil
+	}
+	return field("first_name"), field("last_name"), field("email"), field("phone")
+}
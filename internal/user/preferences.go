@@ -0,0 +1,86 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+)
+
+func (s service) GetPreferences(ctx context.Context, user *domain.User) (*domain.Preferences, error) {
+	p, err := s.repo.GetPreferences(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := p.Resolve()
+	return &prefs, nil
+}
+
+// UpdatePreferences changes the given preferences, an empty locale,
+// timezone or date format and a nil channel go back to the default.
+func (s service) UpdatePreferences(ctx context.Context, user *domain.User, locale, timezone, dateFormat *string, notifications map[string]*bool) (*domain.Preferences, error) {
+	p, err := s.repo.GetPreferences(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if locale != nil {
+		p.Locale = ""
+		if *locale != "" {
+			if p.Locale, err = i18n.Canonical(*locale); err != nil {
+				return nil, ErrInvalidLocale{*locale}
+			}
+		}
+	}
+
+	if timezone != nil {
+		if *timezone != "" && (*timezone == "Local" || !validTimezone(*timezone)) {
+			return nil, ErrInvalidTimezone{*timezone}
+		}
+		p.Timezone = *timezone
+	}
+
+	if dateFormat != nil {
+		if _, ok := domain.DateFormats[*dateFormat]; *dateFormat != "" && !ok {
+			return nil, ErrInvalidDateFormat{*dateFormat}
+		}
+		p.DateFormat = *dateFormat
+	}
+
+	for channel, optIn := range notifications {
+		switch channel {
+		case domain.ChannelEmail:
+			p.NotifyEmail = optIn
+		case domain.ChannelSMS:
+			p.NotifySMS = optIn
+		case domain.ChannelPush:
+			p.NotifyPush = optIn
+		default:
+			return nil, ErrInvalidChannel{channel}
+		}
+	}
+
+	if err := s.repo.SavePreferences(ctx, p); err != nil {
+		return nil, err
+	}
+
+	prefs := p.Resolve()
+	return &prefs, nil
+}
+
+// preferences returns the preferences in effect for the user, the
+// defaults when they can't be read, as they only shape the messages.
+func preferences(ctx context.Context, repo Repository, userID string) domain.Preferences {
+	p, err := repo.GetPreferences(ctx, userID)
+	if err != nil {
+		return domain.UserPreferences{}.Resolve()
+	}
+	return p.Resolve()
+}
+
+func validTimezone(name string) bool {
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
@@ -0,0 +1,53 @@
+package user_test
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ncostamagna/axul_auth/auth"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/internal/user"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+)
+
+func TestUserLocale(t *testing.T) {
+	u := domain.User{ID: "0b8f0f36-3c43-4a35-9f0e-0f7d1c3d7e51", Username: "jdoe"}
+
+	a, err := auth.New("test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := a.Create(u.ID, u.Username, "", true, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		stored string
+		accept string
+		want   string
+	}{
+		{"unset", "", "es-AR,en;q=0.5", i18n.Spanish},
+		{"stored", "en", "es-AR", i18n.English},
+		{"stored over the header", "es", "en", i18n.Spanish},
+	}
+	for _, tt := range tests {
+		repo := newMemRepo(u)
+		repo.locales = map[string]string{u.ID: tt.stored}
+		srv := user.NewService(log.New(io.Discard, "", 0), a, nil, repo, nil, &memAuditor{}, nil, nil, nil, user.MagicLinkConfig{}, nil)
+
+		r := httptest.NewRequest("GET", "/users/me", nil)
+		r.Header.Set("Accept-Language", tt.accept)
+		ctx := i18n.Populate(r.Context(), r)
+
+		if _, err := srv.GetUserByToken(ctx, token, true); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := i18n.FromContext(ctx); got != tt.want {
+			t.Errorf("%s: language = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
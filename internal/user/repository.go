@@ -4,26 +4,96 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/internal/outbox"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey"
+	"github.com/ncostamagna/go-app-users-lab/pkg/uow"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Searcher ranks users matching a free text query. The repository
+// implements it on top of the database, an external search index can
+// be plugged in by implementing it as well.
+type Searcher interface {
+	Search(ctx context.Context, query string, offset, limit int) ([]domain.User, error)
+	SearchCount(ctx context.Context, query string) (int, error)
+}
+
 type Repository interface {
+	Searcher
 	Create(ctx context.Context, user *domain.User) error
+	CreateBatch(ctx context.Context, users []domain.User) error
+	UsernamesTaken(ctx context.Context, usernames []string) ([]string, error)
 	GetAll(ctx context.Context, filters Filters, offset, limit int) ([]domain.User, error)
 	Get(ctx context.Context, id string) (*domain.User, error)
 	Delete(ctx context.Context, id string) error
-	Update(ctx context.Context, id string, firstName, lastName, email, phone, twoFStatus, twoFCode *string, twoFActive *bool) error
+	Update(ctx context.Context, id string, firstName, lastName, email, phone *string, attributes map[string]interface{}, version *int64) error
 	Count(ctx context.Context, filters Filters) (int, error)
+	Stream(ctx context.Context, filters Filters, fn func(user *domain.User) error) error
+	GetDeleted(ctx context.Context, id string) (*domain.User, error)
+	Restore(ctx context.Context, id string) error
+	Purge(ctx context.Context, id string) error
+	CreateLogin(ctx context.Context, login *domain.UserLogin) error
+	FingerprintSeen(ctx context.Context, userID, fingerprint string) (bool, error)
+	GetLogins(ctx context.Context, userID string, offset, limit int) ([]domain.UserLogin, error)
+	CountLogins(ctx context.Context, userID string) (int, error)
+	GetLogin(ctx context.Context, id string) (*domain.UserLogin, error)
+	RevokeLogin(ctx context.Context, id string) error
+	CreateTrustedDevice(ctx context.Context, device *domain.TrustedDevice) error
+	GetTrustedDevice(ctx context.Context, id string) (*domain.TrustedDevice, error)
+	GetTrustedDevices(ctx context.Context, userID string) ([]domain.TrustedDevice, error)
+	TouchTrustedDevice(ctx context.Context, id string) error
+	RevokeTrustedDevice(ctx context.Context, id string) error
+	CreatePasskey(ctx context.Context, p *domain.Passkey) error
+	GetPasskeys(ctx context.Context, userID string) ([]domain.Passkey, error)
+	CountPasskeys(ctx context.Context, userID string) (int, error)
+	UsePasskey(ctx context.Context, id string, signCount uint32, backupState bool) error
+	DeletePasskey(ctx context.Context, userID, id string) error
+	CreateChallenge(ctx context.Context, challenge *domain.PasskeyChallenge) error
+	TakeChallenge(ctx context.Context, id string, ceremony domain.PasskeyCeremony) (*domain.PasskeyChallenge, error)
+	GetByEmail(ctx context.Context, email string) ([]domain.User, error)
+	CreateMagicLink(ctx context.Context, link *domain.MagicLink) error
+	CountMagicLinks(ctx context.Context, userID string, since time.Time) (int, error)
+	UseMagicLink(ctx context.Context, tokenHash string, check func(link *domain.MagicLink) error) (*domain.MagicLink, error)
+	SetPreferredFactor(ctx context.Context, id, factorID string) error
+	SetAvatar(ctx context.Context, id, avatar string) error
+	CreateFactor(ctx context.Context, f *domain.UserFactor) error
+	GetFactor(ctx context.Context, userID, id string) (*domain.UserFactor, error)
+	GetFactors(ctx context.Context, userID string) ([]domain.UserFactor, error)
+	RenameFactor(ctx context.Context, userID, id, label string) error
+	ApproveFactor(ctx context.Context, userID, id string) error
+	TouchFactor(ctx context.Context, id string) error
+	DeleteFactor(ctx context.Context, userID, id string) error
+	CreateOTP(ctx context.Context, code *domain.OTPCode, since time.Time) error
+	CountOTPs(ctx context.Context, userID string, since time.Time) (int, error)
+	GetOTP(ctx context.Context, userID string, purpose domain.OTPPurpose) (*domain.OTPCode, error)
+	AttemptOTP(ctx context.Context, id string, maxAttempts int) error
+	UseOTP(ctx context.Context, id string) error
+	CreateAttribute(ctx context.Context, a *domain.Attribute) error
+	GetAttribute(ctx context.Context, name string) (*domain.Attribute, error)
+	GetAttributes(ctx context.Context) ([]domain.Attribute, error)
+	UpdateAttribute(ctx context.Context, a *domain.Attribute) error
+	DeleteAttribute(ctx context.Context, name string) error
+	GetUserAttributes(ctx context.Context, userIDs []string) ([]domain.UserAttribute, error)
+	GetPreferences(ctx context.Context, userID string) (*domain.UserPreferences, error)
+	SavePreferences(ctx context.Context, p *domain.UserPreferences) error
 }
 
 type repo struct {
-	log *log.Logger
-	db  *gorm.DB
+	log          *log.Logger
+	db           *gorm.DB
+	fullTextOnce sync.Once
+	fullText     bool
 }
 
+const searchIndex = "idx_users_search"
+
 func NewRepo(log *log.Logger, db *gorm.DB) Repository {
 	return &repo{
 		log: log,
@@ -31,8 +101,33 @@ func NewRepo(log *log.Logger, db *gorm.DB) Repository {
 	}
 }
 
+func (repo *repo) conn(ctx context.Context) *gorm.DB {
+	return uow.DB(ctx, repo.db)
+}
+
+// mutate runs fn in a transaction and writes the events it returns to
+// the outbox in that same transaction.
+func (repo *repo) mutate(ctx context.Context, fn func(tx *gorm.DB) ([]domain.Event, error)) error {
+	return repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		events, err := fn(tx)
+		if err != nil {
+			return err
+		}
+		return outbox.Write(tx, events...)
+	})
+}
+
 func (repo *repo) Create(ctx context.Context, user *domain.User) error {
-	if err := repo.db.WithContext(ctx).Create(user).Error; err != nil {
+	err := repo.mutate(ctx, func(tx *gorm.DB) ([]domain.Event, error) {
+		if err := tx.Create(user).Error; err != nil {
+			return nil, err
+		}
+		if err := setUserAttributes(tx, user.ID, user.Attributes); err != nil {
+			return nil, err
+		}
+		return []domain.Event{domain.NewUserCreatedEvent(user)}, nil
+	})
+	if err != nil {
 		repo.log.Println(err)
 		return err
 	}
@@ -40,13 +135,62 @@ func (repo *repo) Create(ctx context.Context, user *domain.User) error {
 	return nil
 }
 
+// CreateBatch inserts the users in a single transaction,
+// either all of them are created or none.
+func (repo *repo) CreateBatch(ctx context.Context, users []domain.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	err := repo.mutate(ctx, func(tx *gorm.DB) ([]domain.Event, error) {
+		if err := tx.CreateInBatches(&users, len(users)).Error; err != nil {
+			return nil, err
+		}
+
+		events := make([]domain.Event, len(users))
+		for i := range users {
+			if err := setUserAttributes(tx, users[i].ID, users[i].Attributes); err != nil {
+				return nil, err
+			}
+			events[i] = domain.NewUserCreatedEvent(&users[i])
+		}
+		return events, nil
+	})
+	if err != nil {
+		repo.log.Println(err)
+		return err
+	}
+
+	repo.log.Printf("%d users created", len(users))
+	return nil
+}
+
+// UsernamesTaken returns which of the usernames are already in use,
+// soft-deleted users included as they still hold theirs.
+func (repo *repo) UsernamesTaken(ctx context.Context, usernames []string) ([]string, error) {
+	var taken []string
+	if len(usernames) == 0 {
+		return taken, nil
+	}
+
+	err := repo.conn(ctx).Unscoped().Model(&domain.User{}).
+		Where("username in ?", usernames).
+		Pluck("username", &taken).Error
+	if err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return taken, nil
+}
+
 func (repo *repo) GetAll(ctx context.Context, filters Filters, offset, limit int) ([]domain.User, error) {
 	var u []domain.User
 
-	tx := repo.db.WithContext(ctx).Model(&u)
+	tx := repo.conn(ctx).Model(&u)
 	tx = applyFilters(tx, filters)
+	tx = applySort(tx, filters.Sort)
 	tx = tx.Limit(limit).Offset(offset)
-	result := tx.Order("created_at desc").Find(&u)
+	result := tx.Find(&u)
 	if result.Error != nil {
 		repo.log.Println(result.Error)
 		return nil, result.Error
@@ -58,7 +202,7 @@ func (repo *repo) GetAll(ctx context.Context, filters Filters, offset, limit int
 func (repo *repo) Get(ctx context.Context, id string) (*domain.User, error) {
 	user := domain.User{ID: id}
 
-	if err := repo.db.WithContext(ctx).First(&user).Error; err != nil {
+	if err := repo.conn(ctx).First(&user).Error; err != nil {
 		repo.log.Println(err)
 		if err == gorm.ErrRecordNotFound {
 			return nil, ErrNotFound{id}
@@ -71,21 +215,25 @@ func (repo *repo) Get(ctx context.Context, id string) (*domain.User, error) {
 func (repo *repo) Delete(ctx context.Context, id string) error {
 	user := domain.User{ID: id}
 
-	result := repo.db.WithContext(ctx).Delete(&user)
+	return repo.mutate(ctx, func(tx *gorm.DB) ([]domain.Event, error) {
+		result := tx.Delete(&user)
 
-	if result.Error != nil {
-		repo.log.Println(result.Error)
-		return result.Error
-	}
+		if result.Error != nil {
+			repo.log.Println(result.Error)
+			return nil, result.Error
+		}
 
-	if result.RowsAffected == 0 {
-		repo.log.Printf("user %s doesn't exists", id)
-		return ErrNotFound{id}
-	}
-	return nil
+		if result.RowsAffected == 0 {
+			repo.log.Printf("user %s doesn't exists", id)
+			return nil, ErrNotFound{id}
+		}
+		return []domain.Event{domain.NewUserEvent(domain.UserDeleted, id)}, nil
+	})
 }
 
-func (repo *repo) Update(ctx context.Context, id string, firstName, lastName, email, phone, twoFStatus, twoFCode *string, twoFActive *bool) error {
+// Update changes the given fields and attributes, a nil attribute
+// removes its value.
+func (repo *repo) Update(ctx context.Context, id string, firstName, lastName, email, phone *string, attributes map[string]interface{}, version *int64) error {
 
 	values := make(map[string]interface{})
 
@@ -105,51 +253,391 @@ func (repo *repo) Update(ctx context.Context, id string, firstName, lastName, em
 		values["phone"] = *phone
 	}
 
-	if twoFStatus != nil {
-		values["two_f_status"] = *twoFStatus
+	values["version"] = gorm.Expr("version + 1")
+
+	return repo.mutate(ctx, func(db *gorm.DB) ([]domain.Event, error) {
+		tx := db.Model(&domain.User{}).Where("id = ?", id)
+		if version != nil {
+			tx = tx.Where("version = ?", *version)
+		}
+		result := tx.Updates(values)
+
+		if result.Error != nil {
+			repo.log.Println(result.Error)
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			if version != nil {
+				var count int64
+				if err := db.Model(&domain.User{}).Where("id = ?", id).Count(&count).Error; err != nil {
+					repo.log.Println(err)
+					return nil, err
+				}
+				if count > 0 {
+					repo.log.Printf("user %s version %d doesn't match", id, *version)
+					return nil, ErrVersionMismatch
+				}
+			}
+			repo.log.Printf("user %s doesn't exists", id)
+			return nil, ErrNotFound{id}
+		}
+
+		if err := setUserAttributes(db, id, attributes); err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+
+		changes := domain.UserChanges(firstName, lastName, email, phone)
+		if len(attributes) > 0 {
+			changes["attributes"] = attributes
+		}
+		return []domain.Event{domain.NewUserUpdatedEvent(id, changes)}, nil
+	})
+}
+
+func (repo *repo) Count(ctx context.Context, filters Filters) (int, error) {
+
+	var count int64
+
+	db := repo.conn(ctx).Model(&domain.User{})
+	db = applyFilters(db, filters)
+
+	if err := db.Count(&count).Error; err != nil {
+		return 0, err
+	}
+
+	repo.log.Println("users counted:", count)
+	return int(count), nil
+
+}
+
+// Stream calls fn for every user matching the filters, reading them one
+// row at a time from the database cursor.
+func (repo *repo) Stream(ctx context.Context, filters Filters, fn func(user *domain.User) error) error {
+
+	tx := repo.conn(ctx).Model(&domain.User{})
+	tx = applyFilters(tx, filters)
+	tx = applySort(tx, filters.Sort)
+
+	rows, err := tx.Rows()
+	if err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u domain.User
+		if err := repo.db.ScanRows(rows, &u); err != nil {
+			repo.log.Println(err)
+			return err
+		}
+		if err := fn(&u); err != nil {
+			return err
+		}
 	}
 
-	if twoFCode != nil {
-		values["two_f_code"] = *twoFCode
+	if err := rows.Err(); err != nil {
+		repo.log.Println(err)
+		return err
 	}
+	return nil
+}
 
-	if twoFActive != nil {
-		values["two_f_active"] = *twoFActive
+func (repo *repo) GetDeleted(ctx context.Context, id string) (*domain.User, error) {
+	user := domain.User{ID: id}
+
+	if err := repo.conn(ctx).Unscoped().Where("deleted IS NOT NULL").First(&user).Error; err != nil {
+		repo.log.Println(err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound{id}
+		}
+		return nil, err
 	}
+	return &user, nil
+}
 
-	result := repo.db.WithContext(ctx).Model(&domain.User{}).Where("id = ?", id).Updates(values)
+func (repo *repo) Restore(ctx context.Context, id string) error {
 
-	if result.Error != nil {
-		repo.log.Println(result.Error)
-		return result.Error
+	err := repo.mutate(ctx, func(tx *gorm.DB) ([]domain.Event, error) {
+		result := tx.Unscoped().Model(&domain.User{}).
+			Where("id = ? AND deleted IS NOT NULL", id).
+			Update("deleted", nil)
+
+		if result.Error != nil {
+			repo.log.Println(result.Error)
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			repo.log.Printf("deleted user %s doesn't exists", id)
+			return nil, ErrNotFound{id}
+		}
+		return []domain.Event{domain.NewUserEvent(domain.UserRestored, id)}, nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if result.RowsAffected == 0 {
-		repo.log.Printf("user %s doesn't exists", id)
-		return ErrNotFound{id}
+	repo.log.Println("user restored with id: ", id)
+	return nil
+}
+
+func (repo *repo) Purge(ctx context.Context, id string) error {
+
+	err := repo.mutate(ctx, func(tx *gorm.DB) ([]domain.Event, error) {
+		result := tx.Unscoped().
+			Where("id = ? AND deleted IS NOT NULL", id).
+			Delete(&domain.User{})
+
+		if result.Error != nil {
+			repo.log.Println(result.Error)
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			repo.log.Printf("deleted user %s doesn't exists", id)
+			return nil, ErrNotFound{id}
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&domain.UserLogin{}).Error; err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&domain.TrustedDevice{}).Error; err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&domain.Passkey{}).Error; err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&domain.MagicLink{}).Error; err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&domain.OTPCode{}).Error; err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&domain.UserFactor{}).Error; err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&domain.UserAttribute{}).Error; err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+
+		if err := tx.Where("user_id = ?", id).Delete(&domain.UserPreferences{}).Error; err != nil {
+			repo.log.Println(err)
+			return nil, err
+		}
+		return []domain.Event{domain.NewUserEvent(domain.UserPurged, id)}, nil
+	})
+	if err != nil {
+		return err
 	}
 
+	repo.log.Println("user purged with id: ", id)
 	return nil
 }
 
-func (repo *repo) Count(ctx context.Context, filters Filters) (int, error) {
+func (repo *repo) CreateLogin(ctx context.Context, login *domain.UserLogin) error {
+	if err := repo.conn(ctx).Create(login).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
 
+// FingerprintSeen reports whether the user already logged in from the device.
+func (repo *repo) FingerprintSeen(ctx context.Context, userID, fingerprint string) (bool, error) {
 	var count int64
 
-	db := repo.db.Model(&domain.User{})
-	db = applyFilters(db, filters)
+	err := repo.conn(ctx).Model(&domain.UserLogin{}).
+		Where("user_id = ? AND fingerprint = ?", userID, fingerprint).
+		Limit(1).Count(&count).Error
+	if err != nil {
+		repo.log.Println(err)
+		return false, err
+	}
+	return count > 0, nil
+}
 
-	if err := db.Count(&count).Error; err != nil {
+func (repo *repo) GetLogins(ctx context.Context, userID string, offset, limit int) ([]domain.UserLogin, error) {
+	var logins []domain.UserLogin
+
+	err := repo.conn(ctx).Where("user_id = ?", userID).
+		Order("created_at desc").Limit(limit).Offset(offset).
+		Find(&logins).Error
+	if err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return logins, nil
+}
+
+func (repo *repo) CountLogins(ctx context.Context, userID string) (int, error) {
+	var count int64
+
+	if err := repo.conn(ctx).Model(&domain.UserLogin{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		repo.log.Println(err)
 		return 0, err
 	}
+	return int(count), nil
+}
+
+func (repo *repo) GetLogin(ctx context.Context, id string) (*domain.UserLogin, error) {
+	login := domain.UserLogin{ID: id}
+
+	if err := repo.conn(ctx).First(&login).Error; err != nil {
+		repo.log.Println(err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrLoginNotFound{id}
+		}
+		return nil, err
+	}
+	return &login, nil
+}
+
+// RevokeLogin marks the login as not made by the user and revokes its session.
+func (repo *repo) RevokeLogin(ctx context.Context, id string) error {
+	err := repo.conn(ctx).Model(&domain.UserLogin{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"not_me":     true,
+			"revoked_at": gorm.Expr("COALESCE(revoked_at, ?)", time.Now()),
+		}).Error
+	if err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) Search(ctx context.Context, query string, offset, limit int) ([]domain.User, error) {
+	var u []domain.User
+
+	tx, ok := repo.applySearch(repo.conn(ctx).Model(&u), query, true)
+	if !ok {
+		return u, nil
+	}
+
+	if err := tx.Limit(limit).Offset(offset).Find(&u).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return u, nil
+}
+
+func (repo *repo) SearchCount(ctx context.Context, query string) (int, error) {
+	var count int64
+
+	tx, ok := repo.applySearch(repo.conn(ctx).Model(&domain.User{}), query, false)
+	if !ok {
+		return 0, nil
+	}
 
-	repo.log.Println("Numero de todos los registros", count)
+	if err := tx.Count(&count).Error; err != nil {
+		repo.log.Println(err)
+		return 0, err
+	}
 	return int(count), nil
+}
+
+// hasFullText reports whether the users table has the full-text index,
+// it is checked once and cached for the life of the repository.
+func (repo *repo) hasFullText() bool {
+	repo.fullTextOnce.Do(func() {
+		repo.fullText = repo.db.Dialector.Name() == "mysql" &&
+			repo.db.Migrator().HasIndex(&domain.User{}, searchIndex)
+	})
+	return repo.fullText
+}
+
+// applySearch adds the search conditions and, when rank is set, the
+// relevance ordering. Case and accent folding rely on the column collation.
+// It returns false when the query has nothing to search for.
+func (repo *repo) applySearch(tx *gorm.DB, query string, rank bool) (*gorm.DB, bool) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return tx, false
+	}
+
+	if repo.hasFullText() {
+		var b strings.Builder
+		for _, t := range terms {
+			fmt.Fprintf(&b, "+%s* ", t)
+		}
+		against := strings.TrimSpace(b.String())
+		match := "MATCH(first_name, last_name, username, email) AGAINST (? IN BOOLEAN MODE)"
+
+		tx = tx.Where(match, against)
+		if rank {
+			tx = tx.Select("*, "+match+" AS score", against).Order("score desc").Order("created_at desc")
+		}
+		return tx, true
+	}
+
+	var score []string
+	var args []interface{}
+	for _, t := range terms {
+		contains := fmt.Sprintf("%%%s%%", t)
+		prefix := fmt.Sprintf("%s%%", t)
+
+		tx = tx.Where("(lower(first_name) like ? or lower(last_name) like ? or lower(username) like ? or lower(email) like ?)",
+			contains, contains, contains, contains)
+
+		score = append(score, "(lower(username) = ?) * 8 + (lower(first_name) like ?) * 4 + (lower(last_name) like ?) * 4 + "+
+			"(lower(username) like ?) * 2 + (lower(email) like ?)")
+		args = append(args, t, prefix, prefix, prefix, prefix)
+	}
+
+	if rank {
+		tx = tx.Select("*, ("+strings.Join(score, " + ")+") AS score", args...).Order("score desc").Order("created_at desc")
+	}
+	return tx, true
+}
 
+// searchTerms lower-cases the query and splits it into words, dropping the
+// characters with special meaning in full-text and like expressions.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, t := range strings.Fields(strings.ToLower(query)) {
+		t = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`+-<>()~*"@%_\'`, r) {
+				return -1
+			}
+			return r
+		}, t)
+		if t != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
 }
 
 func applyFilters(tx *gorm.DB, filters Filters) *gorm.DB {
 
+	if filters.Deleted != nil && *filters.Deleted {
+		tx = tx.Unscoped().Where("deleted IS NOT NULL")
+		if filters.DeletedBefore != nil {
+			tx = tx.Where("deleted < ?", *filters.DeletedBefore)
+		}
+	}
+
+	if len(filters.IDs) > 0 {
+		tx = tx.Where("id in ?", filters.IDs)
+	}
+
 	if filters.FirstName != "" {
 		filters.FirstName = fmt.Sprintf("%%%s%%", strings.ToLower(filters.FirstName))
 		tx = tx.Where("lower(first_name) like ?", filters.FirstName)
@@ -163,5 +651,656 @@ func applyFilters(tx *gorm.DB, filters Filters) *gorm.DB {
 		tx = tx.Where("lower(username) = ?", strings.ToLower(filters.Username))
 	}
 
+	if filters.Email != "" {
+		filters.Email = fmt.Sprintf("%%%s%%", strings.ToLower(filters.Email))
+		tx = tx.Where("lower(email) like ?", filters.Email)
+	}
+
+	if filters.Phone != "" {
+		filters.Phone = fmt.Sprintf("%%%s%%", filters.Phone)
+		tx = tx.Where("phone like ?", filters.Phone)
+	}
+
+	// the 2FA filters match the users with a factor enrolled,
+	// or with a factor in the given status
+	if filters.TwoFActive != nil {
+		if *filters.TwoFActive {
+			tx = tx.Where("EXISTS (SELECT 1 FROM user_factors WHERE user_factors.user_id = users.id)")
+		} else {
+			tx = tx.Where("NOT EXISTS (SELECT 1 FROM user_factors WHERE user_factors.user_id = users.id)")
+		}
+	}
+
+	if filters.TwoFStatus != "" {
+		tx = tx.Where("EXISTS (SELECT 1 FROM user_factors WHERE user_factors.user_id = users.id AND user_factors.status = ?)", filters.TwoFStatus)
+	}
+
+	// the attribute values are already in their stored form
+	names := make([]string, 0, len(filters.Attributes))
+	for name := range filters.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tx = tx.Where("EXISTS (SELECT 1 FROM user_attributes WHERE user_attributes.user_id = users.id AND user_attributes.name = ? AND user_attributes.value = ?)",
+			name, filters.Attributes[name])
+	}
+
+	if filters.CreatedFrom != nil {
+		tx = tx.Where("created_at >= ?", *filters.CreatedFrom)
+	}
+	if filters.CreatedTo != nil {
+		tx = tx.Where("created_at <= ?", *filters.CreatedTo)
+	}
+
+	if filters.UpdatedFrom != nil {
+		tx = tx.Where("updated_at >= ?", *filters.UpdatedFrom)
+	}
+	if filters.UpdatedTo != nil {
+		tx = tx.Where("updated_at <= ?", *filters.UpdatedTo)
+	}
+
 	return tx
 }
+
+// applySort orders by the given fields, the id breaks the ties so the
+// pages of a listing neither repeat nor skip users.
+func applySort(tx *gorm.DB, sort []Sort) *gorm.DB {
+
+	if len(sort) == 0 {
+		return tx.Order("created_at desc").Order("id")
+	}
+
+	for _, s := range sort {
+		column, ok := sortFields[s.Field]
+		if !ok {
+			continue
+		}
+		if s.Desc {
+			column += " desc"
+		}
+		tx = tx.Order(column)
+	}
+
+	return tx.Order("id")
+}
+
+func (repo *repo) CreateTrustedDevice(ctx context.Context, device *domain.TrustedDevice) error {
+	if err := repo.conn(ctx).Create(device).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) GetTrustedDevice(ctx context.Context, id string) (*domain.TrustedDevice, error) {
+	device := domain.TrustedDevice{ID: id}
+
+	if err := repo.conn(ctx).First(&device).Error; err != nil {
+		repo.log.Println(err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrTrustedDeviceNotFound{id}
+		}
+		return nil, err
+	}
+	return &device, nil
+}
+
+// GetTrustedDevices returns the devices of the user that are still trusted.
+func (repo *repo) GetTrustedDevices(ctx context.Context, userID string) ([]domain.TrustedDevice, error) {
+	var devices []domain.TrustedDevice
+
+	err := repo.conn(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at desc").
+		Find(&devices).Error
+	if err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return devices, nil
+}
+
+func (repo *repo) TouchTrustedDevice(ctx context.Context, id string) error {
+	err := repo.conn(ctx).Model(&domain.TrustedDevice{}).
+		Where("id = ?", id).
+		Update("last_used_at", time.Now()).Error
+	if err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) RevokeTrustedDevice(ctx context.Context, id string) error {
+	err := repo.conn(ctx).Model(&domain.TrustedDevice{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) CreatePasskey(ctx context.Context, p *domain.Passkey) error {
+	if err := repo.conn(ctx).Create(p).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) GetPasskeys(ctx context.Context, userID string) ([]domain.Passkey, error) {
+	var passkeys []domain.Passkey
+
+	if err := repo.conn(ctx).Where("user_id = ?", userID).Order("created_at").Find(&passkeys).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (repo *repo) CountPasskeys(ctx context.Context, userID string) (int, error) {
+	var count int64
+
+	if err := repo.conn(ctx).Model(&domain.Passkey{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		repo.log.Println(err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// UsePasskey stores the counter of a successful assertion. The counter
+// must not go backwards, so two assertions racing can't both be accepted.
+func (repo *repo) UsePasskey(ctx context.Context, id string, signCount uint32, backupState bool) error {
+	result := repo.conn(ctx).Model(&domain.Passkey{}).
+		Where("id = ? AND (sign_count < ? OR sign_count = 0)", id, signCount).
+		Updates(map[string]interface{}{
+			"sign_count":   signCount,
+			"backup_state": backupState,
+			"last_used_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return passkey.ErrCloned
+	}
+	return nil
+}
+
+func (repo *repo) DeletePasskey(ctx context.Context, userID, id string) error {
+	result := repo.conn(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&domain.Passkey{})
+
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrPasskeyNotFound{id}
+	}
+	return nil
+}
+
+func (repo *repo) CreateChallenge(ctx context.Context, challenge *domain.PasskeyChallenge) error {
+	if err := repo.conn(ctx).Create(challenge).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// TakeChallenge returns the challenge and removes it, so each ceremony
+// is finished once. Expired challenges are cleaned up on the way.
+func (repo *repo) TakeChallenge(ctx context.Context, id string, ceremony domain.PasskeyCeremony) (*domain.PasskeyChallenge, error) {
+	var challenge domain.PasskeyChallenge
+
+	err := repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&domain.PasskeyChallenge{}).Error; err != nil {
+			return err
+		}
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND ceremony = ?", id, ceremony).
+			First(&challenge).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&challenge).Error
+	})
+	if err != nil {
+		repo.log.Println(err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrChallengeNotFound{id}
+		}
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// GetByEmail returns the users with exactly that email, case insensitive.
+func (repo *repo) GetByEmail(ctx context.Context, email string) ([]domain.User, error) {
+	var users []domain.User
+
+	if err := repo.conn(ctx).Where("lower(email) = ?", strings.ToLower(email)).Find(&users).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return users, nil
+}
+
+func (repo *repo) CreateMagicLink(ctx context.Context, link *domain.MagicLink) error {
+	if err := repo.conn(ctx).Create(link).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) CountMagicLinks(ctx context.Context, userID string, since time.Time) (int, error) {
+	var count int64
+
+	err := repo.conn(ctx).Model(&domain.MagicLink{}).
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Count(&count).Error
+	if err != nil {
+		repo.log.Println(err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// UseMagicLink marks the link as used when check accepts it. The link is
+// locked meanwhile, so it can't be used twice by concurrent requests.
+func (repo *repo) UseMagicLink(ctx context.Context, tokenHash string, check func(link *domain.MagicLink) error) (*domain.MagicLink, error) {
+	var link domain.MagicLink
+
+	err := repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", tokenHash).
+			First(&link).Error
+		if err != nil {
+			return err
+		}
+
+		if err := check(&link); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		link.UsedAt = &now
+		return tx.Model(&link).Update("used_at", now).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrMagicLinkInvalid
+		}
+		return nil, err
+	}
+	return &link, nil
+}
+
+// SetPreferredFactor sets the factor offered first on login,
+// an empty one clears it.
+func (repo *repo) SetPreferredFactor(ctx context.Context, id, factorID string) error {
+	result := repo.conn(ctx).Model(&domain.User{}).Where("id = ?", id).Update("two_f_preferred", factorID)
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNotFound{id}
+	}
+	return nil
+}
+
+// SetAvatar sets the storage prefix of the user's avatar,
+// an empty one goes back to the generated avatar.
+func (repo *repo) SetAvatar(ctx context.Context, id, avatar string) error {
+	return repo.mutate(ctx, func(tx *gorm.DB) ([]domain.Event, error) {
+		result := tx.Model(&domain.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"avatar":  avatar,
+			"version": gorm.Expr("version + 1"),
+		})
+
+		if result.Error != nil {
+			repo.log.Println(result.Error)
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return nil, ErrNotFound{id}
+		}
+
+		changes := map[string]interface{}{"avatar_url": domain.AvatarURL(id, avatar)}
+		return []domain.Event{domain.NewUserUpdatedEvent(id, changes)}, nil
+	})
+}
+
+func (repo *repo) CreateFactor(ctx context.Context, f *domain.UserFactor) error {
+	if err := repo.conn(ctx).Create(f).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) GetFactor(ctx context.Context, userID, id string) (*domain.UserFactor, error) {
+	var f domain.UserFactor
+
+	if err := repo.conn(ctx).Where("id = ? AND user_id = ?", id, userID).First(&f).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrFactorNotFound{id}
+		}
+		repo.log.Println(err)
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (repo *repo) GetFactors(ctx context.Context, userID string) ([]domain.UserFactor, error) {
+	var factors []domain.UserFactor
+
+	if err := repo.conn(ctx).Where("user_id = ?", userID).Order("created_at").Find(&factors).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return factors, nil
+}
+
+func (repo *repo) RenameFactor(ctx context.Context, userID, id, label string) error {
+	if err := repo.conn(ctx).Model(&domain.UserFactor{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Update("label", label).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// ApproveFactor approves a pending factor, it fails when the
+// factor was already approved by another request.
+func (repo *repo) ApproveFactor(ctx context.Context, userID, id string) error {
+	return repo.mutate(ctx, func(tx *gorm.DB) ([]domain.Event, error) {
+		result := tx.Model(&domain.UserFactor{}).
+			Where("id = ? AND user_id = ? AND status = ?", id, userID, domain.FactorPending).
+			Update("status", domain.FactorApproved)
+
+		if result.Error != nil {
+			repo.log.Println(result.Error)
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return nil, ErrFactorApproved
+		}
+		return []domain.Event{domain.NewUserEvent(domain.UserTwoFAEnabled, userID)}, nil
+	})
+}
+
+func (repo *repo) TouchFactor(ctx context.Context, id string) error {
+	if err := repo.conn(ctx).Model(&domain.UserFactor{}).
+		Where("id = ?", id).
+		Update("last_used_at", time.Now()).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) DeleteFactor(ctx context.Context, userID, id string) error {
+	result := repo.conn(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&domain.UserFactor{})
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrFactorNotFound{id}
+	}
+	return nil
+}
+
+// CreateOTP stores the code, the previous codes of the user
+// for the same purpose stop working.
+// CreateOTP stores a new code in place of the ones of the same purpose,
+// which stop working. Unless it was used, the last code sent since the
+// given time hands its attempts over, so asking for another code doesn't
+// grant more guesses.
+func (repo *repo) CreateOTP(ctx context.Context, code *domain.OTPCode, since time.Time) error {
+	err := repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		var last domain.OTPCode
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND purpose = ? AND created_at >= ?", code.UserID, code.Purpose, since).
+			Order("created_at desc").
+			First(&last).Error
+		switch {
+		case err == nil:
+			if last.UsedAt == nil {
+				code.Attempts = last.Attempts
+			}
+		case err != gorm.ErrRecordNotFound:
+			return err
+		}
+
+		now := time.Now()
+		err = tx.Model(&domain.OTPCode{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", code.UserID, code.Purpose, now).
+			Update("expires_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(code).Error
+	})
+	if err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// CountOTPs counts the codes of every purpose sent to the user since the time given.
+func (repo *repo) CountOTPs(ctx context.Context, userID string, since time.Time) (int, error) {
+	var count int64
+
+	err := repo.conn(ctx).Model(&domain.OTPCode{}).
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Count(&count).Error
+	if err != nil {
+		repo.log.Println(err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (repo *repo) GetOTP(ctx context.Context, userID string, purpose domain.OTPPurpose) (*domain.OTPCode, error) {
+	var code domain.OTPCode
+
+	err := repo.conn(ctx).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Order("created_at desc").
+		First(&code).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInvalidCode
+		}
+		repo.log.Println(err)
+		return nil, err
+	}
+	return &code, nil
+}
+
+// AttemptOTP counts an attempt at the code before it is checked, it
+// fails when the code is used, expired or out of attempts. The check and
+// the count are one statement, so concurrent requests can't go over
+// the limit.
+func (repo *repo) AttemptOTP(ctx context.Context, id string, maxAttempts int) error {
+	result := repo.conn(ctx).Model(&domain.OTPCode{}).
+		Where("id = ? AND attempts < ? AND used_at IS NULL AND expires_at > ?", id, maxAttempts, time.Now()).
+		Update("attempts", gorm.Expr("attempts + 1"))
+
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// UseOTP marks the code as used, it fails when another
+// request already used it.
+func (repo *repo) UseOTP(ctx context.Context, id string) error {
+	result := repo.conn(ctx).Model(&domain.OTPCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// setUserAttributes stores the attribute values of a user, a nil value
+// removes the attribute.
+func setUserAttributes(tx *gorm.DB, userID string, attributes map[string]interface{}) error {
+	for name, v := range attributes {
+		if v == nil {
+			if err := tx.Where("user_id = ? AND name = ?", userID, name).Delete(&domain.UserAttribute{}).Error; err != nil {
+				return err
+			}
+			continue
+		}
+
+		value := domain.UserAttribute{UserID: userID, Name: name, Value: domain.AttributeText(v)}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&value).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *repo) CreateAttribute(ctx context.Context, a *domain.Attribute) error {
+	var count int64
+	if err := repo.conn(ctx).Model(&domain.Attribute{}).Where("name = ?", a.Name).Count(&count).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	if count > 0 {
+		return ErrAttributeExists{a.Name}
+	}
+
+	if err := repo.conn(ctx).Create(a).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (repo *repo) GetAttribute(ctx context.Context, name string) (*domain.Attribute, error) {
+	var a domain.Attribute
+
+	if err := repo.conn(ctx).Where("name = ?", name).First(&a).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrAttributeNotFound{name}
+		}
+		repo.log.Println(err)
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (repo *repo) GetAttributes(ctx context.Context) ([]domain.Attribute, error) {
+	var attributes []domain.Attribute
+
+	if err := repo.conn(ctx).Order("name").Find(&attributes).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// UpdateAttribute saves every setting of the attribute but its name,
+// type and creation time.
+func (repo *repo) UpdateAttribute(ctx context.Context, a *domain.Attribute) error {
+	result := repo.conn(ctx).Model(a).
+		Select("label", "required", "visibility", "pattern", "min", "max", "options", "updated_at").
+		Updates(a)
+
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+// DeleteAttribute removes the attribute together with its values.
+func (repo *repo) DeleteAttribute(ctx context.Context, name string) error {
+	return repo.conn(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("name = ?", name).Delete(&domain.Attribute{})
+		if result.Error != nil {
+			repo.log.Println(result.Error)
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrAttributeNotFound{name}
+		}
+
+		if err := tx.Where("name = ?", name).Delete(&domain.UserAttribute{}).Error; err != nil {
+			repo.log.Println(err)
+			return err
+		}
+		return nil
+	})
+}
+
+func (repo *repo) GetUserAttributes(ctx context.Context, userIDs []string) ([]domain.UserAttribute, error) {
+	var values []domain.UserAttribute
+	if len(userIDs) == 0 {
+		return values, nil
+	}
+
+	if err := repo.conn(ctx).Where("user_id in ?", userIDs).Find(&values).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return values, nil
+}
+
+// GetPreferences returns the stored preferences of the user,
+// empty ones when it never set any.
+func (repo *repo) GetPreferences(ctx context.Context, userID string) (*domain.UserPreferences, error) {
+	p := domain.UserPreferences{UserID: userID}
+
+	if err := repo.conn(ctx).Where("user_id = ?", userID).First(&p).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return &p, nil
+		}
+		repo.log.Println(err)
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (repo *repo) SavePreferences(ctx context.Context, p *domain.UserPreferences) error {
+	if err := repo.conn(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(p).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package user_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/internal/user"
+)
+
+// memRepo keeps in memory what the tested flows store. The methods
+// it doesn't implement panic through the nil Repository.
+type memRepo struct {
+	user.Repository
+
+	mu         sync.Mutex
+	users      []domain.User
+	logins     []domain.UserLogin
+	factors    []domain.UserFactor
+	otps       []domain.OTPCode
+	passkeys   []domain.Passkey
+	challenges map[string]domain.PasskeyChallenge
+	attributes []domain.Attribute
+	values     []domain.UserAttribute
+	locales    map[string]string
+}
+
+func newMemRepo(users ...domain.User) *memRepo {
+	return &memRepo{
+		users:      users,
+		challenges: make(map[string]domain.PasskeyChallenge),
+	}
+}
+
+func (r *memRepo) GetAll(_ context.Context, filters user.Filters, offset, limit int) ([]domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []domain.User
+	for _, u := range r.users {
+		if filters.Username == "" || u.Username == filters.Username {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (r *memRepo) Get(_ context.Context, id string) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, user.ErrNotFound{UserID: id}
+}
+
+func (r *memRepo) GetFactors(_ context.Context, userID string) ([]domain.UserFactor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var factors []domain.UserFactor
+	for _, f := range r.factors {
+		if f.UserID == userID {
+			factors = append(factors, f)
+		}
+	}
+	return factors, nil
+}
+
+func (r *memRepo) GetFactor(_ context.Context, userID, id string) (*domain.UserFactor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.factors {
+		if f.UserID == userID && f.ID == id {
+			return &f, nil
+		}
+	}
+	return nil, user.ErrFactorNotFound{FactorID: id}
+}
+
+func (r *memRepo) TouchFactor(context.Context, string) error {
+	return nil
+}
+
+func (r *memRepo) CreateOTP(_ context.Context, code *domain.OTPCode, since time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	last := true
+	for i := len(r.otps) - 1; i >= 0; i-- {
+		otp := &r.otps[i]
+		if otp.UserID != code.UserID || otp.Purpose != code.Purpose {
+			continue
+		}
+		if last && otp.UsedAt == nil && !otp.CreatedAt.Before(since) {
+			code.Attempts = otp.Attempts
+		}
+		last = false
+		if otp.UsedAt == nil && otp.ExpiresAt.After(now) {
+			otp.ExpiresAt = now
+		}
+	}
+
+	code.ID = uuid.New().String()
+	code.CreatedAt = now
+	r.otps = append(r.otps, *code)
+	return nil
+}
+
+func (r *memRepo) CountOTPs(_ context.Context, userID string, since time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, otp := range r.otps {
+		if otp.UserID == userID && !otp.CreatedAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *memRepo) GetOTP(_ context.Context, userID string, purpose domain.OTPPurpose) (*domain.OTPCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.otps) - 1; i >= 0; i-- {
+		otp := r.otps[i]
+		if otp.UserID == userID && otp.Purpose == purpose && otp.UsedAt == nil {
+			return &otp, nil
+		}
+	}
+	return nil, user.ErrInvalidCode
+}
+
+func (r *memRepo) AttemptOTP(_ context.Context, id string, maxAttempts int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.otps {
+		otp := &r.otps[i]
+		if otp.ID == id && otp.Attempts < maxAttempts && otp.UsedAt == nil && otp.ExpiresAt.After(time.Now()) {
+			otp.Attempts++
+			return nil
+		}
+	}
+	return user.ErrInvalidCode
+}
+
+func (r *memRepo) UseOTP(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.otps {
+		if r.otps[i].ID == id && r.otps[i].UsedAt == nil {
+			now := time.Now()
+			r.otps[i].UsedAt = &now
+			return nil
+		}
+	}
+	return user.ErrInvalidCode
+}
+
+func (r *memRepo) GetPreferences(_ context.Context, userID string) (*domain.UserPreferences, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &domain.UserPreferences{UserID: userID, Locale: r.locales[userID]}, nil
+}
+
+func (r *memRepo) CreateLogin(_ context.Context, login *domain.UserLogin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	login.ID = uuid.New().String()
+	login.CreatedAt = time.Now()
+	r.logins = append(r.logins, *login)
+	return nil
+}
+
+func (r *memRepo) FingerprintSeen(_ context.Context, userID, fingerprint string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, l := range r.logins {
+		if l.UserID == userID && l.Fingerprint == fingerprint {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memRepo) CountLogins(_ context.Context, userID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, l := range r.logins {
+		if l.UserID == userID {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *memRepo) CreatePasskey(_ context.Context, p *domain.Passkey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p.ID = uuid.New().String()
+	p.CreatedAt = time.Now()
+	r.passkeys = append(r.passkeys, *p)
+	return nil
+}
+
+func (r *memRepo) GetPasskeys(_ context.Context, userID string) ([]domain.Passkey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var passkeys []domain.Passkey
+	for _, p := range r.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (r *memRepo) CountPasskeys(ctx context.Context, userID string) (int, error) {
+	passkeys, err := r.GetPasskeys(ctx, userID)
+	return len(passkeys), err
+}
+
+func (r *memRepo) UsePasskey(_ context.Context, id string, signCount uint32, backupState bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.passkeys {
+		if r.passkeys[i].ID == id {
+			now := time.Now()
+			r.passkeys[i].SignCount = signCount
+			r.passkeys[i].BackupState = backupState
+			r.passkeys[i].LastUsedAt = &now
+			return nil
+		}
+	}
+	return user.ErrPasskeyNotFound{PasskeyID: id}
+}
+
+func (r *memRepo) passkey(credentialID []byte) *domain.Passkey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			return &p
+		}
+	}
+	return nil
+}
+
+func (r *memRepo) CreateChallenge(_ context.Context, challenge *domain.PasskeyChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge.ID = uuid.New().String()
+	r.challenges[challenge.ID] = *challenge
+	return nil
+}
+
+func (r *memRepo) TakeChallenge(_ context.Context, id string, ceremony domain.PasskeyCeremony) (*domain.PasskeyChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge, ok := r.challenges[id]
+	if !ok || challenge.Ceremony != ceremony {
+		return nil, user.ErrChallengeNotFound{ChallengeID: id}
+	}
+	delete(r.challenges, id)
+	return &challenge, nil
+}
+
+func (r *memRepo) GetAttributes(context.Context) ([]domain.Attribute, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attributes, nil
+}
+
+func (r *memRepo) GetUserAttributes(_ context.Context, userIDs []string) ([]domain.UserAttribute, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var values []domain.UserAttribute
+	for _, v := range r.values {
+		for _, id := range userIDs {
+			if v.UserID == id {
+				values = append(values, v)
+			}
+		}
+	}
+	return values, nil
+}
+
+// memAuditor keeps the audit entries.
+type memAuditor struct {
+	mu      sync.Mutex
+	entries []domain.AuditEntry
+}
+
+func (a *memAuditor) Record(_ context.Context, entry domain.AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, entry)
+	return nil
+}
+
+func (a *memAuditor) last() domain.AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.entries) == 0 {
+		return domain.AuditEntry{}
+	}
+	return a.entries[len(a.entries)-1]
+}
@@ -0,0 +1,30 @@
+package user
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunRetention purges the users soft-deleted more than maxAge ago every
+// interval, until the context is cancelled.
+func RunRetention(ctx context.Context, l *log.Logger, s Service, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.PurgeExpired(ctx, maxAge)
+		if n > 0 {
+			l.Printf("retention: %d users purged", n)
+		}
+		if err != nil {
+			l.Println("retention:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -6,48 +6,197 @@ import (
 	"fmt"
 	"github.com/ncostamagna/axul_auth/auth"
 	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey"
+	"github.com/ncostamagna/go-app-users-lab/pkg/storage"
 	"github.com/ncostamagna/go-app-users-lab/pkg/twofa"
+	"github.com/ncostamagna/go-app-users-lab/pkg/uow"
 	"golang.org/x/crypto/bcrypt"
+	"io"
 	"log"
+	"strings"
+	"time"
 )
 
 type (
 	Filters struct {
-		FirstName string
-		LastName  string
-		Username  string
+		IDs        []string
+		FirstName  string
+		LastName   string
+		Username   string
+		Email      string
+		Phone      string
+		TwoFActive *bool
+		TwoFStatus string
+		// Attributes matches the users with the given attribute
+		// values, by the service they are in their stored form.
+		Attributes  map[string]string
+		CreatedFrom *time.Time
+		CreatedTo   *time.Time
+		UpdatedFrom *time.Time
+		UpdatedTo   *time.Time
+		Deleted     *bool
+		// DeletedBefore only applies when listing deleted users.
+		DeletedBefore *time.Time
+		Sort          []Sort
+	}
+
+	Sort struct {
+		Field string
+		Desc  bool
 	}
 
 	Service interface {
-		Create(ctx context.Context, firstName, lastName, email, phone, username, password string) (*domain.User, error)
-		Login(ctx context.Context, username, password string) (*domain.Login, error)
-		Login2FA(ctx context.Context, user *domain.User, token string) (*domain.Login, error)
+		Create(ctx context.Context, firstName, lastName, email, phone, username, password string, attributes map[string]interface{}) (*domain.User, error)
+		Login(ctx context.Context, username, password, trustedDevice string) (*domain.Login, error)
+		Login2FA(ctx context.Context, user *domain.User, factorID, code string, rememberDevice bool) (*domain.Login, error)
+		SendLoginCode(ctx context.Context, user *domain.User, factorID string) error
+		SetPreferredFactor(ctx context.Context, user *domain.User, factorID string) error
+		GetFactors(ctx context.Context, user *domain.User) ([]domain.UserFactor, error)
+		AddFactor(ctx context.Context, user *domain.User, factorType, label string) (*Enrollment, error)
+		ConfirmFactor(ctx context.Context, user *domain.User, id, code string) error
+		RenameFactor(ctx context.Context, user *domain.User, id, label string) error
+		RemoveFactor(ctx context.Context, user *domain.User, id string) error
+		SetAvatar(ctx context.Context, user *domain.User, r io.Reader) (*domain.User, error)
+		DeleteAvatar(ctx context.Context, user *domain.User) error
+		GetAvatar(ctx context.Context, id string, size int) (*storage.Object, error)
 		GetUserByToken(ctx context.Context, token string, checkAuthorized bool) (*domain.User, error)
-		Create2FA(ctx context.Context, user *domain.User) error
+		Create2FA(ctx context.Context, user *domain.User) (*Enrollment, error)
 		Get(ctx context.Context, id string) (*domain.User, error)
 		GetAll(ctx context.Context, filters Filters, offset, limit int) ([]domain.User, error)
 		Delete(ctx context.Context, id string) error
-		Update(ctx context.Context, id string, firstName, lastName, email, phone, twoFStatus, twoFCode *string, twoFActive *bool) error
+		Update(ctx context.Context, id string, firstName, lastName, email, phone *string, attributes map[string]interface{}, version *int64) error
+		GetMe(ctx context.Context, user *domain.User) (*domain.User, error)
+		UpdateMe(ctx context.Context, user *domain.User, firstName, lastName, phone *string, attributes map[string]interface{}, version *int64) error
+		GetAttributes(ctx context.Context) ([]domain.Attribute, error)
+		CreateAttribute(ctx context.Context, a *domain.Attribute) error
+		UpdateAttribute(ctx context.Context, a *domain.Attribute) error
+		DeleteAttribute(ctx context.Context, name string) error
+		GetPreferences(ctx context.Context, user *domain.User) (*domain.Preferences, error)
+		UpdatePreferences(ctx context.Context, user *domain.User, locale, timezone, dateFormat *string, notifications map[string]*bool) (*domain.Preferences, error)
 		Count(ctx context.Context, filters Filters) (int, error)
+		Search(ctx context.Context, query string, offset, limit int) ([]domain.User, error)
+		SearchCount(ctx context.Context, query string) (int, error)
+		Restore(ctx context.Context, id string) error
+		Purge(ctx context.Context, id string) error
+		PurgeExpired(ctx context.Context, maxAge time.Duration) (int, error)
+		Import(ctx context.Context, rows []ImportRow, dryRun bool) (*ImportJob, error)
+		GetImport(ctx context.Context, id string) (*ImportJob, error)
+		Export(ctx context.Context, filters Filters, fn func(user *domain.User) error) error
+		Batch(ctx context.Context, ops []BatchOp, atomic bool) ([]BatchResult, error)
+		GetTrustedDevices(ctx context.Context, userID string) ([]domain.TrustedDevice, error)
+		RevokeTrustedDevice(ctx context.Context, userID, deviceID string) error
+		BeginPasskeyRegistration(ctx context.Context, user *domain.User) (*PasskeyCeremony, error)
+		FinishPasskeyRegistration(ctx context.Context, user *domain.User, challengeID, name string, response []byte) (*domain.Passkey, error)
+		GetPasskeys(ctx context.Context, userID string) ([]domain.Passkey, error)
+		DeletePasskey(ctx context.Context, userID, passkeyID string) error
+		BeginPasskey2FA(ctx context.Context, user *domain.User) (*PasskeyCeremony, error)
+		Login2FAPasskey(ctx context.Context, user *domain.User, challengeID string, response []byte, rememberDevice bool) (*domain.Login, error)
+		BeginPasskeyLogin(ctx context.Context) (*PasskeyCeremony, error)
+		LoginPasskey(ctx context.Context, challengeID string, response []byte) (*domain.Login, error)
+		RequestMagicLink(ctx context.Context, username, email string) (string, error)
+		LoginMagicLink(ctx context.Context, token, binding, trustedDevice string) (*domain.Login, error)
+		GetLogins(ctx context.Context, userID string, offset, limit int) ([]domain.UserLogin, error)
+		CountLogins(ctx context.Context, userID string) (int, error)
+		RevokeLogin(ctx context.Context, userID, loginID string) error
 	}
 	service struct {
-		log         *log.Logger
-		auth        auth.Auth
-		twoFaClient twofa.TwoFA
-		repo        Repository
+		log           *log.Logger
+		auth          auth.Auth
+		twoFaClient   twofa.TwoFA
+		repo          Repository
+		uow           uow.UnitOfWork
+		auditor       Auditor
+		loginNotifier LoginNotifier
+		trust         *DeviceTrust
+		passkeys      passkey.Passkey
+		magicLink     MagicLinkConfig
+		factors       map[string]Factor
+		blobs         storage.Storage
+		imports       *importStore
 	}
 )
 
-func NewService(log *log.Logger, auth auth.Auth, twoFaClient twofa.TwoFA, repo Repository) Service {
+const purgeBatchSize = 100
+
+// sortFields whitelists the fields the listing can be ordered by,
+// mapping the public name to its column.
+var sortFields = map[string]string{
+	"id":         "id",
+	"username":   "username",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"email":      "email",
+	"phone":      "phone",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// ParseSort parses a comma separated list of sort fields, e.g.
+// "last_name,-created_at" or "last_name:asc,created_at:desc".
+func ParseSort(value string) ([]Sort, error) {
+	var sort []Sort
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		s := Sort{Field: f}
+		if strings.HasPrefix(f, "-") {
+			s.Field, s.Desc = f[1:], true
+		} else if name, dir, ok := strings.Cut(f, ":"); ok {
+			s.Field = name
+			switch strings.ToLower(dir) {
+			case "asc":
+			case "desc":
+				s.Desc = true
+			default:
+				return nil, ErrInvalidSort{f}
+			}
+		}
+
+		if _, ok := sortFields[s.Field]; !ok {
+			return nil, ErrInvalidSort{f}
+		}
+		sort = append(sort, s)
+	}
+	return sort, nil
+}
+
+func NewService(log *log.Logger, auth auth.Auth, twoFaClient twofa.TwoFA, repo Repository, uow uow.UnitOfWork, auditor Auditor, loginNotifier LoginNotifier, trust *DeviceTrust, passkeys passkey.Passkey, magicLink MagicLinkConfig, blobs storage.Storage) Service {
 	return &service{
-		log:         log,
-		auth:        auth,
-		twoFaClient: twoFaClient,
-		repo:        repo,
+		log:           log,
+		auth:          auth,
+		twoFaClient:   twoFaClient,
+		repo:          repo,
+		uow:           uow,
+		auditor:       auditor,
+		loginNotifier: loginNotifier,
+		trust:         trust,
+		passkeys:      passkeys,
+		magicLink:     magicLink,
+		blobs:         blobs,
+		factors: map[string]Factor{
+			FactorTOTP:  totpFactor{client: twoFaClient, blobs: blobs},
+			FactorEmail: emailFactor{repo: repo, notifier: loginNotifier},
+		},
+		imports: newImportStore(),
 	}
 }
 
-func (s service) Create(ctx context.Context, firstName, lastName, email, phone, username, password string) (*domain.User, error) {
+func (s service) Create(ctx context.Context, firstName, lastName, email, phone, username, password string, attributes map[string]interface{}) (*domain.User, error) {
+
+	defs, err := s.attributeDefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err = newUserAttributes(defs, attributes)
+	if err != nil {
+		s.audit(ctx, domain.AuditUserCreate, "", "", err, nil)
+		return nil, err
+	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -55,91 +204,166 @@ func (s service) Create(ctx context.Context, firstName, lastName, email, phone,
 	}
 
 	user := domain.User{
-		FirstName: firstName,
-		LastName:  lastName,
-		Email:     email,
-		Phone:     phone,
-		Username:  username,
-		Password:  string(hashedPassword),
+		FirstName:  firstName,
+		LastName:   lastName,
+		Email:      email,
+		Phone:      phone,
+		Username:   username,
+		Password:   string(hashedPassword),
+		Attributes: attributes,
 	}
 
 	if err := s.repo.Create(ctx, &user); err != nil {
+		s.audit(ctx, domain.AuditUserCreate, "", "", err, nil)
 		return nil, err
 	}
 
+	s.audit(ctx, domain.AuditUserCreate, "", user.ID, nil, nil)
 	return &user, nil
 }
 
-func (s service) Login(ctx context.Context, username, password string) (*domain.Login, error) {
+// Login skips the 2FA step when trustedDevice is the token
+// of a device the user remembered on a previous 2FA login.
+func (s service) Login(ctx context.Context, username, password, trustedDevice string) (*domain.Login, error) {
 	users, err := s.repo.GetAll(ctx, Filters{Username: username}, 0, 1)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(users) < 1 {
-		return nil, errors.New("user not found")
+		err := errors.New("user not found")
+		s.audit(ctx, domain.AuditLogin, "", "", err, nil)
+		return nil, err
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(users[0].Password), []byte(password)); err != nil {
+		s.audit(ctx, domain.AuditLogin, users[0].ID, users[0].ID, err, nil)
+		return nil, err
+	}
+
+	return s.completeLogin(ctx, &users[0], trustedDevice, domain.AuditLogin)
+}
+
+// completeLogin follows a verified first factor. When the user has a
+// second factor, and the device isn't trusted, the login returns the
+// hash to complete it with, otherwise the session starts.
+func (s service) completeLogin(ctx context.Context, user *domain.User, trustedDevice string, action domain.AuditAction) (*domain.Login, error) {
+	factors, err := s.secondFactors(ctx, user)
+	if err != nil {
 		return nil, err
 	}
 
 	l := &domain.Login{
 		Status:    "ok",
-		TwoFactor: users[0].TwoFActive && users[0].TwoFStatus == string(twofa.APPROVED),
+		TwoFactor: len(factors) > 0,
+		Factors:   factors,
+	}
+
+	if l.TwoFactor && s.isTrustedDevice(ctx, user.ID, trustedDevice) {
+		l.TwoFactor, l.Factors = false, nil
+	}
+
+	if l.TwoFactor {
+		l.PreferredFactor = factors[0].ID
+		// the email code is sent right away, the client can ask for
+		// another one or switch factor through SendLoginCode
+		if factors[0].Type == FactorEmail {
+			if err := s.SendLoginCode(ctx, user, factors[0].ID); err != nil {
+				s.log.Println(err)
+			}
+		}
 	}
 
 	var errAuth error
 	if l.TwoFactor {
-		l.TwoFactorHash, errAuth = s.auth.Create(users[0].ID, users[0].Username, "", false, 60)
+		l.TwoFactorHash, errAuth = s.auth.Create(user.ID, user.Username, "", false, 60)
 	} else {
-		l.Token, errAuth = s.auth.Create(users[0].ID, users[0].Username, "", true, 600)
+		sessionID, err := s.startSession(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		l.Token, errAuth = s.auth.Create(user.ID, user.Username, sessionID, true, 600)
 	}
 
 	if errAuth != nil {
 		return nil, errAuth
 	}
 
+	s.audit(ctx, action, user.ID, user.ID, nil, nil)
 	return l, nil
 }
 
-func (s service) Login2FA(ctx context.Context, user *domain.User, code string) (*domain.Login, error) {
+// Login2FA checks the code against the factor, without a factor ID it
+// is the preferred one. A pending factor is approved by its first code.
+// The device is remembered when rememberDevice is set and device trust
+// is enabled, the returned login then carries its token.
+func (s service) Login2FA(ctx context.Context, user *domain.User, factorID, code string, rememberDevice bool) (*domain.Login, error) {
 
 	if code == "" {
 		return nil, ErrCodeRequired
 	}
 
-	if user.TwoFStatus == string(twofa.PENDING) {
+	if err := s.checkFactor(ctx, user, factorID, code); err != nil {
+		s.audit(ctx, domain.AuditLogin2FA, user.ID, user.ID, err, nil)
+		return nil, err
+	}
 
-		if err := s.twoFaClient.Verify(user.ID, code, user.TwoFCode); err != nil {
-			return nil, err
-		}
+	return s.completeLogin2FA(ctx, user, rememberDevice)
+}
 
-		user.TwoFStatus = string(twofa.APPROVED)
-		user.TwoFActive = true
+func (s service) checkFactor(ctx context.Context, user *domain.User, factorID, code string) error {
+	f, err := s.loginFactor(ctx, user, factorID)
+	if err != nil {
+		return err
+	}
 
-		if err := s.Update(ctx, user.ID, nil, nil, nil, nil, &user.TwoFStatus, &user.TwoFCode, &user.TwoFActive); err != nil {
-			return nil, err
-		}
+	if f.Status == domain.FactorPending {
+		return s.approveFactor(ctx, user, f, code)
+	}
 
-	} else {
+	if err := s.factors[f.Type].Verify(ctx, user, f, code); err != nil {
+		return err
+	}
 
-		if err := s.twoFaClient.Check(user.ID, code, user.TwoFCode); err != nil {
-			return nil, err
-		}
+	// the code was right, failing to record it doesn't fail the login
+	if err := s.repo.TouchFactor(ctx, f.ID); err != nil {
+		s.log.Println(err)
+	}
+	return nil
+}
 
+// completeLogin2FA starts the session once the second factor is verified.
+func (s service) completeLogin2FA(ctx context.Context, user *domain.User, rememberDevice bool) (*domain.Login, error) {
+	sessionID, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, err
 	}
 
-	token, err := s.auth.Create(user.ID, user.Username, "", true, 3000)
+	token, err := s.auth.Create(user.ID, user.Username, sessionID, true, 3000)
 	if err != nil {
 		return nil, err
 	}
 
-	return &domain.Login{
+	s.audit(ctx, domain.AuditLogin2FA, user.ID, user.ID, nil, nil)
+
+	l := &domain.Login{
 		Status:    "ok",
-		TwoFactor: user.TwoFActive,
+		TwoFactor: true,
 		Token:     token,
-	}, nil
+	}
+
+	if rememberDevice && s.trust != nil {
+		// the login already succeeded, failing to remember the device doesn't undo it
+		device, trustToken, err := s.trustDevice(ctx, user)
+		if err != nil {
+			s.log.Println(err)
+		} else {
+			l.TrustedDevice = trustToken
+			l.TrustedDeviceExpiresAt = &device.ExpiresAt
+		}
+	}
+
+	return l, nil
 }
 
 func (s service) GetUserByToken(ctx context.Context, token string, checkAuthorized bool) (*domain.User, error) {
@@ -148,72 +372,240 @@ func (s service) GetUserByToken(ctx context.Context, token string, checkAuthoriz
 		return nil, err
 	}
 	if v.ID == "" {
-		return nil, errors.New("invalid user information")
+		return nil, ErrInvalidUserInfo
 	}
 	if checkAuthorized && !v.Authorized {
-		return nil, errors.New("Unauthorized user")
+		return nil, ErrUnauthorizedUser
+	}
+	if err := s.checkSession(ctx, v.Hash); err != nil {
+		return nil, err
 	}
 
-	user, err := s.Get(ctx, v.ID)
+	user, err := s.repo.Get(ctx, v.ID)
 	if err != nil {
 		return nil, err
 	}
+	user.Password = ""
+
+	// the messages of the request go in the locale the user
+	// picked, unset the Accept-Language of the request is used
+	i18n.SetUserLocale(ctx, func() string {
+		p, err := s.repo.GetPreferences(ctx, user.ID)
+		if err != nil {
+			return ""
+		}
+		return p.Locale
+	})
 	return user, nil
 }
 
-func (s service) Create2FA(ctx context.Context, user *domain.User) error {
+func (s service) Create2FA(ctx context.Context, user *domain.User) (*Enrollment, error) {
+	return s.AddFactor(ctx, user, FactorTOTP, "")
+}
+
+func (s service) GetAll(ctx context.Context, filters Filters, offset, limit int) ([]domain.User, error) {
+
+	filters, err := s.attributeFilters(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.repo.GetAll(ctx, filters, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Password = ""
+	}
+
+	if err := s.attachUsersAttributes(ctx, users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
 
-	if user.TwoFStatus == string(twofa.APPROVED) {
-		return fmt.Errorf("the 2FA status is %s", user.TwoFStatus)
+// Get returns the user, the private attributes are only shown to the admins.
+func (s service) Get(ctx context.Context, id string) (*domain.User, error) {
+	user, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
 	}
-	resp, err := s.twoFaClient.Create(user.ID)
+	user.Password = ""
+
+	defs, err := s.attributeDefs(ctx)
 	if err != nil {
+		return nil, err
+	}
+
+	if err := s.attachAttributes(ctx, defs, !isAdmin(ctx), user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s service) Delete(ctx context.Context, id string) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		s.audit(ctx, domain.AuditUserDelete, "", id, err, nil)
 		return err
 	}
 
-	user.TwoFCode = resp.Hash
-	user.TwoFActive = true
-	user.TwoFStatus = "pending"
+	s.audit(ctx, domain.AuditUserDelete, "", id, nil, nil)
+	return nil
+}
 
-	if err := s.Update(ctx, user.ID, nil, nil, nil, nil, &user.TwoFStatus, &user.TwoFCode, &user.TwoFActive); err != nil {
+// Update changes the given fields and attributes of the user, a nil
+// attribute removes its value.
+func (s service) Update(ctx context.Context, id string, firstName, lastName, email, phone *string, attributes map[string]interface{}, version *int64) error {
+	return s.update(ctx, "", id, firstName, lastName, email, phone, attributes, version, false)
+}
+
+func (s service) update(ctx context.Context, actorID, id string, firstName, lastName, email, phone *string, attributes map[string]interface{}, version *int64, self bool) error {
+	old, err := s.repo.Get(ctx, id)
+	if err != nil {
+		s.audit(ctx, domain.AuditUserUpdate, actorID, id, err, nil)
 		return err
 	}
 
-	if err := s.twoFaClient.GenerateQR(user.ID, resp.Url); err != nil {
+	if len(attributes) > 0 {
+		defs, err := s.attributeDefs(ctx)
+		if err != nil {
+			return err
+		}
+
+		if attributes, err = attributeValues(defs, attributes, self); err != nil {
+			s.audit(ctx, domain.AuditUserUpdate, actorID, id, err, nil)
+			return err
+		}
+
+		if err := s.attachAttributes(ctx, defs, false, old); err != nil {
+			return err
+		}
+	}
+
+	changes := domain.UserChanges(firstName, lastName, email, phone)
+	if len(attributes) > 0 {
+		changes["attributes"] = attributes
+	}
+	diff := userDiff(old, changes)
+
+	if err := s.repo.Update(ctx, id, firstName, lastName, email, phone, attributes, version); err != nil {
+		s.audit(ctx, domain.AuditUserUpdate, actorID, id, err, diff)
 		return err
 	}
+
+	s.audit(ctx, domain.AuditUserUpdate, actorID, id, nil, diff)
 	return nil
 }
 
-func (s service) GetAll(ctx context.Context, filters Filters, offset, limit int) ([]domain.User, error) {
+func (s service) Count(ctx context.Context, filters Filters) (int, error) {
+	filters, err := s.attributeFilters(ctx, filters)
+	if err != nil {
+		return 0, err
+	}
+	return s.repo.Count(ctx, filters)
+}
 
-	users, err := s.repo.GetAll(ctx, filters, offset, limit)
+func (s service) Search(ctx context.Context, query string, offset, limit int) ([]domain.User, error) {
+
+	users, err := s.repo.Search(ctx, query, offset, limit)
 	if err != nil {
 		return nil, err
 	}
 	for i := range users {
 		users[i].Password = ""
 	}
+
+	if err := s.attachUsersAttributes(ctx, users); err != nil {
+		return nil, err
+	}
 	return users, nil
 }
 
-func (s service) Get(ctx context.Context, id string) (*domain.User, error) {
-	user, err := s.repo.Get(ctx, id)
-	if err != nil {
-		return nil, err
+func (s service) SearchCount(ctx context.Context, query string) (int, error) {
+	return s.repo.SearchCount(ctx, query)
+}
+
+func (s service) Restore(ctx context.Context, id string) error {
+	if err := s.repo.Restore(ctx, id); err != nil {
+		s.audit(ctx, domain.AuditUserRestore, "", id, err, nil)
+		return err
 	}
-	user.Password = ""
-	return user, nil
+
+	s.audit(ctx, domain.AuditUserRestore, "", id, nil, nil)
+
+	return nil
 }
 
-func (s service) Delete(ctx context.Context, id string) error {
-	return s.repo.Delete(ctx, id)
+// Purge permanently removes a soft-deleted user together with its
+// 2FA factor and QR file, which frees its username.
+func (s service) Purge(ctx context.Context, id string) error {
+	err := s.purge(ctx, id)
+	s.audit(ctx, domain.AuditUserPurge, "", id, err, nil)
+	return err
 }
 
-func (s service) Update(ctx context.Context, id string, firstName, lastName, email, phone, twoFStatus, twoFCode *string, twoFActive *bool) error {
-	return s.repo.Update(ctx, id, firstName, lastName, email, phone, twoFStatus, twoFCode, twoFActive)
+func (s service) purge(ctx context.Context, id string) error {
+	user, err := s.repo.GetDeleted(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	factors, err := s.repo.GetFactors(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range factors {
+		if err := s.factors[factors[i].Type].Remove(ctx, user, &factors[i]); err != nil {
+			return err
+		}
+	}
+
+	if user.Avatar != "" && s.blobs != nil {
+		s.deleteAvatarFiles(ctx, user.Avatar)
+	}
+
+	if err := s.repo.Purge(ctx, user.ID); err != nil {
+		return err
+	}
+
+	return nil
 }
 
-func (s service) Count(ctx context.Context, filters Filters) (int, error) {
-	return s.repo.Count(ctx, filters)
+// PurgeExpired purges the users that were soft-deleted more than
+// maxAge ago and returns how many were removed. A user that can't be
+// purged is logged and skipped, so it doesn't hold back the others,
+// and the errors are returned together at the end.
+func (s service) PurgeExpired(ctx context.Context, maxAge time.Duration) (int, error) {
+	deleted := true
+	before := time.Now().Add(-maxAge)
+	filters := Filters{
+		Deleted:       &deleted,
+		DeletedBefore: &before,
+		Sort:          []Sort{{Field: "created_at"}},
+	}
+
+	purged := 0
+	var errs []error
+	for {
+		// the purged users leave the listing, the failed ones stay
+		// ahead of the rest, so they are skipped by the offset
+		users, err := s.repo.GetAll(ctx, filters, len(errs), purgeBatchSize)
+		if err != nil {
+			return purged, errors.Join(append(errs, err)...)
+		}
+
+		for _, u := range users {
+			if err := s.Purge(ctx, u.ID); err != nil {
+				s.log.Println("purge", u.ID, err)
+				errs = append(errs, fmt.Errorf("purge user %s: %w", u.ID, err))
+				continue
+			}
+			purged++
+		}
+
+		if len(users) < purgeBatchSize {
+			return purged, errors.Join(errs...)
+		}
+	}
 }
@@ -0,0 +1,126 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/clientinfo"
+)
+
+// DeviceTrust signs the tokens of the remembered devices. A token is
+// the device ID and an HMAC over the device, so it can't be forged nor
+// moved to another user, and it is checked against the stored device,
+// so revoking it takes effect at once.
+type DeviceTrust struct {
+	key []byte
+	ttl time.Duration
+}
+
+func NewDeviceTrust(key string, ttl time.Duration) *DeviceTrust {
+	return &DeviceTrust{
+		key: []byte(key),
+		ttl: ttl,
+	}
+}
+
+func (t *DeviceTrust) sign(d *domain.TrustedDevice) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(strings.Join([]string{d.ID, d.UserID, d.UserAgentHash, strconv.FormatInt(d.ExpiresAt.Unix(), 10)}, "|")))
+	return d.ID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (t *DeviceTrust) verify(d *domain.TrustedDevice, token string) bool {
+	return hmac.Equal([]byte(t.sign(d)), []byte(token))
+}
+
+// trustDevice remembers the device the request comes from
+// and returns the token that identifies it.
+func (s service) trustDevice(ctx context.Context, user *domain.User) (*domain.TrustedDevice, string, error) {
+	info := clientinfo.FromContext(ctx)
+
+	device := domain.TrustedDevice{
+		UserID:        user.ID,
+		UserAgent:     truncate(info.UserAgent, 255),
+		UserAgentHash: userAgentHash(info.UserAgent),
+		IP:            info.IP,
+		// the database keeps whole seconds, the signature must match once read back
+		ExpiresAt: time.Now().Add(s.trust.ttl).Truncate(time.Second),
+	}
+
+	if err := s.repo.CreateTrustedDevice(ctx, &device); err != nil {
+		s.audit(ctx, domain.AuditDeviceTrust, user.ID, user.ID, err, nil)
+		return nil, "", err
+	}
+
+	s.audit(ctx, domain.AuditDeviceTrust, user.ID, user.ID, nil, nil)
+	return &device, s.trust.sign(&device), nil
+}
+
+// isTrustedDevice reports whether the token identifies a device the user
+// trusted, which hasn't expired nor been revoked, and the request comes
+// from that same user agent. Any failure means the device isn't trusted.
+func (s service) isTrustedDevice(ctx context.Context, userID, token string) bool {
+	if s.trust == nil || token == "" {
+		return false
+	}
+
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+
+	device, err := s.repo.GetTrustedDevice(ctx, id)
+	if err != nil {
+		return false
+	}
+
+	if device.UserID != userID || device.RevokedAt != nil || time.Now().After(device.ExpiresAt) {
+		return false
+	}
+
+	if device.UserAgentHash != userAgentHash(clientinfo.FromContext(ctx).UserAgent) || !s.trust.verify(device, token) {
+		return false
+	}
+
+	if err := s.repo.TouchTrustedDevice(ctx, device.ID); err != nil {
+		s.log.Println(err)
+	}
+	return true
+}
+
+func (s service) GetTrustedDevices(ctx context.Context, userID string) ([]domain.TrustedDevice, error) {
+	return s.repo.GetTrustedDevices(ctx, userID)
+}
+
+// RevokeTrustedDevice forgets a device of the user,
+// its next login asks for the 2FA code again.
+func (s service) RevokeTrustedDevice(ctx context.Context, userID, deviceID string) error {
+	err := s.revokeTrustedDevice(ctx, userID, deviceID)
+	s.audit(ctx, domain.AuditDeviceRevoke, userID, userID, err, nil)
+	return err
+}
+
+func (s service) revokeTrustedDevice(ctx context.Context, userID, deviceID string) error {
+	device, err := s.repo.GetTrustedDevice(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if device.UserID != userID {
+		return ErrTrustedDeviceNotFound{deviceID}
+	}
+
+	return s.repo.RevokeTrustedDevice(ctx, deviceID)
+}
+
+func userAgentHash(userAgent string) string {
+	sum := sha256.Sum256([]byte(userAgent))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgnat is the shared address space of carrier-grade NATs, not routable
+// on the internet but reachable inside some networks.
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// NewClient returns the client the deliveries are sent with. It refuses
+// to connect to loopback, private and link-local addresses, checked on
+// every dial so a name resolving to one, or a redirect to one, is refused
+// too.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return ErrPrivateURL
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// publicHost tells whether host may be a public one, names are checked
+// again when they are dialed.
+func publicHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return publicIP(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnat.Contains(ip))
+}
@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+
+	maxAttempts  = 10
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = 12 * time.Hour
+	maxErrorSize = 255
+)
+
+// Dispatcher sends the pending deliveries, retrying the failed ones with
+// exponential backoff until they are delivered or dead-lettered.
+type Dispatcher struct {
+	log       *log.Logger
+	repo      Repository
+	client    *http.Client
+	batchSize int
+}
+
+func NewDispatcher(log *log.Logger, repo Repository, client *http.Client, batchSize int) *Dispatcher {
+	return &Dispatcher{
+		log:       log,
+		repo:      repo,
+		client:    client,
+		batchSize: batchSize,
+	}
+}
+
+// Run dispatches the due deliveries every interval until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := d.Dispatch(ctx)
+			if err != nil {
+				d.log.Println("webhook:", err)
+			}
+			if err != nil || n < d.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch sends a batch of due deliveries and returns how many it read.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	deliveries, err := d.repo.GetDueDeliveries(ctx, d.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	webhooks := make(map[string]*domain.Webhook)
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		webhook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			if webhook, err = d.repo.Get(ctx, delivery.WebhookID); err != nil && !errors.As(err, &ErrNotFound{}) {
+				return len(deliveries), err
+			}
+			webhooks[delivery.WebhookID] = webhook
+		}
+
+		if webhook == nil {
+			// the webhook was deleted, there is nowhere to deliver to
+			delivery.Status = domain.DeliveryDead
+			delivery.LastError = ErrNotFound{delivery.WebhookID}.Error()
+			delivery.NextAttemptAt = nil
+		} else {
+			status, err := d.send(ctx, webhook, delivery)
+			d.record(delivery, status, err)
+		}
+
+		if err := d.repo.SaveAttempt(ctx, delivery); err != nil {
+			return len(deliveries), err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, webhook *domain.Webhook, delivery *domain.WebhookDelivery) (int, error) {
+	if !webhook.Active {
+		return 0, fmt.Errorf("webhook %s is disabled", webhook.ID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-app-users-lab-webhooks")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", string(delivery.EventType))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// record updates the delivery with the outcome of an attempt.
+func (d *Dispatcher) record(delivery *domain.WebhookDelivery, status int, err error) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatusCode = status
+
+	if err == nil {
+		delivery.Status = domain.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+		return
+	}
+
+	msg := err.Error()
+	if len(msg) > maxErrorSize {
+		msg = msg[:maxErrorSize]
+	}
+	delivery.LastError = msg
+
+	if delivery.Attempts >= maxAttempts {
+		d.log.Printf("webhook: delivery %s dead after %d attempts: %s", delivery.ID, delivery.Attempts, msg)
+		delivery.Status = domain.DeliveryDead
+		delivery.NextAttemptAt = nil
+		return
+	}
+
+	backoff := baseBackoff << uint(delivery.Attempts-1)
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	next := now.Add(backoff)
+	delivery.NextAttemptAt = &next
+}
+
+// Sign returns the signature header value, "t=<unix time>,v1=<hex hmac>".
+// The HMAC-SHA256 covers the timestamp and the body, "<t>.<body>", so
+// receivers can reject replayed deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,240 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-http-utils/meta"
+	"github.com/ncostamagna/go-http-utils/response"
+)
+
+type (
+	Controller func(ctx context.Context, request interface{}) (interface{}, error)
+
+	Endpoints struct {
+		Create        Controller
+		Get           Controller
+		GetAll        Controller
+		Update        Controller
+		Delete        Controller
+		GetDeliveries Controller
+		Redeliver     Controller
+	}
+
+	CreateReq struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+
+	GetReq struct {
+		ID string
+	}
+
+	GetAllReq struct {
+		Limit int
+		Page  int
+	}
+
+	UpdateReq struct {
+		ID           string
+		URL          *string  `json:"url"`
+		Events       []string `json:"events"`
+		Active       *bool    `json:"active"`
+		RotateSecret bool     `json:"rotate_secret"`
+	}
+
+	DeleteReq struct {
+		ID string
+	}
+
+	GetDeliveriesReq struct {
+		WebhookID string
+		Status    string
+		Limit     int
+		Page      int
+	}
+
+	RedeliverReq struct {
+		WebhookID  string
+		DeliveryID string
+	}
+
+	Config struct {
+		LimPageDef string
+	}
+)
+
+func MakeEndpoints(s Service, config Config) Endpoints {
+
+	return Endpoints{
+		Create:        makeCreateEndpoint(s),
+		Get:           makeGetEndpoint(s),
+		GetAll:        makeGetAllEndpoint(s, config),
+		Update:        makeUpdateEndpoint(s),
+		Delete:        makeDeleteEndpoint(s),
+		GetDeliveries: makeGetDeliveriesEndpoint(s, config),
+		Redeliver:     makeRedeliverEndpoint(s),
+	}
+
+}
+
+func makeCreateEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(CreateReq)
+
+		if err := ValidateURL(req.URL); err != nil {
+			return nil, response.BadRequest(i18n.Localize(ctx, err))
+		}
+
+		if err := ValidateEvents(req.Events); err != nil {
+			return nil, response.BadRequest(i18n.Localize(ctx, err))
+		}
+
+		webhook, err := s.Create(ctx, req.URL, req.Events, req.Secret)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.Created("success", webhook, nil), nil
+	}
+}
+
+func makeGetEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetReq)
+
+		webhook, err := s.Get(ctx, req.ID)
+		if err != nil {
+			if errors.As(err, &ErrNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", webhook, nil), nil
+	}
+}
+
+func makeGetAllEndpoint(s Service, config Config) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetAllReq)
+
+		count, err := s.Count(ctx)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		meta, err := meta.New(req.Page, req.Limit, count, config.LimPageDef)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		webhooks, err := s.GetAll(ctx, meta.Offset(), meta.Limit())
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", webhooks, meta), nil
+	}
+}
+
+func makeUpdateEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(UpdateReq)
+
+		if req.URL != nil {
+			if err := ValidateURL(*req.URL); err != nil {
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
+		}
+
+		if req.Events != nil {
+			if err := ValidateEvents(req.Events); err != nil {
+				return nil, response.BadRequest(i18n.Localize(ctx, err))
+			}
+		}
+
+		webhook, err := s.Update(ctx, req.ID, req.URL, req.Events, req.Active, req.RotateSecret)
+		if err != nil {
+			if errors.As(err, &ErrNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", webhook, nil), nil
+	}
+}
+
+func makeDeleteEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(DeleteReq)
+
+		if err := s.Delete(ctx, req.ID); err != nil {
+			if errors.As(err, &ErrNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", nil, nil), nil
+	}
+}
+
+func makeGetDeliveriesEndpoint(s Service, config Config) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(GetDeliveriesReq)
+
+		if _, err := s.Get(ctx, req.WebhookID); err != nil {
+			if errors.As(err, &ErrNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		status := domain.DeliveryStatus(req.Status)
+
+		count, err := s.CountDeliveries(ctx, req.WebhookID, status)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		meta, err := meta.New(req.Page, req.Limit, count, config.LimPageDef)
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		deliveries, err := s.GetDeliveries(ctx, req.WebhookID, status, meta.Offset(), meta.Limit())
+		if err != nil {
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.OK("success", deliveries, meta), nil
+	}
+}
+
+func makeRedeliverEndpoint(s Service) Controller {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(RedeliverReq)
+
+		delivery, err := s.Redeliver(ctx, req.WebhookID, req.DeliveryID)
+		if err != nil {
+			if errors.As(err, &ErrDeliveryNotFound{}) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return response.Accepted("success", delivery, nil), nil
+	}
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import "github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+
+var ErrURLRequired = i18n.NewError("webhook_url_required")
+var ErrInvalidURL = i18n.NewError("webhook_invalid_url")
+var ErrEventsRequired = i18n.NewError("webhook_events_required")
+var ErrPrivateURL = i18n.NewError("webhook_private_url")
+
+type ErrInvalidEvent struct {
+	Event string
+}
+
+func (e ErrInvalidEvent) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrInvalidEvent) Localized() i18n.Message {
+	return i18n.Msg("webhook_invalid_event", e.Event)
+}
+
+type ErrNotFound struct {
+	WebhookID string
+}
+
+func (e ErrNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrNotFound) Localized() i18n.Message {
+	return i18n.Msg("webhook_not_found", e.WebhookID)
+}
+
+type ErrDeliveryNotFound struct {
+	DeliveryID string
+}
+
+func (e ErrDeliveryNotFound) Error() string {
+	return e.Localized().In(i18n.English)
+}
+
+func (e ErrDeliveryNotFound) Localized() i18n.Message {
+	return i18n.Msg("webhook_delivery_not_found", e.DeliveryID)
+}
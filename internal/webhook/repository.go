@@ -0,0 +1,245 @@
+package webhook
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+	"github.com/ncostamagna/go-app-users-lab/pkg/uow"
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	Create(ctx context.Context, webhook *domain.Webhook) error
+	GetAll(ctx context.Context, offset, limit int) ([]domain.Webhook, error)
+	GetActive(ctx context.Context) ([]domain.Webhook, error)
+	Get(ctx context.Context, id string) (*domain.Webhook, error)
+	Update(ctx context.Context, id string, url, events, secret *string, active *bool) error
+	Delete(ctx context.Context, id string) error
+	Count(ctx context.Context) (int, error)
+	CreateDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error
+	GetEventWebhooks(ctx context.Context, eventID string) ([]string, error)
+	GetDeliveries(ctx context.Context, webhookID string, status domain.DeliveryStatus, offset, limit int) ([]domain.WebhookDelivery, error)
+	CountDeliveries(ctx context.Context, webhookID string, status domain.DeliveryStatus) (int, error)
+	GetDelivery(ctx context.Context, webhookID, id string) (*domain.WebhookDelivery, error)
+	GetDueDeliveries(ctx context.Context, limit int) ([]domain.WebhookDelivery, error)
+	SaveAttempt(ctx context.Context, delivery *domain.WebhookDelivery) error
+}
+
+type repo struct {
+	log *log.Logger
+	db  *gorm.DB
+}
+
+func NewRepo(log *log.Logger, db *gorm.DB) Repository {
+	return &repo{
+		log: log,
+		db:  db,
+	}
+}
+
+func (repo *repo) conn(ctx context.Context) *gorm.DB {
+	return uow.DB(ctx, repo.db)
+}
+
+func (repo *repo) Create(ctx context.Context, webhook *domain.Webhook) error {
+	if err := repo.conn(ctx).Create(webhook).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	repo.log.Println("webhook created with id: ", webhook.ID)
+	return nil
+}
+
+func (repo *repo) GetAll(ctx context.Context, offset, limit int) ([]domain.Webhook, error) {
+	var w []domain.Webhook
+
+	if err := repo.conn(ctx).Order("created_at desc").Limit(limit).Offset(offset).Find(&w).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return w, nil
+}
+
+func (repo *repo) GetActive(ctx context.Context) ([]domain.Webhook, error) {
+	var w []domain.Webhook
+
+	if err := repo.conn(ctx).Where("active = ?", true).Find(&w).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return w, nil
+}
+
+func (repo *repo) Get(ctx context.Context, id string) (*domain.Webhook, error) {
+	webhook := domain.Webhook{ID: id}
+
+	if err := repo.conn(ctx).First(&webhook).Error; err != nil {
+		repo.log.Println(err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound{id}
+		}
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (repo *repo) Update(ctx context.Context, id string, url, events, secret *string, active *bool) error {
+
+	values := make(map[string]interface{})
+
+	if url != nil {
+		values["url"] = *url
+	}
+
+	if events != nil {
+		values["events"] = *events
+	}
+
+	if secret != nil {
+		values["secret"] = *secret
+	}
+
+	if active != nil {
+		values["active"] = *active
+	}
+
+	result := repo.conn(ctx).Model(&domain.Webhook{}).Where("id = ?", id).Updates(values)
+
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		repo.log.Printf("webhook %s doesn't exists", id)
+		return ErrNotFound{id}
+	}
+
+	return nil
+}
+
+func (repo *repo) Delete(ctx context.Context, id string) error {
+
+	result := repo.conn(ctx).Delete(&domain.Webhook{ID: id})
+
+	if result.Error != nil {
+		repo.log.Println(result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		repo.log.Printf("webhook %s doesn't exists", id)
+		return ErrNotFound{id}
+	}
+	return nil
+}
+
+func (repo *repo) Count(ctx context.Context) (int, error) {
+	var count int64
+
+	if err := repo.conn(ctx).Model(&domain.Webhook{}).Count(&count).Error; err != nil {
+		repo.log.Println(err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (repo *repo) CreateDeliveries(ctx context.Context, deliveries []domain.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := repo.conn(ctx).Create(&deliveries).Error; err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// GetEventWebhooks returns the webhooks that have a delivery of the event.
+func (repo *repo) GetEventWebhooks(ctx context.Context, eventID string) ([]string, error) {
+	var ids []string
+	err := repo.conn(ctx).Model(&domain.WebhookDelivery{}).
+		Where("event_id = ?", eventID).
+		Distinct().Pluck("webhook_id", &ids).Error
+	if err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (repo *repo) GetDeliveries(ctx context.Context, webhookID string, status domain.DeliveryStatus, offset, limit int) ([]domain.WebhookDelivery, error) {
+	var d []domain.WebhookDelivery
+
+	tx := applyDeliveryFilters(repo.conn(ctx).Model(&d), webhookID, status)
+	if err := tx.Order("created_at desc").Limit(limit).Offset(offset).Find(&d).Error; err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return d, nil
+}
+
+func (repo *repo) CountDeliveries(ctx context.Context, webhookID string, status domain.DeliveryStatus) (int, error) {
+	var count int64
+
+	tx := applyDeliveryFilters(repo.conn(ctx).Model(&domain.WebhookDelivery{}), webhookID, status)
+	if err := tx.Count(&count).Error; err != nil {
+		repo.log.Println(err)
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (repo *repo) GetDelivery(ctx context.Context, webhookID, id string) (*domain.WebhookDelivery, error) {
+	var d domain.WebhookDelivery
+
+	if err := repo.conn(ctx).Where("id = ? AND webhook_id = ?", id, webhookID).First(&d).Error; err != nil {
+		repo.log.Println(err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrDeliveryNotFound{id}
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetDueDeliveries returns the pending deliveries whose next attempt is due, oldest first.
+func (repo *repo) GetDueDeliveries(ctx context.Context, limit int) ([]domain.WebhookDelivery, error) {
+	var d []domain.WebhookDelivery
+
+	err := repo.conn(ctx).
+		Where("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", domain.DeliveryPending, time.Now()).
+		Order("created_at").
+		Limit(limit).
+		Find(&d).Error
+	if err != nil {
+		repo.log.Println(err)
+		return nil, err
+	}
+	return d, nil
+}
+
+// SaveAttempt stores the outcome of a delivery attempt.
+func (repo *repo) SaveAttempt(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	err := repo.conn(ctx).Model(delivery).
+		Select("status", "attempts", "last_status_code", "last_error", "next_attempt_at", "delivered_at").
+		Updates(delivery).Error
+	if err != nil {
+		repo.log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func applyDeliveryFilters(tx *gorm.DB, webhookID string, status domain.DeliveryStatus) *gorm.DB {
+	tx = tx.Where("webhook_id = ?", webhookID)
+
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+
+	return tx
+}
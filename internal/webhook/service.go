@@ -0,0 +1,243 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
+)
+
+// events are the event types a webhook can subscribe to, besides "*".
+var events = map[domain.EventType]bool{
+	domain.UserCreated:      true,
+	domain.UserUpdated:      true,
+	domain.UserDeleted:      true,
+	domain.UserRestored:     true,
+	domain.UserPurged:       true,
+	domain.UserTwoFAEnabled: true,
+}
+
+type (
+	Service interface {
+		Create(ctx context.Context, url string, events []string, secret string) (*domain.Webhook, error)
+		Get(ctx context.Context, id string) (*domain.Webhook, error)
+		GetAll(ctx context.Context, offset, limit int) ([]domain.Webhook, error)
+		Count(ctx context.Context) (int, error)
+		Update(ctx context.Context, id string, url *string, events []string, active *bool, rotateSecret bool) (*domain.Webhook, error)
+		Delete(ctx context.Context, id string) error
+		GetDeliveries(ctx context.Context, webhookID string, status domain.DeliveryStatus, offset, limit int) ([]domain.WebhookDelivery, error)
+		CountDeliveries(ctx context.Context, webhookID string, status domain.DeliveryStatus) (int, error)
+		Redeliver(ctx context.Context, webhookID, deliveryID string) (*domain.WebhookDelivery, error)
+		Publish(ctx context.Context, e domain.Event) error
+	}
+
+	service struct {
+		log  *log.Logger
+		repo Repository
+	}
+)
+
+func NewService(log *log.Logger, repo Repository) Service {
+	return &service{
+		log:  log,
+		repo: repo,
+	}
+}
+
+func (s service) Create(ctx context.Context, url string, events []string, secret string) (*domain.Webhook, error) {
+
+	if secret == "" {
+		var err error
+		if secret, err = newSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	webhook := domain.Webhook{
+		URL:    url,
+		Events: strings.Join(events, ","),
+		Secret: secret,
+		Active: true,
+	}
+
+	if err := s.repo.Create(ctx, &webhook); err != nil {
+		return nil, err
+	}
+
+	// the secret is only shown when it is created or rotated
+	return &webhook, nil
+}
+
+func (s service) Get(ctx context.Context, id string) (*domain.Webhook, error) {
+	webhook, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+func (s service) GetAll(ctx context.Context, offset, limit int) ([]domain.Webhook, error) {
+	webhooks, err := s.repo.GetAll(ctx, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+func (s service) Count(ctx context.Context) (int, error) {
+	return s.repo.Count(ctx)
+}
+
+func (s service) Update(ctx context.Context, id string, url *string, events []string, active *bool, rotateSecret bool) (*domain.Webhook, error) {
+
+	var eventList, secret *string
+	if events != nil {
+		joined := strings.Join(events, ",")
+		eventList = &joined
+	}
+
+	if rotateSecret {
+		newSecret, err := newSecret()
+		if err != nil {
+			return nil, err
+		}
+		secret = &newSecret
+	}
+
+	if err := s.repo.Update(ctx, id, url, eventList, secret, active); err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !rotateSecret {
+		webhook.Secret = ""
+	}
+	return webhook, nil
+}
+
+func (s service) Delete(ctx context.Context, id string) error {
+	return s.repo.Delete(ctx, id)
+}
+
+func (s service) GetDeliveries(ctx context.Context, webhookID string, status domain.DeliveryStatus, offset, limit int) ([]domain.WebhookDelivery, error) {
+	return s.repo.GetDeliveries(ctx, webhookID, status, offset, limit)
+}
+
+func (s service) CountDeliveries(ctx context.Context, webhookID string, status domain.DeliveryStatus) (int, error) {
+	return s.repo.CountDeliveries(ctx, webhookID, status)
+}
+
+// Redeliver queues the event of a past delivery again as a new delivery,
+// the original one is kept in the log.
+func (s service) Redeliver(ctx context.Context, webhookID, deliveryID string) (*domain.WebhookDelivery, error) {
+	d, err := s.repo.GetDelivery(ctx, webhookID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []domain.WebhookDelivery{{
+		WebhookID: d.WebhookID,
+		EventID:   d.EventID,
+		EventType: d.EventType,
+		Payload:   d.Payload,
+		Status:    domain.DeliveryPending,
+	}}
+
+	// the id and creation time are set on the created element
+	if err := s.repo.CreateDeliveries(ctx, deliveries); err != nil {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+// Publish queues a delivery of the event for each active webhook subscribed
+// to it, it is the outbox publisher of the webhooks. The webhooks that already
+// have a delivery of the event are skipped, so publishing it again after a
+// failure doesn't deliver it twice.
+func (s service) Publish(ctx context.Context, e domain.Event) error {
+	webhooks, err := s.repo.GetActive(ctx)
+	if err != nil {
+		return err
+	}
+
+	queued, err := s.repo.GetEventWebhooks(ctx, e.ID)
+	if err != nil {
+		return err
+	}
+	skip := make(map[string]bool, len(queued))
+	for _, id := range queued {
+		skip[id] = true
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []domain.WebhookDelivery
+	for _, w := range webhooks {
+		if skip[w.ID] || !w.Subscribed(e.Type) {
+			continue
+		}
+		deliveries = append(deliveries, domain.WebhookDelivery{
+			WebhookID: w.ID,
+			EventID:   e.ID,
+			EventType: e.Type,
+			Payload:   payload,
+			Status:    domain.DeliveryPending,
+		})
+	}
+
+	return s.repo.CreateDeliveries(ctx, deliveries)
+}
+
+// ValidateURL checks the webhook can be called, only absolute http(s) urls
+// are allowed and they can't point to a loopback or private address.
+func ValidateURL(raw string) error {
+	if raw == "" {
+		return ErrURLRequired
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+	if !publicHost(u.Hostname()) {
+		return ErrPrivateURL
+	}
+	return nil
+}
+
+// ValidateEvents checks every event is known, "*" stands for all of them.
+func ValidateEvents(list []string) error {
+	if len(list) == 0 {
+		return ErrEventsRequired
+	}
+
+	for _, e := range list {
+		if e != "*" && !events[domain.EventType(e)] {
+			return ErrInvalidEvent{e}
+		}
+	}
+	return nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -28,7 +28,11 @@ func DBConnection() (*gorm.DB, error) {
 	}
 
 	if os.Getenv("DATABASE_MIGRATE") == "true" {
-		if err := db.AutoMigrate(&domain.User{}); err != nil {
+		if err := db.AutoMigrate(&domain.User{}, &domain.Event{}, &domain.Webhook{}, &domain.WebhookDelivery{}, &domain.AuditEntry{}, &domain.UserLogin{}, &domain.TrustedDevice{}, &domain.Passkey{}, &domain.PasskeyChallenge{}, &domain.MagicLink{}, &domain.OTPCode{}, &domain.UserFactor{}, &domain.Attribute{}, &domain.UserAttribute{}, &domain.UserPreferences{}); err != nil {
+			return nil, err
+		}
+
+		if err := migrateFactors(db); err != nil {
 			return nil, err
 		}
 	}
@@ -36,6 +40,54 @@ func DBConnection() (*gorm.DB, error) {
 	return db, nil
 }
 
+// migrateFactors moves the 2FA factor kept in the users table to the
+// factors table and drops the old columns, it does nothing once they're
+// gone. MySQL can't roll back the drops, the copy skips the factors
+// already moved so a failed migration can run again.
+func migrateFactors(db *gorm.DB) error {
+	m := db.Migrator()
+	if !m.HasColumn(&domain.User{}, "two_f_code") {
+		return nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO user_factors (id, user_id, type, label, status, secret, created_at)
+			SELECT UUID(), u.id, 'totp', 'Authenticator app', u.two_f_status, u.two_f_code, NOW()
+			FROM users u WHERE u.two_f_active AND u.two_f_code <> ''
+			AND NOT EXISTS (SELECT 1 FROM user_factors f WHERE f.user_id = u.id AND f.secret = u.two_f_code)`).Error; err != nil {
+			return err
+		}
+
+		if m.HasColumn(&domain.User{}, "two_f_email") {
+			if err := tx.Exec(`INSERT INTO user_factors (id, user_id, type, label, status, created_at)
+				SELECT UUID(), u.id, 'email', LEFT(u.email, 50), ?, NOW()
+				FROM users u WHERE u.two_f_email
+				AND NOT EXISTS (SELECT 1 FROM user_factors f WHERE f.user_id = u.id AND f.type = 'email')`,
+				domain.FactorApproved).Error; err != nil {
+				return err
+			}
+		}
+
+		// the preferred factor was a factor type, it becomes a factor ID
+		return tx.Exec(`UPDATE users SET two_f_preferred = COALESCE(
+			(SELECT f.id FROM user_factors f WHERE f.user_id = users.id AND f.type = users.two_f_preferred LIMIT 1), '')
+			WHERE two_f_preferred IN ('totp', 'email')`).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, column := range []string{"two_f_status", "two_f_active", "two_f_email", "two_f_code"} {
+		if !m.HasColumn(&domain.User{}, column) {
+			continue
+		}
+		if err := m.DropColumn(&domain.User{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func InitLogger() *log.Logger {
 	return log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
 }
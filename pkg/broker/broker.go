@@ -0,0 +1,47 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+)
+
+var ErrUnknownBroker = errors.New("unknown broker, must be nats, kafka or memory")
+
+type (
+	// Publisher sends messages to a broker. Key is the partition key,
+	// messages with the same key keep their order.
+	Publisher interface {
+		Publish(ctx context.Context, msg Message) error
+		Close() error
+	}
+
+	Message struct {
+		Topic   string
+		Key     string
+		Value   []byte
+		Headers map[string]string
+	}
+)
+
+// NewFromEnv builds the publisher set in BROKER, nil when it is empty.
+//
+// BROKER: nats, kafka or memory
+//
+// NATS_URL: the NATS server url, nats.DefaultURL when empty
+//
+// KAFKA_BROKERS: comma separated list of the Kafka brokers
+func NewFromEnv() (Publisher, error) {
+	switch os.Getenv("BROKER") {
+	case "":
+		return nil, nil
+	case "nats":
+		return NewNATS(os.Getenv("NATS_URL"))
+	case "kafka":
+		return NewKafka(strings.Split(os.Getenv("KAFKA_BROKERS"), ",")), nil
+	case "memory":
+		return NewMemory(), nil
+	}
+	return nil, ErrUnknownBroker
+}
@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafka publishes to Kafka, messages are partitioned by key hash.
+func NewKafka(brokers []string) Publisher {
+	return &kafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+		},
+	}
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, msg Message) error {
+	m := kafka.Message{
+		Topic: msg.Topic,
+		Key:   []byte(msg.Key),
+		Value: msg.Value,
+	}
+	for k, v := range msg.Headers {
+		m.Headers = append(m.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	return p.writer.WriteMessages(ctx, m)
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
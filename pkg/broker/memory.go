@@ -0,0 +1,35 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps the published messages, a stand-in for a broker
+// in development and tests.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Publish(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages published so far, oldest first.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
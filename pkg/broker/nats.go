@@ -0,0 +1,43 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+type natsPublisher struct {
+	conn *nats.Conn
+}
+
+// NewNATS publishes to NATS core, the topic is used as the subject.
+// NATS has no partitions, the key travels in the Nats-Msg-Key header.
+func NewNATS(url string) (Publisher, error) {
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	conn, err := nats.Connect(url, nats.Name("go-app-users-lab"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{conn: conn}, nil
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, msg Message) error {
+	m := nats.NewMsg(msg.Topic)
+	m.Data = msg.Value
+	m.Header.Set("Nats-Msg-Key", msg.Key)
+	for k, v := range msg.Headers {
+		m.Header.Set(k, v)
+	}
+
+	if err := p.conn.PublishMsg(m); err != nil {
+		return err
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) Close() error {
+	return p.conn.Drain()
+}
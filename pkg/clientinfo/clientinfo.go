@@ -0,0 +1,114 @@
+package clientinfo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Info describes who is calling, taken from the HTTP request
+// and carried in the context down to the services.
+type Info struct {
+	IP        string
+	UserAgent string
+	Token     string
+}
+
+// Proxies are the networks of the proxies in front of the service,
+// only they are trusted to set X-Forwarded-For.
+type Proxies []*net.IPNet
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, ctxKey{}, info)
+}
+
+// FromContext returns the client info, empty when the call didn't come from a request.
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(ctxKey{}).(Info)
+	return info
+}
+
+// ParseProxies reads a comma separated list of CIDRs or addresses,
+// e.g. "10.0.0.0/8,192.168.1.10".
+func ParseProxies(s string) (Proxies, error) {
+	var proxies Proxies
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address '%s'", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network '%s'", p)
+		}
+		proxies = append(proxies, n)
+	}
+	return proxies, nil
+}
+
+func (p Proxies) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest reads the client info. X-Forwarded-For is only read when
+// the request comes from a trusted proxy, the IP is then the last address
+// of it not added by one of them, as the ones before can be made up by
+// the client.
+func FromRequest(r *http.Request, proxies Proxies) Info {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	if proxies.trusted(ip) {
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !proxies.trusted(hop) {
+				break
+			}
+		}
+	}
+
+	return Info{
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Token:     r.Header.Get("Authorization"),
+	}
+}
+
+// Populate returns a go-kit ServerBefore function storing the client info in the context.
+func Populate(proxies Proxies) func(context.Context, *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		return NewContext(ctx, FromRequest(r, proxies))
+	}
+}
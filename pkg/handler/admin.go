@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-http-utils/response"
+)
+
+// RequireAdmin only lets through the requests that carry the admin token
+// in Authorization, optionally as a bearer token. Without a token set up
+// every request is rejected.
+func RequireAdmin(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(token, r) {
+			ctx := i18n.Populate(r.Context(), r)
+			encodeError(ctx, response.Unauthorized(i18n.T(ctx, "admin_required")), w)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin tells whether the request carries the admin token.
+func isAdmin(token string, r *http.Request) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/ncostamagna/go-app-users-lab/internal/audit"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-http-utils/response"
+)
+
+func NewAuditHTTPServer(ctx context.Context, endpoints audit.Endpoints) http.Handler {
+
+	r := mux.NewRouter()
+
+	opts := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerBefore(i18n.Populate),
+	}
+
+	r.Handle("/audit", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetAll),
+		decodeGetAllAudit,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/audit/verify", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Verify),
+		httptransport.NopRequestDecoder,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	return r
+}
+
+func decodeGetAllAudit(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	v := r.URL.Query()
+
+	limit, _ := strconv.Atoi(v.Get("limit"))
+	page, _ := strconv.Atoi(v.Get("page"))
+
+	req := audit.GetAllReq{
+		ActorID:  v.Get("actor_id"),
+		TargetID: v.Get("target_id"),
+		Action:   v.Get("action"),
+		Outcome:  v.Get("outcome"),
+		IP:       v.Get("ip"),
+		Limit:    limit,
+		Page:     page,
+	}
+
+	var err error
+	if req.From, err = parseDateParam(v.Get("from"), false); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_param", "from", err))
+	}
+	if req.To, err = parseDateParam(v.Get("to"), true); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_param", "to", err))
+	}
+
+	return req, nil
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-app-users-lab/pkg/storage"
+	"github.com/ncostamagna/go-http-utils/response"
+)
+
+type blobReq struct {
+	Key       string
+	Expires   string
+	Signature string
+}
+
+// NewBlobHTTPServer serves the objects of the storages with signed
+// links, a link only works for its key and until it expires.
+func NewBlobHTTPServer(ctx context.Context, store storage.Storage, signer *storage.Signer) http.Handler {
+
+	r := mux.NewRouter()
+
+	opts := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerBefore(i18n.Populate),
+	}
+
+	r.Handle("/blobs/{key:.+}", httptransport.NewServer(
+		makeGetBlobEndpoint(store, signer),
+		decodeGetBlob,
+		encodeBlob,
+		opts...,
+	)).Methods("GET")
+
+	return r
+}
+
+func makeGetBlobEndpoint(store storage.Storage, signer *storage.Signer) func(ctx context.Context, request interface{}) (interface{}, error) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+
+		req := request.(blobReq)
+
+		if err := signer.Verify(req.Key, req.Expires, req.Signature); err != nil {
+			return nil, response.Unauthorized(i18n.Localize(ctx, err))
+		}
+
+		o, err := store.Get(ctx, req.Key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil, response.NotFound(i18n.Localize(ctx, err))
+			}
+			return nil, response.InternalServerError(err.Error())
+		}
+
+		return o, nil
+	}
+}
+
+func decodeGetBlob(_ context.Context, r *http.Request) (interface{}, error) {
+
+	v := r.URL.Query()
+	return blobReq{
+		Key:       mux.Vars(r)["key"],
+		Expires:   v.Get("expires"),
+		Signature: v.Get("signature"),
+	}, nil
+}
+
+func encodeBlob(_ context.Context, w http.ResponseWriter, resp interface{}) error {
+	o := resp.(*storage.Object)
+	defer o.Body.Close()
+
+	contentType := o.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(o.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// the link is a secret, the response must not outlive it in a cache
+	w.Header().Set("Cache-Control", "private, no-store")
+	if strings.HasPrefix(contentType, "image/") {
+		w.Header().Set("Content-Disposition", "inline")
+	} else {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+
+	_, err := io.Copy(w, o.Body)
+	return err
+}
+
+// encodeAvatar writes the avatar, its URL changes with every upload
+// so it can be cached. The generated SVG is locked down so it can't
+// run anything when opened on its own.
+func encodeAvatar(_ context.Context, w http.ResponseWriter, resp interface{}) error {
+	o := resp.(*storage.Object)
+	defer o.Body.Close()
+
+	w.Header().Set("Content-Type", o.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(o.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	_, err := io.Copy(w, o.Body)
+	return err
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type ctxKey int
+
+const ctxIfNoneMatch ctxKey = iota
+
+func formatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// etagMatch reports whether etag is in the If-None-Match list,
+// weak comparison is used as it is a GET precondition.
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion returns the version the client expects to update, nil
+// when there is no precondition. An etag we didn't issue can never match.
+func ifMatchVersion(r *http.Request) *int64 {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return nil
+	}
+
+	unquoted, err := strconv.Unquote(h)
+	if err != nil {
+		v := int64(-1)
+		return &v
+	}
+
+	v, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil {
+		v = -1
+	}
+	return &v
+}
+
+func populateIfNoneMatch(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, ctxIfNoneMatch, r.Header.Get("If-None-Match"))
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/ncostamagna/go-app-users-lab/internal/user"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+)
+
+var importColumns = []string{"first_name", "last_name", "email", "phone", "username", "password"}
+
+// parseImportCSV reads a CSV with a header row naming the columns,
+// rows that can't be read are kept with their error for the report.
+func parseImportCSV(ctx context.Context, body io.Reader) ([]user.ImportRow, error) {
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, i18n.NewError("import_invalid_header", err)
+	}
+
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		index[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	for _, c := range []string{"first_name", "last_name", "username", "password"} {
+		if _, ok := index[c]; !ok {
+			return nil, i18n.NewError("import_column_required", c)
+		}
+	}
+
+	var rows []user.ImportRow
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			rows = append(rows, user.ImportRow{Line: parseErr.Line, Err: parseErr.Err.Error()})
+			continue
+		}
+
+		line, _ := r.FieldPos(0)
+		row := user.ImportRow{Line: line}
+
+		if len(record) != len(header) {
+			row.Err = i18n.T(ctx, "import_column_count", len(header), len(record))
+			rows = append(rows, row)
+			continue
+		}
+
+		values := make(map[string]string, len(importColumns))
+		for _, c := range importColumns {
+			if i, ok := index[c]; ok {
+				values[c] = strings.TrimSpace(record[i])
+			}
+		}
+
+		row.User = user.CreateReq{
+			FirstName: values["first_name"],
+			LastName:  values["last_name"],
+			Email:     values["email"],
+			Phone:     values["phone"],
+			Username:  values["username"],
+			Password:  values["password"],
+		}
+
+		// custom attributes come in attr.{name} columns, empty cells are left unset
+		for h, i := range index {
+			name, ok := strings.CutPrefix(h, "attr.")
+			if !ok || name == "" {
+				continue
+			}
+			if value := strings.TrimSpace(record[i]); value != "" {
+				if row.User.Attributes == nil {
+					row.User.Attributes = make(map[string]interface{})
+				}
+				row.User.Attributes[name] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// parseImportNDJSON reads one JSON user per line, blank lines are skipped.
+func parseImportNDJSON(body io.Reader) ([]user.ImportRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []user.ImportRow
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		row := user.ImportRow{Line: line}
+		if err := json.Unmarshal(b, &row.User); err != nil {
+			row.Err = err.Error()
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -3,23 +3,45 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
+	"github.com/ncostamagna/go-app-users-lab/internal/domain"
 	"github.com/ncostamagna/go-app-users-lab/internal/user"
+	"github.com/ncostamagna/go-app-users-lab/pkg/clientinfo"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
 	"github.com/ncostamagna/go-http-utils/response"
 )
 
-func NewUserHTTPServer(ctx context.Context, endpoints user.Endpoints) http.Handler {
+const maxImportSize = 32 << 20
+
+const (
+	trustedDeviceCookie = "trusted_device"
+	magicLinkCookie     = "magic_link"
+)
+
+const maxPasskeyResponseSize = 64 << 10
+
+// NewUserHTTPServer serves the users API, the routes meant for admins
+// need adminToken as RequireAdmin does.
+func NewUserHTTPServer(ctx context.Context, endpoints user.Endpoints, proxies clientinfo.Proxies, adminToken string) http.Handler {
 
 	r := mux.NewRouter()
 
 	opts := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerBefore(i18n.Populate),
+		httptransport.ServerBefore(clientinfo.Populate(proxies)),
+		httptransport.ServerBefore(populateAdmin(adminToken)),
 	}
 
 	r.Handle("/users", httptransport.NewServer(
@@ -35,6 +57,48 @@ func NewUserHTTPServer(ctx context.Context, endpoints user.Endpoints) http.Handl
 		opts...,
 	)).Methods("GET")
 
+	r.Handle("/users/search", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Search),
+		decodeSearchUser,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/users/deleted", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetDeleted),
+		decodeGetAllUser,
+		encodeResponse,
+		opts...,
+	))).Methods("GET")
+
+	r.Handle("/users/import", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Import),
+		decodeImportUsers,
+		encodeResponse,
+		opts...,
+	))).Methods("POST")
+
+	r.Handle("/users/import/{id}", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetImport),
+		decodeGetImport,
+		encodeResponse,
+		opts...,
+	))).Methods("GET")
+
+	r.Handle("/users/export", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Export),
+		decodeExportUsers,
+		encodeExportResponse,
+		opts...,
+	))).Methods("GET")
+
+	r.Handle("/users/batch", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Batch),
+		decodeBatchUsers,
+		encodeResponse,
+		opts...,
+	))).Methods("POST")
+
 	r.Handle("/users/login", httptransport.NewServer(
 		endpoint.Endpoint(endpoints.Login),
 		decodeLoginUser, encodeResponse,
@@ -43,7 +107,7 @@ func NewUserHTTPServer(ctx context.Context, endpoints user.Endpoints) http.Handl
 
 	r.Handle("/users/login/2fa", httptransport.NewServer(
 		endpoint.Endpoint(endpoints.Login2FA),
-		decodeLogin2FAUser, encodeResponse,
+		decodeLogin2FAUser, encodeLoginResponse,
 		opts...,
 	)).Methods("POST")
 
@@ -53,11 +117,228 @@ func NewUserHTTPServer(ctx context.Context, endpoints user.Endpoints) http.Handl
 		opts...,
 	)).Methods("POST")
 
+	r.Handle("/users/login/2fa/send", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.SendLoginCode),
+		decodeFactor,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/me/factors", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetFactors),
+		decodeCreate2FAUser,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/users/me/factors", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.AddFactor),
+		decodeAddFactor,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/me/factors/{id}/verify", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.ConfirmFactor),
+		decodeConfirmFactor,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/me/factors/{id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.RenameFactor),
+		decodeRenameFactor,
+		encodeResponse,
+		opts...,
+	)).Methods("PATCH")
+
+	r.Handle("/users/me/factors/{id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.RemoveFactor),
+		decodeRemoveFactor,
+		encodeResponse,
+		opts...,
+	)).Methods("DELETE")
+
+	r.Handle("/users/me/2fa/preferred", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.SetPreferredFactor),
+		decodeFactor,
+		encodeResponse,
+		opts...,
+	)).Methods("PUT")
+
+	r.Handle("/users/me/avatar", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.SetAvatar),
+		decodeSetAvatar,
+		encodeResponse,
+		opts...,
+	)).Methods("PUT")
+
+	r.Handle("/users/me/avatar", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.DeleteAvatar),
+		decodeCreate2FAUser,
+		encodeResponse,
+		opts...,
+	)).Methods("DELETE")
+
+	r.Handle("/users/me/logins", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetLogins),
+		decodeGetLogins,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/users/me/logins/{id}/not-me", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.NotMe),
+		decodeNotMe,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/login/2fa/passkey", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.BeginPasskey2FA),
+		decodePasskey,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/login/2fa/passkey/{challenge_id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Login2FAPasskey),
+		decodeLogin2FAPasskey,
+		encodeLoginResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/login/magic", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.RequestMagicLink),
+		decodeRequestMagicLink,
+		encodeMagicLinkResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/login/magic/consume", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.LoginMagicLink),
+		decodeLoginMagicLink,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/login/passkey", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.BeginPasskeyLogin),
+		httptransport.NopRequestDecoder,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/login/passkey/{challenge_id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.LoginPasskey),
+		decodeLoginPasskey,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/me/passkeys", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetPasskeys),
+		decodePasskey,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/users/me/passkeys/register", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.BeginPasskeyRegistration),
+		decodePasskey,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/me/passkeys/register/{challenge_id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.FinishPasskeyRegistration),
+		decodeFinishPasskeyRegistration,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/users/me/passkeys/{id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.DeletePasskey),
+		decodeDeletePasskey,
+		encodeResponse,
+		opts...,
+	)).Methods("DELETE")
+
+	r.Handle("/users/me/devices", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetDevices),
+		decodeGetDevices,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/users/me/devices/{id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.RevokeDevice),
+		decodeRevokeDevice,
+		encodeResponse,
+		opts...,
+	)).Methods("DELETE")
+
+	r.Handle("/users/me", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetMe),
+		decodeGetMe,
+		encodeGetUserResponse,
+		append(opts, httptransport.ServerBefore(populateIfNoneMatch))...,
+	)).Methods("GET")
+
+	r.Handle("/users/me", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.UpdateMe),
+		decodeUpdateMe,
+		encodeResponse,
+		opts...,
+	)).Methods("PATCH")
+
+	r.Handle("/users/me/preferences", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetPreferences),
+		decodeGetPreferences,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/users/me/preferences", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.UpdatePreferences),
+		decodeUpdatePreferences,
+		encodeResponse,
+		opts...,
+	)).Methods("PATCH")
+
+	r.Handle("/users/attributes", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetAttributes),
+		decodeGetAttributes,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/users/attributes", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.CreateAttribute),
+		decodeCreateAttribute,
+		encodeResponse,
+		opts...,
+	))).Methods("POST")
+
+	r.Handle("/users/attributes/{name}", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.UpdateAttribute),
+		decodeUpdateAttribute,
+		encodeResponse,
+		opts...,
+	))).Methods("PUT")
+
+	r.Handle("/users/attributes/{name}", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.DeleteAttribute),
+		decodeDeleteAttribute,
+		encodeResponse,
+		opts...,
+	))).Methods("DELETE")
+
 	r.Handle("/users/{id}", httptransport.NewServer(
 		endpoint.Endpoint(endpoints.Get),
 		decodeGetUser,
-		encodeResponse,
-		opts...,
+		encodeGetUserResponse,
+		append(opts, httptransport.ServerBefore(populateIfNoneMatch))...,
 	)).Methods("GET")
 
 	r.Handle("/users/{id}", httptransport.NewServer(
@@ -67,6 +348,13 @@ func NewUserHTTPServer(ctx context.Context, endpoints user.Endpoints) http.Handl
 		opts...,
 	)).Methods("PATCH")
 
+	r.Handle("/users/{id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Replace),
+		decodeReplaceUser,
+		encodeResponse,
+		opts...,
+	)).Methods("PUT")
+
 	r.Handle("/users/{id}", httptransport.NewServer(
 		endpoint.Endpoint(endpoints.Delete),
 		decodeDeleteUser,
@@ -74,24 +362,76 @@ func NewUserHTTPServer(ctx context.Context, endpoints user.Endpoints) http.Handl
 		opts...,
 	)).Methods("DELETE")
 
+	r.Handle("/users/{id}/avatar", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetAvatar),
+		decodeGetAvatar,
+		encodeAvatar,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/users/{id}/restore", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Restore),
+		decodeRestoreUser,
+		encodeResponse,
+		opts...,
+	))).Methods("POST")
+
+	r.Handle("/users/{id}/purge", RequireAdmin(adminToken, httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Purge),
+		decodePurgeUser,
+		encodeResponse,
+		opts...,
+	))).Methods("DELETE")
+
 	return r
 }
 
-func decodeCreateUser(_ context.Context, r *http.Request) (interface{}, error) {
+// populateAdmin marks the requests that carry the admin token, only
+// they are shown the private attributes.
+func populateAdmin(token string) httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if isAdmin(token, r) {
+			return user.WithAdmin(ctx)
+		}
+		return ctx
+	}
+}
+
+func decodeCreateUser(ctx context.Context, r *http.Request) (interface{}, error) {
 
 	var req user.CreateReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, response.BadRequest(fmt.Sprintf("invalid request format: '%v'", err.Error()))
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
 	}
 
 	return req, nil
 }
 
-func decodeLoginUser(_ context.Context, r *http.Request) (interface{}, error) {
+func decodeBatchUsers(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.BatchReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	return req, nil
+}
+
+func decodeLoginUser(ctx context.Context, r *http.Request) (interface{}, error) {
 
 	var req user.LoginReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, response.BadRequest(fmt.Sprintf("invalid request format: '%v'", err.Error()))
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	// the trusted device can also come from a header or the cookie set on the 2FA login
+	if req.TrustedDevice == "" {
+		req.TrustedDevice = r.Header.Get("X-Trusted-Device")
+	}
+	if req.TrustedDevice == "" {
+		if c, err := r.Cookie(trustedDeviceCookie); err == nil {
+			req.TrustedDevice = c.Value
+		}
 	}
 
 	return req, nil
@@ -104,17 +444,332 @@ func decodeCreate2FAUser(_ context.Context, r *http.Request) (interface{}, error
 	}, nil
 }
 
-func decodeLogin2FAUser(_ context.Context, r *http.Request) (interface{}, error) {
+func decodeLogin2FAUser(ctx context.Context, r *http.Request) (interface{}, error) {
 
 	var req user.Login2FAReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, response.BadRequest(fmt.Sprintf("invalid request format: '%v'", err.Error()))
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+	req.Token = r.Header.Get("Authorization")
+
+	return req, nil
+}
+
+func decodeFactor(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.FactorReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+	req.Token = r.Header.Get("Authorization")
+
+	return req, nil
+}
+
+func decodeAddFactor(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.AddFactorReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+	req.Token = r.Header.Get("Authorization")
+
+	return req, nil
+}
+
+func decodeConfirmFactor(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.ConfirmFactorReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+	req.Token = r.Header.Get("Authorization")
+	req.FactorID = mux.Vars(r)["id"]
+
+	return req, nil
+}
+
+func decodeRenameFactor(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.RenameFactorReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+	req.Token = r.Header.Get("Authorization")
+	req.FactorID = mux.Vars(r)["id"]
+
+	return req, nil
+}
+
+func decodeRemoveFactor(_ context.Context, r *http.Request) (interface{}, error) {
+
+	p := mux.Vars(r)
+	return user.RemoveFactorReq{
+		Token:    r.Header.Get("Authorization"),
+		FactorID: p["id"],
+	}, nil
+}
+
+// decodeSetAvatar hands the "avatar" part of the multipart form over
+// without buffering it, the service limits how much of it is read.
+func decodeSetAvatar(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	req := user.SetAvatarReq{Token: r.Header.Get("Authorization")}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return req, nil
+		}
+		if err != nil {
+			return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+		}
+		if part.FormName() == "avatar" {
+			req.Image = part
+			return req, nil
+		}
+	}
+}
+
+func decodeGetAvatar(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	req := user.GetAvatarReq{ID: mux.Vars(r)["id"]}
+
+	if v := r.URL.Query().Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, response.BadRequest(i18n.T(ctx, "invalid_param", "size", err))
+		}
+		req.Size = size
+	}
+
+	return req, nil
+}
+
+func decodeGetLogins(_ context.Context, r *http.Request) (interface{}, error) {
+
+	v := r.URL.Query()
+
+	limit, _ := strconv.Atoi(v.Get("limit"))
+	page, _ := strconv.Atoi(v.Get("page"))
+
+	return user.GetLoginsReq{
+		Token: r.Header.Get("Authorization"),
+		Limit: limit,
+		Page:  page,
+	}, nil
+}
+
+func decodeNotMe(_ context.Context, r *http.Request) (interface{}, error) {
+
+	p := mux.Vars(r)
+	return user.NotMeReq{
+		Token:   r.Header.Get("Authorization"),
+		LoginID: p["id"],
+	}, nil
+}
+
+func decodeRequestMagicLink(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.MagicLinkReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	return req, nil
+}
+
+func decodeLoginMagicLink(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.LoginMagicLinkReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	if c, err := r.Cookie(magicLinkCookie); err == nil {
+		req.Binding = c.Value
+	}
+
+	if req.TrustedDevice == "" {
+		req.TrustedDevice = r.Header.Get("X-Trusted-Device")
+	}
+	if req.TrustedDevice == "" {
+		if c, err := r.Cookie(trustedDeviceCookie); err == nil {
+			req.TrustedDevice = c.Value
+		}
+	}
+
+	return req, nil
+}
+
+func decodePasskey(_ context.Context, r *http.Request) (interface{}, error) {
+
+	return user.PasskeyReq{
+		Token: r.Header.Get("Authorization"),
+	}, nil
+}
+
+func decodeFinishPasskeyRegistration(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	body, err := readPasskeyResponse(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := mux.Vars(r)
+	return user.FinishPasskeyRegistrationReq{
+		Token:       r.Header.Get("Authorization"),
+		ChallengeID: p["challenge_id"],
+		Name:        r.URL.Query().Get("name"),
+		Response:    body,
+	}, nil
+}
+
+func decodeDeletePasskey(_ context.Context, r *http.Request) (interface{}, error) {
+
+	p := mux.Vars(r)
+	return user.DeletePasskeyReq{
+		Token:     r.Header.Get("Authorization"),
+		PasskeyID: p["id"],
+	}, nil
+}
+
+func decodeLogin2FAPasskey(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	body, err := readPasskeyResponse(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	remember, err := parseBoolParam(r.URL.Query().Get("remember_device"))
+	if err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_param", "remember_device", err))
+	}
+
+	p := mux.Vars(r)
+	return user.Login2FAPasskeyReq{
+		Token:          r.Header.Get("Authorization"),
+		ChallengeID:    p["challenge_id"],
+		RememberDevice: remember != nil && *remember,
+		Response:       body,
+	}, nil
+}
+
+func decodeLoginPasskey(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	body, err := readPasskeyResponse(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := mux.Vars(r)
+	return user.LoginPasskeyReq{
+		ChallengeID: p["challenge_id"],
+		Response:    body,
+	}, nil
+}
+
+// readPasskeyResponse reads the credential the browser returned,
+// which is passed on as is to be verified.
+func readPasskeyResponse(ctx context.Context, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPasskeyResponseSize))
+	if err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+	return body, nil
+}
+
+func decodeGetMe(_ context.Context, r *http.Request) (interface{}, error) {
+
+	return user.GetMeReq{
+		Token: r.Header.Get("Authorization"),
+	}, nil
+}
+
+func decodeUpdateMe(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.UpdateMeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+	req.Token = r.Header.Get("Authorization")
+	req.Version = ifMatchVersion(r)
+
+	return req, nil
+}
+
+func decodeGetPreferences(_ context.Context, r *http.Request) (interface{}, error) {
+
+	return user.GetPreferencesReq{
+		Token: r.Header.Get("Authorization"),
+	}, nil
+}
+
+func decodeUpdatePreferences(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.UpdatePreferencesReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
 	}
 	req.Token = r.Header.Get("Authorization")
 
 	return req, nil
 }
 
+func decodeGetAttributes(_ context.Context, r *http.Request) (interface{}, error) {
+
+	return user.GetAttributesReq{}, nil
+}
+
+func decodeCreateAttribute(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.AttributeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	return req, nil
+}
+
+func decodeUpdateAttribute(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req user.AttributeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+	req.Name = mux.Vars(r)["name"]
+
+	return req, nil
+}
+
+func decodeDeleteAttribute(_ context.Context, r *http.Request) (interface{}, error) {
+
+	return user.DeleteAttributeReq{
+		Name: mux.Vars(r)["name"],
+	}, nil
+}
+
+func decodeGetDevices(_ context.Context, r *http.Request) (interface{}, error) {
+
+	return user.GetDevicesReq{
+		Token: r.Header.Get("Authorization"),
+	}, nil
+}
+
+func decodeRevokeDevice(_ context.Context, r *http.Request) (interface{}, error) {
+
+	p := mux.Vars(r)
+	return user.RevokeDeviceReq{
+		Token:    r.Header.Get("Authorization"),
+		DeviceID: p["id"],
+	}, nil
+}
+
 func decodeGetUser(_ context.Context, r *http.Request) (interface{}, error) {
 
 	p := mux.Vars(r)
@@ -125,7 +780,7 @@ func decodeGetUser(_ context.Context, r *http.Request) (interface{}, error) {
 	return req, nil
 }
 
-func decodeGetAllUser(_ context.Context, r *http.Request) (interface{}, error) {
+func decodeGetAllUser(ctx context.Context, r *http.Request) (interface{}, error) {
 
 	v := r.URL.Query()
 
@@ -133,24 +788,185 @@ func decodeGetAllUser(_ context.Context, r *http.Request) (interface{}, error) {
 	page, _ := strconv.Atoi(v.Get("page"))
 
 	req := user.GetAllReq{
-		FirstName: v.Get("first_name"),
-		LastName:  v.Get("last_name"),
-		Limit:     limit,
-		Page:      page,
+		FirstName:  v.Get("first_name"),
+		LastName:   v.Get("last_name"),
+		Username:   v.Get("username"),
+		Email:      v.Get("email"),
+		Phone:      v.Get("phone"),
+		TwoFStatus: v.Get("twofa_status"),
+		Limit:      limit,
+		Page:       page,
+	}
+
+	for _, ids := range v["ids"] {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				req.IDs = append(req.IDs, id)
+			}
+		}
+	}
+
+	// custom attributes are filtered as attr.{name}=value
+	for key := range v {
+		if name, ok := strings.CutPrefix(key, "attr."); ok && name != "" {
+			if req.Attributes == nil {
+				req.Attributes = make(map[string]string)
+			}
+			req.Attributes[name] = v.Get(key)
+		}
+	}
+
+	var err error
+	if req.TwoFActive, err = parseBoolParam(v.Get("twofa_active")); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_param", "twofa_active", err))
+	}
+
+	if req.Deleted, err = parseBoolParam(v.Get("deleted")); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_param", "deleted", err))
+	}
+
+	dates := []struct {
+		name     string
+		dst      **time.Time
+		endOfDay bool
+	}{
+		{"created_from", &req.CreatedFrom, false},
+		{"created_to", &req.CreatedTo, true},
+		{"updated_from", &req.UpdatedFrom, false},
+		{"updated_to", &req.UpdatedTo, true},
+	}
+	for _, d := range dates {
+		if *d.dst, err = parseDateParam(v.Get(d.name), d.endOfDay); err != nil {
+			return nil, response.BadRequest(i18n.T(ctx, "invalid_param", d.name, err))
+		}
+	}
+
+	if req.Sort, err = user.ParseSort(v.Get("sort")); err != nil {
+		return nil, response.BadRequest(i18n.Localize(ctx, err))
+	}
+
+	return req, nil
+}
+
+func parseBoolParam(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// parseDateParam accepts RFC3339 timestamps or plain dates (2006-01-02).
+// A plain date used as an upper bound covers the whole day.
+func parseDateParam(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return &t, nil
+}
+
+func decodeSearchUser(_ context.Context, r *http.Request) (interface{}, error) {
+
+	v := r.URL.Query()
+
+	limit, _ := strconv.Atoi(v.Get("limit"))
+	page, _ := strconv.Atoi(v.Get("page"))
+
+	req := user.SearchReq{
+		Query: v.Get("q"),
+		Limit: limit,
+		Page:  page,
 	}
 
 	return req, nil
 }
 
-func decodeUpdateUser(_ context.Context, r *http.Request) (interface{}, error) {
+func decodeExportUsers(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	getAll, err := decodeGetAllUser(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	v := r.URL.Query()
+
+	req := user.ExportReq{
+		GetAllReq: getAll.(user.GetAllReq),
+		Format:    v.Get("format"),
+	}
+
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+
+	for _, c := range strings.Split(v.Get("columns"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			req.Columns = append(req.Columns, c)
+		}
+	}
+
+	return req, nil
+}
+
+func decodeUpdateUser(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	path := mux.Vars(r)
+	id, version := path["id"], ifMatchVersion(r)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/merge-patch+json":
+		req := user.MergePatchReq{ID: id, Version: version}
+		if err := json.NewDecoder(r.Body).Decode(&req.Patch); err != nil {
+			return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+		}
+		return req, nil
+
+	case "application/json-patch+json":
+		req := user.JSONPatchReq{ID: id, Version: version}
+		if err := json.NewDecoder(r.Body).Decode(&req.Ops); err != nil {
+			return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+		}
+		return req, nil
+	}
+
 	var req user.UpdateReq
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, response.BadRequest(fmt.Sprintf("invalid request format: '%v'", err.Error()))
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	req.ID = id
+	req.Version = version
+
+	return req, nil
+}
+
+func decodeReplaceUser(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req user.ReplaceReq
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
 	}
 
 	path := mux.Vars(r)
 	req.ID = path["id"]
+	req.Version = ifMatchVersion(r)
 
 	return req, nil
 }
@@ -165,6 +981,79 @@ func decodeDeleteUser(_ context.Context, r *http.Request) (interface{}, error) {
 	return req, nil
 }
 
+// decodeImportUsers reads the whole file, the import runs after
+// the request is answered. The format comes from ?format= or the
+// Content-Type, csv or ndjson.
+func decodeImportUsers(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	v := r.URL.Query()
+
+	format := v.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/ndjson":
+			format = "ndjson"
+		}
+	}
+
+	var req user.ImportReq
+	var err error
+	req.DryRun, _ = strconv.ParseBool(v.Get("dry_run"))
+
+	body := http.MaxBytesReader(nil, r.Body, maxImportSize)
+	switch format {
+	case "csv":
+		req.Rows, err = parseImportCSV(ctx, body)
+	case "ndjson":
+		req.Rows, err = parseImportNDJSON(body)
+	default:
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_import_format"))
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, requestTooLarge(i18n.T(ctx, "request_too_large", tooLarge.Limit))
+	}
+	if err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	return req, nil
+}
+
+func decodeGetImport(_ context.Context, r *http.Request) (interface{}, error) {
+
+	path := mux.Vars(r)
+	req := user.GetImportReq{
+		ID: path["id"],
+	}
+
+	return req, nil
+}
+
+func decodeRestoreUser(_ context.Context, r *http.Request) (interface{}, error) {
+
+	path := mux.Vars(r)
+	req := user.RestoreReq{
+		ID: path["id"],
+	}
+
+	return req, nil
+}
+
+func decodePurgeUser(_ context.Context, r *http.Request) (interface{}, error) {
+
+	path := mux.Vars(r)
+	req := user.PurgeReq{
+		ID: path["id"],
+	}
+
+	return req, nil
+}
+
 func encodeResponse(ctx context.Context, w http.ResponseWriter, resp interface{}) error {
 	r := resp.(response.Response)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -172,9 +1061,90 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, resp interface{}
 	return json.NewEncoder(w).Encode(r)
 }
 
+// encodeLoginResponse sets the trusted device cookie
+// when the 2FA login remembered the device.
+func encodeLoginResponse(ctx context.Context, w http.ResponseWriter, resp interface{}) error {
+	r := resp.(response.Response)
+
+	if l, ok := r.GetData().(*domain.Login); ok && l.TrustedDevice != "" && l.TrustedDeviceExpiresAt != nil {
+		http.SetCookie(w, &http.Cookie{
+			Name:     trustedDeviceCookie,
+			Value:    l.TrustedDevice,
+			Path:     "/users/login",
+			Expires:  *l.TrustedDeviceExpiresAt,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	return encodeResponse(ctx, w, resp)
+}
+
+// encodeMagicLinkResponse hands the browser binding of the link to the
+// browser as a cookie, the link is only accepted along with it.
+func encodeMagicLinkResponse(ctx context.Context, w http.ResponseWriter, resp interface{}) error {
+	r := resp.(response.Response)
+
+	if res, ok := r.GetData().(user.MagicLinkRes); ok && res.Binding != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     magicLinkCookie,
+			Value:    res.Binding,
+			Path:     "/users/login/magic",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	return encodeResponse(ctx, w, resp)
+}
+
+// encodeGetUserResponse sets the user version as ETag and answers
+// 304 Not Modified when it matches the request's If-None-Match.
+func encodeGetUserResponse(ctx context.Context, w http.ResponseWriter, resp interface{}) error {
+	r := resp.(response.Response)
+
+	if u, ok := r.GetData().(*domain.User); ok {
+		etag := formatETag(u.Version)
+		w.Header().Set("ETag", etag)
+
+		if inm, _ := ctx.Value(ctxIfNoneMatch).(string); etagMatch(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	return encodeResponse(ctx, w, resp)
+}
+
+// encodeExportResponse streams the export, lifting the server write
+// timeout as a large export can take longer than a regular response.
+func encodeExportResponse(_ context.Context, w http.ResponseWriter, resp interface{}) error {
+	r := resp.(user.ExportRes)
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", r.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", r.Filename))
+	w.WriteHeader(http.StatusOK)
+
+	return r.Write(w)
+}
+
+func requestTooLarge(msg string) response.Response {
+	return &response.ErrorResponse{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: msg,
+	}
+}
+
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	resp := err.(response.Response)
+	resp, ok := err.(response.Response)
+	if !ok {
+		resp = response.InternalServerError(err.Error())
+	}
 	w.WriteHeader(resp.StatusCode())
 	_ = json.NewEncoder(w).Encode(resp)
 }
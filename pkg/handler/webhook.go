@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/ncostamagna/go-app-users-lab/internal/webhook"
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+	"github.com/ncostamagna/go-http-utils/response"
+)
+
+func NewWebhookHTTPServer(ctx context.Context, endpoints webhook.Endpoints) http.Handler {
+
+	r := mux.NewRouter()
+
+	opts := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerBefore(i18n.Populate),
+	}
+
+	r.Handle("/webhooks", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Create),
+		decodeCreateWebhook,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	r.Handle("/webhooks", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetAll),
+		decodeGetAllWebhook,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/webhooks/{id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Get),
+		decodeGetWebhook,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/webhooks/{id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Update),
+		decodeUpdateWebhook,
+		encodeResponse,
+		opts...,
+	)).Methods("PATCH")
+
+	r.Handle("/webhooks/{id}", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Delete),
+		decodeDeleteWebhook,
+		encodeResponse,
+		opts...,
+	)).Methods("DELETE")
+
+	r.Handle("/webhooks/{id}/deliveries", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.GetDeliveries),
+		decodeGetDeliveries,
+		encodeResponse,
+		opts...,
+	)).Methods("GET")
+
+	r.Handle("/webhooks/{id}/deliveries/{delivery_id}/redeliver", httptransport.NewServer(
+		endpoint.Endpoint(endpoints.Redeliver),
+		decodeRedeliver,
+		encodeResponse,
+		opts...,
+	)).Methods("POST")
+
+	return r
+}
+
+func decodeCreateWebhook(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req webhook.CreateReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	return req, nil
+}
+
+func decodeGetAllWebhook(_ context.Context, r *http.Request) (interface{}, error) {
+
+	v := r.URL.Query()
+
+	limit, _ := strconv.Atoi(v.Get("limit"))
+	page, _ := strconv.Atoi(v.Get("page"))
+
+	return webhook.GetAllReq{
+		Limit: limit,
+		Page:  page,
+	}, nil
+}
+
+func decodeGetWebhook(_ context.Context, r *http.Request) (interface{}, error) {
+
+	p := mux.Vars(r)
+	return webhook.GetReq{
+		ID: p["id"],
+	}, nil
+}
+
+func decodeUpdateWebhook(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	var req webhook.UpdateReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, response.BadRequest(i18n.T(ctx, "invalid_request_format", err))
+	}
+
+	p := mux.Vars(r)
+	req.ID = p["id"]
+
+	return req, nil
+}
+
+func decodeDeleteWebhook(_ context.Context, r *http.Request) (interface{}, error) {
+
+	p := mux.Vars(r)
+	return webhook.DeleteReq{
+		ID: p["id"],
+	}, nil
+}
+
+func decodeGetDeliveries(_ context.Context, r *http.Request) (interface{}, error) {
+
+	v := r.URL.Query()
+	p := mux.Vars(r)
+
+	limit, _ := strconv.Atoi(v.Get("limit"))
+	page, _ := strconv.Atoi(v.Get("page"))
+
+	return webhook.GetDeliveriesReq{
+		WebhookID: p["id"],
+		Status:    v.Get("status"),
+		Limit:     limit,
+		Page:      page,
+	}, nil
+}
+
+func decodeRedeliver(_ context.Context, r *http.Request) (interface{}, error) {
+
+	p := mux.Vars(r)
+	return webhook.RedeliverReq{
+		WebhookID:  p["id"],
+		DeliveryID: p["delivery_id"],
+	}, nil
+}
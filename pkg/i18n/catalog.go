@@ -0,0 +1,479 @@
+package i18n
+
+// catalog holds the translations of every message by its code, the
+// codes are stable so clients can rely on them.
+var catalog = map[string]map[string]string{
+	// requests
+	"invalid_request_format": {
+		English: "invalid request format: '%v'",
+		Spanish: "formato de la solicitud no válido: '%v'",
+	},
+	"request_too_large": {
+		English: "the request body is larger than %d bytes",
+		Spanish: "el cuerpo de la solicitud supera los %d bytes",
+	},
+	"invalid_param": {
+		English: "invalid %s: '%v'",
+		Spanish: "%s no válido: '%v'",
+	},
+	"unsupported_operation": {
+		English: "unsupported operation '%s'",
+		Spanish: "la operación '%s' no está soportada",
+	},
+
+	// users
+	"user_not_found": {
+		English: "user '%s' doesn't exist",
+		Spanish: "el usuario '%s' no existe",
+	},
+	"first_name_required": {
+		English: "first name is required",
+		Spanish: "el nombre es obligatorio",
+	},
+	"last_name_required": {
+		English: "last name is required",
+		Spanish: "el apellido es obligatorio",
+	},
+	"username_required": {
+		English: "username is required",
+		Spanish: "el nombre de usuario es obligatorio",
+	},
+	"password_required": {
+		English: "password is required",
+		Spanish: "la contraseña es obligatoria",
+	},
+	"username_taken": {
+		English: "username already exists",
+		Spanish: "el nombre de usuario ya existe",
+	},
+	"email_required": {
+		English: "the user has no email",
+		Spanish: "el usuario no tiene email",
+	},
+	"query_required": {
+		English: "query is required",
+		Spanish: "la búsqueda es obligatoria",
+	},
+	"invalid_sort": {
+		English: "invalid sort field '%s'",
+		Spanish: "el campo de orden '%s' no es válido",
+	},
+	"version_mismatch": {
+		English: "user has been modified, version doesn't match",
+		Spanish: "el usuario fue modificado, la versión no coincide",
+	},
+
+	// patches
+	"invalid_patch": {
+		English: "invalid patch: %s",
+		Spanish: "patch no válido: %s",
+	},
+	"patch_test_failed": {
+		English: "patch test failed for '%s'",
+		Spanish: "la prueba del patch falló para '%s'",
+	},
+	"patch_missing_value": {
+		English: "missing value for '%s'",
+		Spanish: "falta el valor de '%s'",
+	},
+	"patch_invalid_value": {
+		English: "invalid value for '%s'",
+		Spanish: "el valor de '%s' no es válido",
+	},
+	"patch_not_string": {
+		English: "'%s' must be a string or null",
+		Spanish: "'%s' debe ser un texto o null",
+	},
+	"patch_not_patchable": {
+		English: "'%s' can't be patched",
+		Spanish: "'%s' no se puede modificar",
+	},
+	"patch_invalid_path": {
+		English: "invalid path '%s'",
+		Spanish: "la ruta '%s' no es válida",
+	},
+	"patch_attributes_not_object": {
+		English: "'attributes' must be an object",
+		Spanish: "'attributes' debe ser un objeto",
+	},
+	"patch_attributes_copy": {
+		English: "attributes can't be copied or moved",
+		Spanish: "los atributos no se pueden copiar ni mover",
+	},
+
+	// imports, exports and batches
+	"import_empty": {
+		English: "the import has no rows",
+		Spanish: "la importación no tiene filas",
+	},
+	"import_not_found": {
+		English: "import '%s' doesn't exist",
+		Spanish: "la importación '%s' no existe",
+	},
+	"invalid_import_format": {
+		English: "format must be csv or ndjson",
+		Spanish: "el formato debe ser csv o ndjson",
+	},
+	"import_invalid_header": {
+		English: "invalid csv header: %v",
+		Spanish: "el encabezado del csv no es válido: %v",
+	},
+	"import_column_required": {
+		English: "csv column '%s' is required",
+		Spanish: "la columna '%s' del csv es obligatoria",
+	},
+	"import_username_duplicated": {
+		English: "username is duplicated in the file",
+		Spanish: "el nombre de usuario está repetido en el archivo",
+	},
+	"import_column_count": {
+		English: "expected %d columns, got %d",
+		Spanish: "se esperaban %d columnas, se recibieron %d",
+	},
+	"invalid_export_column": {
+		English: "invalid export column '%s'",
+		Spanish: "la columna de exportación '%s' no es válida",
+	},
+	"invalid_export_format": {
+		English: "invalid export format '%s', must be csv, ndjson or json",
+		Spanish: "el formato de exportación '%s' no es válido, debe ser csv, ndjson o json",
+	},
+	"batch_empty": {
+		English: "the batch has no operations",
+		Spanish: "el lote no tiene operaciones",
+	},
+	"batch_too_large": {
+		English: "the batch has too many operations",
+		Spanish: "el lote tiene demasiadas operaciones",
+	},
+	"invalid_batch_op": {
+		English: "invalid operation: %s",
+		Spanish: "operación no válida: %s",
+	},
+	"batch_invalid_create": {
+		English: "invalid create data: %s",
+		Spanish: "los datos de alta no son válidos: %s",
+	},
+	"batch_invalid_update": {
+		English: "invalid update data: %s",
+		Spanish: "los datos de modificación no son válidos: %s",
+	},
+	"batch_id_required": {
+		English: "id is required",
+		Spanish: "el id es obligatorio",
+	},
+
+	// sessions and logins
+	"admin_required": {
+		English: "an admin token is required",
+		Spanish: "se requiere un token de administrador",
+	},
+	"invalid_user_information": {
+		English: "invalid user information",
+		Spanish: "la información del usuario no es válida",
+	},
+	"unauthorized_user": {
+		English: "Unauthorized user",
+		Spanish: "usuario no autorizado",
+	},
+	"session_revoked": {
+		English: "the session has been revoked",
+		Spanish: "la sesión fue revocada",
+	},
+	"login_not_found": {
+		English: "login '%s' doesn't exist",
+		Spanish: "el inicio de sesión '%s' no existe",
+	},
+	"trusted_device_not_found": {
+		English: "trusted device '%s' doesn't exist",
+		Spanish: "el dispositivo de confianza '%s' no existe",
+	},
+	"passkeys_disabled": {
+		English: "passkeys are not enabled",
+		Spanish: "las passkeys no están habilitadas",
+	},
+	"passkey_invalid": {
+		English: "the passkey response is invalid",
+		Spanish: "la respuesta de la passkey no es válida",
+	},
+	"passkey_not_found": {
+		English: "passkey '%s' doesn't exist",
+		Spanish: "la passkey '%s' no existe",
+	},
+	"challenge_not_found": {
+		English: "challenge '%s' doesn't exist or has expired",
+		Spanish: "el desafío '%s' no existe o expiró",
+	},
+	"magic_link_disabled": {
+		English: "magic links are not enabled",
+		Spanish: "los enlaces mágicos no están habilitados",
+	},
+	"magic_link_recipient_required": {
+		English: "username or email is required",
+		Spanish: "el nombre de usuario o el email es obligatorio",
+	},
+	"magic_link_token_required": {
+		English: "token is required",
+		Spanish: "el token es obligatorio",
+	},
+	"magic_link_invalid": {
+		English: "the link is invalid, used or expired",
+		Spanish: "el enlace no es válido, ya se usó o expiró",
+	},
+	"magic_link_browser": {
+		English: "the link must be opened in the browser it was requested from",
+		Spanish: "el enlace debe abrirse en el navegador desde el que se pidió",
+	},
+
+	// second factors
+	"code_required": {
+		English: "code is required",
+		Spanish: "el código es obligatorio",
+	},
+	"invalid_code": {
+		English: "the code is invalid or has expired",
+		Spanish: "el código no es válido o expiró",
+	},
+	"too_many_codes": {
+		English: "too many codes were sent, try again later",
+		Spanish: "se enviaron demasiados códigos, intenta más tarde",
+	},
+	"invalid_factor": {
+		English: "factor '%s' is not available",
+		Spanish: "el factor '%s' no está disponible",
+	},
+	"factor_not_found": {
+		English: "factor '%s' doesn't exist",
+		Spanish: "el factor '%s' no existe",
+	},
+	"factor_exists": {
+		English: "a factor of type '%s' is already enrolled",
+		Spanish: "ya hay un factor de tipo '%s' registrado",
+	},
+	"factor_approved": {
+		English: "the factor is already approved",
+		Spanish: "el factor ya está aprobado",
+	},
+	"factor_label_too_long": {
+		English: "the factor label is too long",
+		Spanish: "la etiqueta del factor es demasiado larga",
+	},
+
+	// avatars and blobs
+	"avatar_required": {
+		English: "avatar is required",
+		Spanish: "el avatar es obligatorio",
+	},
+	"invalid_avatar_size": {
+		English: "invalid avatar size %d, must be 64, 128 or 256",
+		Spanish: "el tamaño de avatar %d no es válido, debe ser 64, 128 o 256",
+	},
+	"storage_disabled": {
+		English: "no storage is set up",
+		Spanish: "no hay almacenamiento configurado",
+	},
+	"object_not_found": {
+		English: "object not found",
+		Spanish: "no se encontró el objeto",
+	},
+	"invalid_object_key": {
+		English: "invalid object key",
+		Spanish: "la clave del objeto no es válida",
+	},
+	"invalid_link": {
+		English: "the link is invalid or has expired",
+		Spanish: "el enlace no es válido o expiró",
+	},
+	"image_too_large": {
+		English: "the image is too large",
+		Spanish: "la imagen es demasiado grande",
+	},
+	"image_too_many_pixels": {
+		English: "the image has too many pixels",
+		Spanish: "la imagen tiene demasiados píxeles",
+	},
+	"image_unsupported_type": {
+		English: "the image must be a JPEG, PNG, GIF or WebP",
+		Spanish: "la imagen debe ser JPEG, PNG, GIF o WebP",
+	},
+
+	// custom attributes
+	"attribute_not_found": {
+		English: "attribute '%s' doesn't exist",
+		Spanish: "el atributo '%s' no existe",
+	},
+	"attribute_exists": {
+		English: "attribute '%s' already exists",
+		Spanish: "el atributo '%s' ya existe",
+	},
+	"invalid_attribute": {
+		English: "invalid attribute '%s': %s",
+		Spanish: "el atributo '%s' no es válido: %s",
+	},
+	"invalid_attribute_value": {
+		English: "invalid value for attribute '%s': %s",
+		Spanish: "el valor del atributo '%s' no es válido: %s",
+	},
+	"attribute_name": {
+		English: "the name must start with a lower case letter followed by up to 49 lower case letters, digits or underscores",
+		Spanish: "el nombre debe empezar con una letra minúscula seguida de hasta 49 letras minúsculas, dígitos o guiones bajos",
+	},
+	"attribute_label_too_long": {
+		English: "the label is too long",
+		Spanish: "la etiqueta es demasiado larga",
+	},
+	"attribute_type": {
+		English: "the type must be string, number, boolean, date or enum",
+		Spanish: "el tipo debe ser string, number, boolean, date o enum",
+	},
+	"attribute_type_immutable": {
+		English: "the type can't be changed",
+		Spanish: "el tipo no se puede cambiar",
+	},
+	"attribute_visibility": {
+		English: "the visibility must be public, readonly or private",
+		Spanish: "la visibilidad debe ser public, readonly o private",
+	},
+	"attribute_pattern_type": {
+		English: "only string attributes take a pattern",
+		Spanish: "solo los atributos string admiten un patrón",
+	},
+	"attribute_pattern": {
+		English: "the pattern is not a valid regular expression",
+		Spanish: "el patrón no es una expresión regular válida",
+	},
+	"attribute_range_type": {
+		English: "only string and number attributes take a min and max",
+		Spanish: "solo los atributos string y number admiten mínimo y máximo",
+	},
+	"attribute_range_negative": {
+		English: "the min and max length can't be negative",
+		Spanish: "el largo mínimo y máximo no pueden ser negativos",
+	},
+	"attribute_range": {
+		English: "the min is greater than the max",
+		Spanish: "el mínimo es mayor que el máximo",
+	},
+	"attribute_options_type": {
+		English: "only enum attributes take options",
+		Spanish: "solo los atributos enum admiten opciones",
+	},
+	"attribute_options_required": {
+		English: "an enum needs options",
+		Spanish: "un enum necesita opciones",
+	},
+	"attribute_option_length": {
+		English: "the options can't be empty or longer than 255 bytes",
+		Spanish: "las opciones no pueden estar vacías ni superar los 255 bytes",
+	},
+	"attribute_option_repeated": {
+		English: "the option '%s' is repeated",
+		Spanish: "la opción '%s' está repetida",
+	},
+	"attribute_value_readonly": {
+		English: "it can't be changed",
+		Spanish: "no se puede cambiar",
+	},
+	"attribute_value_required": {
+		English: "it is required",
+		Spanish: "es obligatorio",
+	},
+	"attribute_value_number": {
+		English: "it must be a number",
+		Spanish: "debe ser un número",
+	},
+	"attribute_value_boolean": {
+		English: "it must be a boolean",
+		Spanish: "debe ser un booleano",
+	},
+	"attribute_value_string": {
+		English: "it must be a string",
+		Spanish: "debe ser un texto",
+	},
+	"attribute_value_date": {
+		English: "it must be a date as %s",
+		Spanish: "debe ser una fecha con el formato %s",
+	},
+	"attribute_value_option": {
+		English: "it must be one of the options",
+		Spanish: "debe ser una de las opciones",
+	},
+	"attribute_value_min": {
+		English: "it must be at least %v",
+		Spanish: "debe ser al menos %v",
+	},
+	"attribute_value_max": {
+		English: "it must be at most %v",
+		Spanish: "debe ser como máximo %v",
+	},
+	"attribute_value_too_long": {
+		English: "it is too long",
+		Spanish: "es demasiado largo",
+	},
+	"attribute_value_min_length": {
+		English: "it must have at least %v characters",
+		Spanish: "debe tener al menos %v caracteres",
+	},
+	"attribute_value_max_length": {
+		English: "it must have at most %v characters",
+		Spanish: "debe tener como máximo %v caracteres",
+	},
+	"attribute_value_pattern": {
+		English: "it doesn't match the pattern",
+		Spanish: "no coincide con el patrón",
+	},
+
+	// preferences
+	"invalid_locale": {
+		English: "invalid locale '%s', must be a BCP 47 language tag",
+		Spanish: "el idioma '%s' no es válido, debe ser una etiqueta BCP 47",
+	},
+	"invalid_timezone": {
+		English: "invalid timezone '%s', must be an IANA time zone",
+		Spanish: "la zona horaria '%s' no es válida, debe ser una zona horaria IANA",
+	},
+	"invalid_date_format": {
+		English: "invalid date format '%s', must be YYYY-MM-DD, DD/MM/YYYY, MM/DD/YYYY or DD.MM.YYYY",
+		Spanish: "el formato de fecha '%s' no es válido, debe ser YYYY-MM-DD, DD/MM/YYYY, MM/DD/YYYY o DD.MM.YYYY",
+	},
+	"invalid_channel": {
+		English: "invalid notification channel '%s', must be email, sms or push",
+		Spanish: "el canal de notificación '%s' no es válido, debe ser email, sms o push",
+	},
+
+	// webhooks
+	"webhook_not_found": {
+		English: "webhook '%s' doesn't exist",
+		Spanish: "el webhook '%s' no existe",
+	},
+	"webhook_url_required": {
+		English: "url is required",
+		Spanish: "la url es obligatoria",
+	},
+	"webhook_invalid_url": {
+		English: "url must be an absolute http or https url",
+		Spanish: "la url debe ser una url http o https absoluta",
+	},
+	"webhook_private_url": {
+		English: "url can't point to a loopback or private address",
+		Spanish: "la url no puede apuntar a una dirección local o privada",
+	},
+	"webhook_events_required": {
+		English: "events are required",
+		Spanish: "los eventos son obligatorios",
+	},
+	"webhook_invalid_event": {
+		English: "invalid event '%s'",
+		Spanish: "el evento '%s' no es válido",
+	},
+	"webhook_delivery_not_found": {
+		English: "delivery '%s' doesn't exist",
+		Spanish: "la entrega '%s' no existe",
+	},
+
+	// audit
+	"audit_invalid_outcome": {
+		English: "invalid outcome '%s'",
+		Spanish: "el resultado '%s' no es válido",
+	},
+}
@@ -0,0 +1,38 @@
+// Package i18n picks the language messages are written in and holds
+// the catalogue of their translations, keyed by stable codes.
+package i18n
+
+import "golang.org/x/text/language"
+
+// the languages messages are translated to, English is the fallback
+const (
+	English = "en"
+	Spanish = "es"
+)
+
+var matcher = language.NewMatcher([]language.Tag{language.English, language.Spanish})
+
+// Lang returns the supported language closest to the locales, BCP 47
+// tags in order of preference. Invalid or unsupported ones are skipped.
+func Lang(locales ...string) string {
+	var tags []language.Tag
+	for _, l := range locales {
+		if t, err := language.Parse(l); err == nil {
+			tags = append(tags, t)
+		}
+	}
+
+	if _, i, c := matcher.Match(tags...); c != language.No && i == 1 {
+		return Spanish
+	}
+	return English
+}
+
+// Canonical returns the canonical form of a BCP 47 locale.
+func Canonical(locale string) (string, error) {
+	t, err := language.Parse(locale)
+	if err != nil {
+		return "", err
+	}
+	return t.String(), nil
+}
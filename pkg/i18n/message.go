@@ -0,0 +1,159 @@
+package i18n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/text/language"
+)
+
+// Message is a text of the catalogue, Args fill the placeholders of its
+// translations. Args that are messages or localizable errors are written
+// in the same language.
+type Message struct {
+	Code string
+	Args []interface{}
+}
+
+func Msg(code string, args ...interface{}) Message {
+	return Message{Code: code, Args: args}
+}
+
+// In writes the message in lang, falling back to English, and to the
+// code when it isn't in the catalogue.
+func (m Message) In(lang string) string {
+	texts, ok := catalog[m.Code]
+	if !ok {
+		return m.Code
+	}
+
+	format, ok := texts[lang]
+	if !ok {
+		format = texts[English]
+	}
+
+	args := make([]interface{}, len(m.Args))
+	for i, a := range m.Args {
+		switch a := a.(type) {
+		case Message:
+			args[i] = a.In(lang)
+		case error:
+			args[i] = localize(lang, a)
+		default:
+			args[i] = a
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Localizer is implemented by the errors whose text is in the catalogue.
+type Localizer interface {
+	Localized() Message
+}
+
+// Error is an error with a stable code, its text is the English one.
+type Error struct {
+	Message
+}
+
+func NewError(code string, args ...interface{}) *Error {
+	return &Error{Msg(code, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.In(English)
+}
+
+func (e *Error) Localized() Message {
+	return e.Message
+}
+
+// Unwrap returns the errors among the args, so errors.Is and
+// errors.As see the causes of the message.
+func (e *Error) Unwrap() []error {
+	var errs []error
+	for _, a := range e.Args {
+		if err, ok := a.(error); ok {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Localize writes err in the language of the request, errors
+// that aren't in the catalogue keep their text.
+func Localize(ctx context.Context, err error) string {
+	return localize(FromContext(ctx), err)
+}
+
+// T writes the message code in the language of the request.
+func T(ctx context.Context, code string, args ...interface{}) string {
+	return Msg(code, args...).In(FromContext(ctx))
+}
+
+func localize(lang string, err error) string {
+	var l Localizer
+	if errors.As(err, &l) {
+		return l.Localized().In(lang)
+	}
+	return err.Error()
+}
+
+// languages holds what the language of a request is picked from, the
+// locale stored for the user is read the first time it is needed.
+type languages struct {
+	accept []string
+	user   func() string
+	lang   string
+}
+
+type ctxKey struct{}
+
+// FromContext returns the language of the request: the locale of the
+// authenticated user, then Accept-Language, then English.
+func FromContext(ctx context.Context) string {
+	l, ok := ctx.Value(ctxKey{}).(*languages)
+	if !ok {
+		return English
+	}
+
+	if l.lang == "" {
+		locales := l.accept
+		if l.user != nil {
+			if locale := l.user(); locale != "" {
+				locales = append([]string{locale}, locales...)
+			}
+		}
+		l.lang = Lang(locales...)
+	}
+	return l.lang
+}
+
+// WithLang returns a context whose messages are written in lang,
+// for work that outlives the request it was started from.
+func WithLang(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &languages{lang: lang})
+}
+
+// SetUserLocale sets how to read the locale of the authenticated user,
+// it is only called when a message is written.
+func SetUserLocale(ctx context.Context, locale func() string) {
+	if l, ok := ctx.Value(ctxKey{}).(*languages); ok {
+		l.user = locale
+		l.lang = ""
+	}
+}
+
+// Populate is a go-kit ServerBefore function storing the languages
+// of Accept-Language in the context.
+func Populate(ctx context.Context, r *http.Request) context.Context {
+	l := &languages{}
+	if tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil {
+		for _, t := range tags {
+			l.accept = append(l.accept, t.String())
+		}
+	}
+	return context.WithValue(ctx, ctxKey{}, l)
+}
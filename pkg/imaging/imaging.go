@@ -0,0 +1,192 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+)
+
+var (
+	ErrTooLarge        = i18n.NewError("image_too_large")
+	ErrTooManyPixels   = i18n.NewError("image_too_many_pixels")
+	ErrUnsupportedType = i18n.NewError("image_unsupported_type")
+)
+
+// the content types Decode accepts, sniffed from the data
+// instead of trusting what the client says
+var supportedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Orientation is the EXIF orientation of a JPEG, 1 is upright.
+type Orientation int
+
+// Decode reads an image of at most maxBytes and maxPixels, the size is
+// checked before decoding so a small file can't expand into a huge
+// image. The metadata, EXIF included, isn't kept, only the orientation.
+func Decode(r io.Reader, maxBytes int64, maxPixels int) (image.Image, Orientation, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, 0, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if !supportedTypes[contentType] {
+		return nil, 0, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, ErrUnsupportedType
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxPixels/config.Height {
+		return nil, 0, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, ErrUnsupportedType
+	}
+
+	o := Orientation(1)
+	if contentType == "image/jpeg" {
+		o = jpegOrientation(data)
+	}
+	return img, o, nil
+}
+
+// Square crops the center square of the image, scales it to size and
+// turns it upright. The center square of a turned image is the turned
+// center square, so only the small result is turned.
+func Square(img image.Image, o Orientation, size int) *image.RGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	crop := image.Rect(0, 0, side, side).Add(b.Min).Add(image.Pt((b.Dx()-side)/2, (b.Dy()-side)/2))
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+
+	return orient(dst, o)
+}
+
+// orient turns a square image upright, flipping and rotating
+// it as the EXIF orientation says.
+func orient(img *image.RGBA, o Orientation) *image.RGBA {
+	if o < 2 || o > 8 {
+		return img
+	}
+
+	n := img.Bounds().Dx()
+	dst := image.NewRGBA(img.Bounds())
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			sx, sy := x, y
+			switch o {
+			case 2:
+				sx = n - 1 - x
+			case 3:
+				sx, sy = n-1-x, n-1-y
+			case 4:
+				sy = n - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, n-1-x
+			case 7:
+				sx, sy = n-1-y, n-1-x
+			case 8:
+				sx, sy = n-1-y, x
+			}
+			dst.SetRGBA(x, y, img.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the orientation tag of the EXIF segment,
+// 1 when there is none or it can't be read.
+func jpegOrientation(data []byte) Orientation {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// the image data starts, there are no more metadata segments
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		length := int(data[i+2])<<8 | int(data[i+3])
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) Orientation {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var u16 func(b []byte) int
+	var u32 func(b []byte) int
+	switch string(tiff[:2]) {
+	case "II":
+		u16 = func(b []byte) int { return int(b[0]) | int(b[1])<<8 }
+		u32 = func(b []byte) int { return u16(b) | u16(b[2:])<<16 }
+	case "MM":
+		u16 = func(b []byte) int { return int(b[0])<<8 | int(b[1]) }
+		u32 = func(b []byte) int { return u16(b)<<16 | u16(b[2:]) }
+	default:
+		return 1
+	}
+
+	ifd := u32(tiff[4:])
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := u16(tiff[ifd:])
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if u16(tiff[entry:]) == 0x0112 {
+			if o := u16(tiff[entry+8:]); o >= 1 && o <= 8 {
+				return Orientation(o)
+			}
+			return 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Sender delivers plain text emails.
+type Sender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// NewFromEnv builds the sender set up by MAIL_SMTP_HOST, MAIL_SMTP_PORT,
+// MAIL_SMTP_USER, MAIL_SMTP_PASSWORD and MAIL_FROM. Without a host the
+// emails are only logged.
+func NewFromEnv(l *log.Logger) Sender {
+	host := os.Getenv("MAIL_SMTP_HOST")
+	if host == "" {
+		return NewLog(l)
+	}
+
+	port := os.Getenv("MAIL_SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return NewSMTP(host, port, os.Getenv("MAIL_SMTP_USER"), os.Getenv("MAIL_SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+}
+
+type smtpSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTP(host, port, username, password, from string) Sender {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpSender{
+		addr: host + ":" + port,
+		auth: auth,
+		from: from,
+	}
+}
+
+func (s *smtpSender) Send(_ context.Context, to, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		s.from, to, header(subject), body)
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(msg))
+}
+
+type logSender struct {
+	log *log.Logger
+}
+
+func NewLog(l *log.Logger) Sender {
+	return &logSender{log: l}
+}
+
+func (s *logSender) Send(_ context.Context, to, subject, body string) error {
+	s.log.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// header drops line breaks so a value can't inject other headers.
+func header(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
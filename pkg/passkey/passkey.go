@@ -0,0 +1,178 @@
+package passkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var ErrCloned = errors.New("the passkey sign counter went backwards, the authenticator may have been cloned")
+
+type (
+	// Passkey runs the WebAuthn registration and assertion ceremonies. The
+	// begin steps return the options for the browser and the session to keep
+	// until the ceremony is finished, the finish steps verify the browser
+	// response against that session.
+	Passkey interface {
+		BeginRegistration(user *User) (interface{}, []byte, error)
+		FinishRegistration(user *User, session, response []byte) (*webauthn.Credential, error)
+		BeginLogin(user *User) (interface{}, []byte, error)
+		FinishLogin(user *User, session, response []byte) (*webauthn.Credential, error)
+		BeginDiscoverableLogin() (interface{}, []byte, error)
+		FinishDiscoverableLogin(session, response []byte, lookup func(userID string) (*User, error)) (*User, *webauthn.Credential, error)
+	}
+
+	// User is the account a ceremony runs for, ID is sent to the
+	// authenticator as the user handle.
+	User struct {
+		ID          string
+		Name        string
+		DisplayName string
+		Credentials []webauthn.Credential
+	}
+
+	passkey struct {
+		web *webauthn.WebAuthn
+	}
+)
+
+func New(rpID, rpName string, origins []string) (Passkey, error) {
+	web, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &passkey{web: web}, nil
+}
+
+func (u *User) WebAuthnID() []byte                         { return []byte(u.ID) }
+func (u *User) WebAuthnName() string                       { return u.Name }
+func (u *User) WebAuthnDisplayName() string                { return u.DisplayName }
+func (u *User) WebAuthnCredentials() []webauthn.Credential { return u.Credentials }
+func (u *User) WebAuthnIcon() string                       { return "" }
+
+// BeginRegistration asks for a discoverable credential when the authenticator
+// supports it, so it can be used for passwordless logins, and excludes the
+// authenticators the user already registered.
+func (p *passkey) BeginRegistration(user *User) (interface{}, []byte, error) {
+	exclude := make([]protocol.CredentialDescriptor, len(user.Credentials))
+	for i, c := range user.Credentials {
+		exclude[i] = c.Descriptor()
+	}
+
+	options, session, err := p.web.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		webauthn.WithExclusions(exclude),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeSession(options, session)
+}
+
+func (p *passkey) FinishRegistration(user *User, session, response []byte) (*webauthn.Credential, error) {
+	s, err := decodeSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+	return p.web.CreateCredential(user, *s, parsed)
+}
+
+func (p *passkey) BeginLogin(user *User) (interface{}, []byte, error) {
+	options, session, err := p.web.BeginLogin(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeSession(options, session)
+}
+
+func (p *passkey) FinishLogin(user *User, session, response []byte) (*webauthn.Credential, error) {
+	s, err := decodeSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := p.web.ValidateLogin(user, *s, parsed)
+	if err != nil {
+		return nil, err
+	}
+	return checkCounter(cred)
+}
+
+// BeginDiscoverableLogin starts a passwordless login, the user is known once
+// the authenticator answers. As the passkey is the only factor, the
+// authenticator must verify the user.
+func (p *passkey) BeginDiscoverableLogin() (interface{}, []byte, error) {
+	options, session, err := p.web.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeSession(options, session)
+}
+
+func (p *passkey) FinishDiscoverableLogin(session, response []byte, lookup func(userID string) (*User, error)) (*User, *webauthn.Credential, error) {
+	s, err := decodeSession(session)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var user *User
+	cred, err := p.web.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		user, err = lookup(string(userHandle))
+		return user, err
+	}, *s, parsed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cred, err = checkCounter(cred)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, cred, nil
+}
+
+// checkCounter rejects the assertion when the sign counter didn't increase.
+func checkCounter(cred *webauthn.Credential) (*webauthn.Credential, error) {
+	if cred.Authenticator.CloneWarning {
+		return nil, ErrCloned
+	}
+	return cred, nil
+}
+
+func encodeSession(options interface{}, session *webauthn.SessionData) (interface{}, []byte, error) {
+	b, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return options, b, nil
+}
+
+func decodeSession(b []byte) (*webauthn.SessionData, error) {
+	var s webauthn.SessionData
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,253 @@
+package passkey_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey"
+	"github.com/ncostamagna/go-app-users-lab/pkg/passkey/passkeytest"
+)
+
+const origin = "https://users.example.com"
+
+func newPasskey(t *testing.T) passkey.Passkey {
+	t.Helper()
+	p, err := passkey.New("users.example.com", "Users", []string{origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// register runs a registration ceremony and returns the user holding
+// the new credential.
+func register(t *testing.T, p passkey.Passkey, a *passkeytest.Authenticator) *passkey.User {
+	t.Helper()
+	user := &passkey.User{ID: "4f1c6a5e-8f0e-4d43-9a55-5a3b2f1e7c10", Name: "jdoe", DisplayName: "John Doe"}
+
+	options, session, err := p.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := a.Register(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := p.FinishRegistration(user, session, response)
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	user.Credentials = []webauthn.Credential{*cred}
+	return user
+}
+
+func login(p passkey.Passkey, user *passkey.User, a *passkeytest.Authenticator) (*webauthn.Credential, error) {
+	options, session, err := p.BeginLogin(user)
+	if err != nil {
+		return nil, err
+	}
+	response, err := a.Assert(options)
+	if err != nil {
+		return nil, err
+	}
+	return p.FinishLogin(user, session, response)
+}
+
+func TestRegistration(t *testing.T) {
+	p := newPasskey(t)
+	a, err := passkeytest.New(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := register(t, p, a)
+
+	cred := user.Credentials[0]
+	if string(cred.ID) != string(a.CredentialID) {
+		t.Errorf("credential ID = %x, want %x", cred.ID, a.CredentialID)
+	}
+	if cred.AttestationType != "none" {
+		t.Errorf("attestation = %q, want none", cred.AttestationType)
+	}
+	if string(a.UserHandle) != user.ID {
+		t.Errorf("user handle = %q, want the user ID %q", a.UserHandle, user.ID)
+	}
+}
+
+func TestRegistrationWrongOrigin(t *testing.T) {
+	p := newPasskey(t)
+	a, err := passkeytest.New("https://evil.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &passkey.User{ID: "4f1c6a5e-8f0e-4d43-9a55-5a3b2f1e7c10", Name: "jdoe", DisplayName: "jdoe"}
+	options, session, err := p.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := a.Register(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.FinishRegistration(user, session, response); err == nil {
+		t.Error("registered a credential created for another origin")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	p := newPasskey(t)
+	a, err := passkeytest.New(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := register(t, p, a)
+
+	for want := uint32(1); want <= 2; want++ {
+		cred, err := login(p, user, a)
+		if err != nil {
+			t.Fatalf("login %d: %v", want, err)
+		}
+		if cred.Authenticator.SignCount != want {
+			t.Errorf("sign count = %d, want %d", cred.Authenticator.SignCount, want)
+		}
+		user.Credentials[0].Authenticator.SignCount = cred.Authenticator.SignCount
+	}
+}
+
+func TestLoginReplayedSession(t *testing.T) {
+	p := newPasskey(t)
+	a, err := passkeytest.New(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := register(t, p, a)
+
+	_, session, err := p.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// answered for another challenge than the one of the session
+	options, _, err := p.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := a.Assert(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.FinishLogin(user, session, response); err == nil {
+		t.Error("accepted an assertion of another challenge")
+	}
+}
+
+func TestLoginCounterRegression(t *testing.T) {
+	p := newPasskey(t)
+	a, err := passkeytest.New(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := register(t, p, a)
+
+	// a clone of the authenticator is behind the counter stored for the passkey
+	user.Credentials[0].Authenticator.SignCount = 10
+	a.Counter = 10
+
+	if _, err := login(p, user, a); !errors.Is(err, passkey.ErrCloned) {
+		t.Errorf("login with the same counter: err = %v, want ErrCloned", err)
+	}
+
+	a.Counter = 5
+	if _, err := login(p, user, a); !errors.Is(err, passkey.ErrCloned) {
+		t.Errorf("login with a lower counter: err = %v, want ErrCloned", err)
+	}
+
+	a.Counter = 11
+	if _, err := login(p, user, a); err != nil {
+		t.Errorf("login with a higher counter: %v", err)
+	}
+}
+
+func TestLoginOtherKey(t *testing.T) {
+	p := newPasskey(t)
+	a, err := passkeytest.New(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := register(t, p, a)
+
+	// same credential ID, signed by another key
+	other, err := passkeytest.New(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.CredentialID = a.CredentialID
+	other.UserHandle = a.UserHandle
+
+	if _, err := login(p, user, other); err == nil {
+		t.Error("accepted an assertion signed by another key")
+	}
+}
+
+func TestDiscoverableLogin(t *testing.T) {
+	p := newPasskey(t)
+	a, err := passkeytest.New(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := register(t, p, a)
+
+	options, session, err := p.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := a.Assert(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, cred, err := p.FinishDiscoverableLogin(session, response, func(userID string) (*passkey.User, error) {
+		if userID != user.ID {
+			t.Errorf("looked up %q, want %q", userID, user.ID)
+		}
+		return user, nil
+	})
+	if err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+	if got != user {
+		t.Errorf("logged in %+v, want %+v", got, user)
+	}
+	if cred.Authenticator.SignCount != 1 {
+		t.Errorf("sign count = %d, want 1", cred.Authenticator.SignCount)
+	}
+}
+
+func TestDiscoverableLoginUnknownUser(t *testing.T) {
+	p := newPasskey(t)
+	a, err := passkeytest.New(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	register(t, p, a)
+
+	options, session, err := p.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := a.Assert(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notFound := errors.New("not found")
+	if _, _, err := p.FinishDiscoverableLogin(session, response, func(string) (*passkey.User, error) {
+		return nil, notFound
+	}); err == nil {
+		t.Error("logged in a user that doesn't exist")
+	}
+}
@@ -0,0 +1,181 @@
+// Package passkeytest provides a software authenticator answering the
+// WebAuthn ceremonies, for tests.
+package passkeytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+// Authenticator holds one ES256 credential, registered with "none"
+// attestation. Counter is the sign counter of the next assertion, it
+// goes up by one after each of them.
+type Authenticator struct {
+	Origin       string
+	CredentialID []byte
+	UserHandle   []byte
+	Counter      uint32
+
+	key *ecdsa.PrivateKey
+}
+
+// credentialOptions are the fields of the creation and request options the
+// authenticator reads, they are both under publicKey.
+type credentialOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RPID      string `json:"rpId"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID protocol.URLEncodedBase64 `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+func New(origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{
+		Origin:       origin,
+		CredentialID: id,
+		Counter:      1,
+		key:          key,
+	}, nil
+}
+
+// Register answers the options of a registration ceremony,
+// as navigator.credentials.create would.
+func (a *Authenticator) Register(options interface{}) ([]byte, error) {
+	o, err := readOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	a.UserHandle = o.PublicKey.User.ID
+
+	clientData, err := a.clientData(protocol.CreateCeremony, o.PublicKey.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	authData := a.authData(o.PublicKey.RP.ID, byte(protocol.FlagAttestedCredentialData), 0)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.CredentialID)))
+	authData = append(authData, a.CredentialID...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a.credential(map[string]string{
+		"clientDataJSON":    encode(clientData),
+		"attestationObject": encode(attestation),
+	})
+}
+
+// Assert answers the options of a login ceremony, as
+// navigator.credentials.get would, and moves the counter on.
+func (a *Authenticator) Assert(options interface{}) ([]byte, error) {
+	o, err := readOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	clientData, err := a.clientData(protocol.AssertCeremony, o.PublicKey.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	authData := a.authData(o.PublicKey.RPID, 0, a.Counter)
+	a.Counter++
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return a.credential(map[string]string{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.UserHandle),
+	})
+}
+
+// authData is the authenticator data up to the counter, the user
+// is always present and verified.
+func (a *Authenticator) authData(rpID string, flags byte, counter uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags|byte(protocol.FlagUserPresent)|byte(protocol.FlagUserVerified))
+	return binary.BigEndian.AppendUint32(data, counter)
+}
+
+func (a *Authenticator) clientData(ceremony protocol.CeremonyType, challenge string) ([]byte, error) {
+	return json.Marshal(protocol.CollectedClientData{
+		Type:      ceremony,
+		Challenge: challenge,
+		Origin:    a.Origin,
+	})
+}
+
+func (a *Authenticator) credential(response map[string]string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":       encode(a.CredentialID),
+		"rawId":    encode(a.CredentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+}
+
+func readOptions(options interface{}) (*credentialOptions, error) {
+	b, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var o credentialOptions
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
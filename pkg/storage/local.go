@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metaSuffix names the file next to each object with its content type and expiry.
+const metaSuffix = ".meta.json"
+
+type localMeta struct {
+	ContentType string     `json:"content_type,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// local keeps the objects in a directory, the key is the path below it.
+type local struct {
+	root   string
+	signer *Signer
+}
+
+func NewLocal(root string, signer *Signer) (Storage, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &local{root: root, signer: signer}, nil
+}
+
+func (l *local) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(key, metaSuffix) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file first, a reader never sees half an object.
+func (l *local) Put(_ context.Context, key string, r io.Reader, opts PutOptions) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return err
+	}
+
+	meta, err := json.Marshal(localMeta{ContentType: opts.ContentType, ExpiresAt: expiresAt(opts.TTL)})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+metaSuffix, meta, 0o640); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *local) Get(_ context.Context, key string) (*Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := readMeta(p)
+	if err != nil {
+		return nil, err
+	}
+	if expired(meta.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Object{
+		Body:        f,
+		ContentType: meta.ContentType,
+		Size:        info.Size(),
+		ExpiresAt:   meta.ExpiresAt,
+	}, nil
+}
+
+func (l *local) Delete(_ context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	return remove(p)
+}
+
+func (l *local) URL(_ context.Context, key string, ttl time.Duration) (string, error) {
+	if l.signer == nil {
+		return "", ErrSignerRequired
+	}
+	return l.signer.URL(key, ttl), nil
+}
+
+func (l *local) Cleanup(ctx context.Context) (int, error) {
+	n := 0
+	err := filepath.WalkDir(l.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || !strings.HasSuffix(p, metaSuffix) {
+			return nil
+		}
+
+		object := strings.TrimSuffix(p, metaSuffix)
+		meta, err := readMeta(object)
+		if err != nil || !expired(meta.ExpiresAt) {
+			return nil
+		}
+
+		if err := remove(object); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func readMeta(p string) (*localMeta, error) {
+	b, err := os.ReadFile(p + metaSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var meta localMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// remove deletes the object and its meta file, missing files are ignored.
+func remove(p string) error {
+	for _, f := range []string{p, p + metaSuffix} {
+		if err := os.Remove(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
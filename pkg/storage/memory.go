@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	expiresAt   *time.Time
+}
+
+// memory keeps the objects in the process, they are lost on restart
+// and aren't shared between replicas.
+type memory struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	signer  *Signer
+}
+
+func NewMemory(signer *Signer) Storage {
+	return &memory{objects: make(map[string]memoryObject), signer: signer}
+}
+
+func (m *memory) Put(_ context.Context, key string, r io.Reader, opts PutOptions) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{data: data, contentType: opts.ContentType, expiresAt: expiresAt(opts.TTL)}
+	return nil
+}
+
+func (m *memory) Get(_ context.Context, key string) (*Object, error) {
+	m.mu.RLock()
+	o, ok := m.objects[key]
+	m.mu.RUnlock()
+
+	if !ok || expired(o.expiresAt) {
+		return nil, ErrNotFound
+	}
+
+	return &Object{
+		Body:        io.NopCloser(bytes.NewReader(o.data)),
+		ContentType: o.contentType,
+		Size:        int64(len(o.data)),
+		ExpiresAt:   o.expiresAt,
+	}, nil
+}
+
+func (m *memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memory) URL(_ context.Context, key string, ttl time.Duration) (string, error) {
+	if m.signer == nil {
+		return "", ErrSignerRequired
+	}
+	return m.signer.URL(key, ttl), nil
+}
+
+func (m *memory) Cleanup(_ context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for key, o := range m.objects {
+		if expired(o.expiresAt) {
+			delete(m.objects, key)
+			n++
+		}
+	}
+	return n, nil
+}
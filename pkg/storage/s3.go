@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// expiresMeta is the user metadata holding the expiry of an object.
+const expiresMeta = "Expires-At"
+
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+}
+
+// s3 keeps the objects in an S3 compatible bucket, its links are presigned.
+type s3 struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3(c S3Config) (Storage, error) {
+	if c.Bucket == "" {
+		return nil, ErrMissingS3Bucket
+	}
+
+	client, err := minio.New(c.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(c.AccessKey, c.SecretKey, ""),
+		Secure: c.UseSSL,
+		Region: c.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3{client: client, bucket: c.Bucket}, nil
+}
+
+func (s *s3) Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	putOpts := minio.PutObjectOptions{ContentType: opts.ContentType}
+	if t := expiresAt(opts.TTL); t != nil {
+		putOpts.UserMetadata = map[string]string{expiresMeta: t.UTC().Format(time.RFC3339)}
+	}
+
+	// without the size the client buffers a part of hundreds of megabytes
+	size := int64(-1)
+	if l, ok := r.(interface{ Len() int }); ok {
+		size = int64(l.Len())
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, putOpts)
+	return err
+}
+
+func (s *s3) Get(ctx context.Context, key string) (*Object, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	t := s3ExpiresAt(info)
+	if expired(t) {
+		return nil, ErrNotFound
+	}
+
+	o, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return &Object{
+		Body:        o,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		ExpiresAt:   t,
+	}, nil
+}
+
+func (s *s3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *s3) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, ttl, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Cleanup checks the metadata of every object, a lifecycle rule on
+// the bucket does the same without listing it.
+func (s *s3) Cleanup(ctx context.Context) (int, error) {
+	n := 0
+	for o := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if o.Err != nil {
+			return n, o.Err
+		}
+
+		info, err := s.client.StatObject(ctx, s.bucket, o.Key, minio.StatObjectOptions{})
+		if err != nil {
+			return n, err
+		}
+		if !expired(s3ExpiresAt(info)) {
+			continue
+		}
+
+		if err := s.Delete(ctx, o.Key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func s3ExpiresAt(info minio.ObjectInfo) *time.Time {
+	v, ok := info.UserMetadata[expiresMeta]
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func s3Error(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stubObject struct {
+	data        []byte
+	contentType string
+	meta        http.Header
+	modified    time.Time
+}
+
+// s3Stub is the part of the S3 API the storage uses, path style and
+// for one bucket. Requests aren't authenticated.
+type s3Stub struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]stubObject
+}
+
+func newS3Stub(t *testing.T, bucket string) *httptest.Server {
+	t.Helper()
+	stub := &s3Stub{bucket: bucket, objects: make(map[string]stubObject)}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != s.bucket {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r)
+	case key != "" && r.Method == http.MethodPut:
+		s.put(w, r, key)
+	case key != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		s.get(w, r, key)
+	case key != "" && r.Method == http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *s3Stub) put(w http.ResponseWriter, r *http.Request, key string) {
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = awsChunked(r.Body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	meta := make(http.Header)
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Amz-Meta-") {
+			meta[k] = v
+		}
+	}
+
+	s.mu.Lock()
+	s.objects[key] = stubObject{data: data, contentType: r.Header.Get("Content-Type"), meta: meta, modified: time.Now()}
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", etag(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *s3Stub) get(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.Lock()
+	o, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		s.error(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	for k, v := range o.meta {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", o.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+	w.Header().Set("ETag", etag(o.data))
+	w.Header().Set("Last-Modified", o.modified.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(o.data)
+	}
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, r *http.Request) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []content
+	}{Name: s.bucket, Prefix: r.URL.Query().Get("prefix"), MaxKeys: 1000}
+
+	s.mu.Lock()
+	for key, o := range s.objects {
+		if strings.HasPrefix(key, result.Prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: o.modified.UTC().Format("2006-01-02T15:04:05.000Z"),
+				ETag:         etag(o.data),
+				Size:         len(o.data),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (s *s3Stub) error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message><Resource>%s</Resource></Error>", code, code, r.URL.Path)
+	}
+}
+
+func etag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, len(data))
+}
+
+// awsChunked reads the payload of a streaming signed upload, made
+// of "size;chunk-signature=...\r\n" headers each followed by its chunk.
+func awsChunked(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			header, err := br.ReadString('\n')
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+			size, err := strconv.ParseInt(sizeHex, 16, 64)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if size == 0 {
+				pw.Close()
+				return
+			}
+			if _, err := io.CopyN(pw, br, size); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := br.ReadString('\n'); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
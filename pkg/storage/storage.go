@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ncostamagna/go-app-users-lab/pkg/i18n"
+)
+
+var (
+	ErrNotFound        = i18n.NewError("object_not_found")
+	ErrInvalidKey      = i18n.NewError("invalid_object_key")
+	ErrInvalidURL      = i18n.NewError("invalid_link")
+	ErrUnknownStorage  = errors.New("unknown storage, must be local, memory or s3")
+	ErrSignerRequired  = errors.New("the storage needs a signer to build links")
+	ErrMissingS3Bucket = errors.New("S3_BUCKET is required")
+	ErrSigningKey      = errors.New("STORAGE_SIGNING_KEY is required")
+)
+
+type (
+	// Storage keeps blobs by key. Objects stored with a TTL expire,
+	// they can't be read anymore and Cleanup deletes them.
+	Storage interface {
+		Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error
+		// Get returns ErrNotFound when the object doesn't exist or expired,
+		// the caller closes the body.
+		Get(ctx context.Context, key string) (*Object, error)
+		// Delete removes the object, a missing one is not an error.
+		Delete(ctx context.Context, key string) error
+		// URL returns a link to the object that works for ttl.
+		URL(ctx context.Context, key string, ttl time.Duration) (string, error)
+		// Cleanup deletes the expired objects and returns how many.
+		Cleanup(ctx context.Context) (int, error)
+	}
+
+	PutOptions struct {
+		ContentType string
+		// TTL is how long the object is kept, zero keeps it.
+		TTL time.Duration
+	}
+
+	Object struct {
+		Body        io.ReadCloser
+		ContentType string
+		Size        int64
+		ExpiresAt   *time.Time
+	}
+)
+
+// NewFromEnv builds the storage set in STORAGE, the signer builds the
+// links of the local and memory storages.
+//
+// STORAGE: local, memory or s3, memory when empty
+//
+// STORAGE_LOCAL_DIR: the directory of the local storage, ./data when empty
+//
+// S3_ENDPOINT, S3_ACCESS_KEY, S3_SECRET_KEY, S3_BUCKET, S3_REGION and
+// S3_USE_SSL: the S3 compatible server, the bucket must exist
+func NewFromEnv(signer *Signer) (Storage, error) {
+	switch os.Getenv("STORAGE") {
+	case "", "memory":
+		return NewMemory(signer), nil
+	case "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "./data"
+		}
+		return NewLocal(dir, signer)
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			UseSSL:    os.Getenv("S3_USE_SSL") != "false",
+		})
+	}
+	return nil, ErrUnknownStorage
+}
+
+// RunCleanup deletes the expired objects every interval until ctx is done.
+func RunCleanup(ctx context.Context, l *log.Logger, s Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.Cleanup(ctx)
+		if err != nil {
+			l.Println("storage cleanup:", err)
+		} else if n > 0 {
+			l.Printf("storage cleanup: %d objects deleted", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Signer builds and checks the links of the storages that have no
+// links of their own, they are served by the blob handler at baseURL.
+type Signer struct {
+	key     []byte
+	baseURL string
+}
+
+// NewSigner fails without a key, the links could be forged otherwise.
+func NewSigner(key, baseURL string) (*Signer, error) {
+	if key == "" {
+		return nil, ErrSigningKey
+	}
+	return &Signer{key: []byte(key), baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// URL returns the link to the key, it carries its expiry and
+// an HMAC-SHA256 signature of the key and the expiry.
+func (s *Signer) URL(key string, ttl time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	v := url.Values{}
+	v.Set("expires", expires)
+	v.Set("signature", s.sign(key, expires))
+
+	return s.baseURL + "/" + escapeKey(key) + "?" + v.Encode()
+}
+
+// Verify checks the link was signed for the key and hasn't expired.
+func (s *Signer) Verify(key, expires, signature string) error {
+	if !hmac.Equal([]byte(signature), []byte(s.sign(key, expires))) {
+		return ErrInvalidURL
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+func (s *Signer) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// validKey rejects the keys that could leave the storage root.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, p := range strings.Split(key, "/") {
+		if p == "" || p == "." || p == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+func expiresAt(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	t := time.Now().Add(ttl)
+	return &t
+}
+
+func expired(t *time.Time) bool {
+	return t != nil && time.Now().After(*t)
+}
@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"avatars/u1/1.png", true},
+		{"2fa/f1.png", true},
+		{"a..b/c", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"../secret", false},
+		{"avatars/../../secret", false},
+		{"avatars/./1.png", false},
+		{"avatars//1.png", false},
+		{"avatars/", false},
+		{`avatars\..\secret`, false},
+		{"..", false},
+	}
+
+	for _, tt := range tests {
+		err := validKey(tt.key)
+		if tt.valid && err != nil {
+			t.Errorf("validKey(%q) = %v, want it valid", tt.key, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("validKey(%q) = %v, want ErrInvalidKey", tt.key, err)
+		}
+	}
+}
+
+func TestNewSignerRequiresKey(t *testing.T) {
+	if _, err := NewSigner("", "/blobs"); !errors.Is(err, ErrSigningKey) {
+		t.Errorf("err = %v, want ErrSigningKey", err)
+	}
+}
+
+// signedLink returns the key, expiry and signature of a link of the signer.
+func signedLink(t *testing.T, s *Signer, key string, ttl time.Duration) (string, string, string) {
+	t.Helper()
+
+	u, err := url.Parse(s.URL(key, ttl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := url.PathUnescape(strings.TrimPrefix(u.Path, "/blobs/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, u.Query().Get("expires"), u.Query().Get("signature")
+}
+
+func TestSignerVerify(t *testing.T) {
+	s, err := NewSigner("storage-key", "http://localhost:8000/blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, expires, signature := signedLink(t, s, "avatars/u 1/1.png", time.Minute)
+	if key != "avatars/u 1/1.png" {
+		t.Fatalf("link of %q, want the key escaped in the path", key)
+	}
+	if err := s.Verify(key, expires, signature); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	other, err := NewSigner("other-key", "http://localhost:8000/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pastExpires, pastSignature := signedLink(t, s, key, -time.Minute)
+
+	tests := []struct {
+		name                    string
+		signer                  *Signer
+		key, expires, signature string
+	}{
+		{"other key", s, "avatars/u2/1.png", expires, signature},
+		{"extended expiry", s, key, expires + "0", signature},
+		{"tampered signature", s, key, expires, strings.Repeat("0", len(signature))},
+		{"no signature", s, key, expires, ""},
+		{"expired", s, key, pastExpires, pastSignature},
+		{"other signing key", other, key, expires, signature},
+	}
+	for _, tt := range tests {
+		if err := tt.signer.Verify(tt.key, tt.expires, tt.signature); !errors.Is(err, ErrInvalidURL) {
+			t.Errorf("%s: err = %v, want ErrInvalidURL", tt.name, err)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	s, err := NewSigner("storage-key", "/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, NewMemory(s))
+}
+
+func TestLocal(t *testing.T) {
+	s, err := NewSigner("storage-key", "/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(t.TempDir(), "blobs")
+	store, err := NewLocal(root, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, store)
+
+	ctx := context.Background()
+	// the meta files can't be read or overwritten as objects
+	if err := store.Put(ctx, "a.png"+metaSuffix, strings.NewReader("{}"), PutOptions{}); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("put of a meta file: err = %v, want ErrInvalidKey", err)
+	}
+	// nothing was written outside the root
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escaped")); !os.IsNotExist(err) {
+		t.Errorf("an object was written outside the root: %v", err)
+	}
+}
+
+func TestS3(t *testing.T) {
+	srv := newS3Stub(t, "users")
+
+	store, err := NewS3(S3Config{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		AccessKey: "minio",
+		SecretKey: "minio123",
+		Bucket:    "users",
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, store)
+}
+
+func TestNewS3RequiresBucket(t *testing.T) {
+	if _, err := NewS3(S3Config{Endpoint: "localhost:9000"}); !errors.Is(err, ErrMissingS3Bucket) {
+		t.Errorf("err = %v, want ErrMissingS3Bucket", err)
+	}
+}
+
+// testStorage runs the behaviour every storage shares.
+func testStorage(t *testing.T, store Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	t.Run("put get delete", func(t *testing.T) {
+		if err := store.Put(ctx, "avatars/u1/1.png", bytes.NewReader([]byte("png")), PutOptions{ContentType: "image/png"}); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		assertObject(t, store, "avatars/u1/1.png", "png", "image/png")
+
+		// a second put replaces the object
+		if err := store.Put(ctx, "avatars/u1/1.png", bytes.NewReader([]byte("new png")), PutOptions{ContentType: "image/png"}); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		assertObject(t, store, "avatars/u1/1.png", "new png", "image/png")
+
+		if err := store.Delete(ctx, "avatars/u1/1.png"); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if _, err := store.Get(ctx, "avatars/u1/1.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get of a deleted object: err = %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, "avatars/u1/1.png"); err != nil {
+			t.Errorf("delete of a missing object: %v", err)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := store.Get(ctx, "avatars/none.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, key := range []string{"", "/abs.png", "../escaped", "avatars/../../escaped", "avatars//1.png"} {
+			if err := store.Put(ctx, key, strings.NewReader("x"), PutOptions{}); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("put %q: err = %v, want ErrInvalidKey", key, err)
+			}
+		}
+	})
+
+	t.Run("url", func(t *testing.T) {
+		link, err := store.URL(ctx, "2fa/f1.png", time.Minute)
+		if err != nil {
+			t.Fatalf("url: %v", err)
+		}
+		if !strings.Contains(link, "2fa/f1.png") {
+			t.Errorf("link %q doesn't point to the key", link)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		if err := store.Put(ctx, "2fa/expired.png", strings.NewReader("qr"), PutOptions{ContentType: "image/png", TTL: time.Millisecond}); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		if err := store.Put(ctx, "2fa/live.png", strings.NewReader("qr"), PutOptions{ContentType: "image/png", TTL: time.Hour}); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		if err := store.Put(ctx, "avatars/kept.png", strings.NewReader("png"), PutOptions{ContentType: "image/png"}); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if _, err := store.Get(ctx, "2fa/expired.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get of an expired object: err = %v, want ErrNotFound", err)
+		}
+		live := assertObject(t, store, "2fa/live.png", "qr", "image/png")
+		if live.ExpiresAt == nil || time.Until(*live.ExpiresAt) < 59*time.Minute {
+			t.Errorf("expires at %v, want in an hour", live.ExpiresAt)
+		}
+
+		n, err := store.Cleanup(ctx)
+		if err != nil {
+			t.Fatalf("cleanup: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("cleanup deleted %d objects, want 1", n)
+		}
+
+		assertObject(t, store, "2fa/live.png", "qr", "image/png")
+		assertObject(t, store, "avatars/kept.png", "png", "image/png")
+
+		if n, err := store.Cleanup(ctx); err != nil || n != 0 {
+			t.Errorf("second cleanup deleted %d objects, err %v, want none", n, err)
+		}
+	})
+}
+
+func assertObject(t *testing.T, store Storage, key, data, contentType string) *Object {
+	t.Helper()
+
+	o, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	defer o.Body.Close()
+
+	b, err := io.ReadAll(o.Body)
+	if err != nil {
+		t.Fatalf("read %s: %v", key, err)
+	}
+	if string(b) != data || o.Size != int64(len(data)) || o.ContentType != contentType {
+		t.Errorf("%s = %q (%d bytes, %s), want %q (%s)", key, b, o.Size, o.ContentType, data, contentType)
+	}
+	return o
+}
@@ -1,9 +1,13 @@
 package twofa
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+
 	"github.com/skip2/go-qrcode"
 	"github.com/twilio/twilio-go"
+	"github.com/twilio/twilio-go/client"
 	verify "github.com/twilio/twilio-go/rest/verify/v2"
 )
 
@@ -17,9 +21,10 @@ const (
 type (
 	TwoFA interface {
 		Create(id string) (*Response, error)
-		GenerateQR(id, url string) error
+		QR(url string) ([]byte, error)
 		Verify(id, code, hash string) error
 		Check(id, code, hash string) error
+		Delete(id, hash string) error
 	}
 	twoFA struct {
 		serviceID    string
@@ -66,17 +71,9 @@ func (t twoFA) Create(id string) (*Response, error) {
 	}, nil
 }
 
-func (t twoFA) GenerateQR(id, url string) error {
-	qrCode, err := qrcode.New(url, qrcode.Medium)
-	if err != nil {
-		return err
-	}
-
-	if err := qrCode.WriteFile(256, fmt.Sprintf("./files/%s.png", id)); err != nil {
-		return err
-	}
-
-	return nil
+// QR encodes the factor URL as a PNG image, it is kept in memory.
+func (t twoFA) QR(url string) ([]byte, error) {
+	return qrcode.Encode(url, qrcode.Medium, 256)
 }
 
 func (t twoFA) Verify(id, code, hash string) error {
@@ -110,3 +107,16 @@ func (t twoFA) Check(id, code, hash string) error {
 
 	return nil
 }
+
+// Delete removes the factor from the Twilio entity, a factor that
+// no longer exists is not an error.
+func (t twoFA) Delete(id, hash string) error {
+	err := t.restClient.VerifyV2.DeleteFactor(t.serviceID, id, hash)
+
+	var restErr *client.TwilioRestError
+	if errors.As(err, &restErr) && restErr.Status == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,101 @@
+package uow
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// UnitOfWork runs several repository calls atomically. The transaction
+	// travels in the context, so every repository that gets its connection
+	// through DB joins it.
+	UnitOfWork interface {
+		Do(ctx context.Context, fn func(ctx context.Context) error) error
+	}
+
+	unitOfWork struct {
+		db *gorm.DB
+	}
+
+	txKey    struct{}
+	hooksKey struct{}
+
+	hooks struct {
+		compensations []func(ctx context.Context) error
+		afterCommit   []func(ctx context.Context)
+	}
+)
+
+func New(db *gorm.DB) UnitOfWork {
+	return &unitOfWork{db: db}
+}
+
+// Do runs fn in a transaction, committing when it returns nil and rolling
+// back otherwise. On rollback, or when the commit fails, the registered
+// compensations run in reverse order. A nested Do joins the outer unit.
+func (u *unitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if InTransaction(ctx) {
+		return fn(ctx)
+	}
+
+	h := &hooks{}
+	err := u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		ctx := context.WithValue(ctx, txKey{}, tx)
+		ctx = context.WithValue(ctx, hooksKey{}, h)
+		return fn(ctx)
+	})
+
+	// the hooks must run even if the request was cancelled
+	if err != nil {
+		return h.compensate(context.WithoutCancel(ctx), err)
+	}
+
+	for _, fn := range h.afterCommit {
+		fn(context.WithoutCancel(ctx))
+	}
+	return nil
+}
+
+// DB returns the transaction in the context, or db when there is none.
+func DB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+func InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*gorm.DB)
+	return ok
+}
+
+// Compensate registers fn to undo an external side effect, such as a call
+// to a third party, if the unit of work doesn't commit. Outside a unit of
+// work there is nothing to roll back and fn is discarded.
+func Compensate(ctx context.Context, fn func(ctx context.Context) error) {
+	if h, ok := ctx.Value(hooksKey{}).(*hooks); ok {
+		h.compensations = append(h.compensations, fn)
+	}
+}
+
+// AfterCommit defers fn until the unit of work commits, it is dropped on
+// rollback. Outside a unit of work fn runs right away.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	if h, ok := ctx.Value(hooksKey{}).(*hooks); ok {
+		h.afterCommit = append(h.afterCommit, fn)
+		return
+	}
+	fn(ctx)
+}
+
+func (h *hooks) compensate(ctx context.Context, cause error) error {
+	errs := []error{cause}
+	for i := len(h.compensations) - 1; i >= 0; i-- {
+		if err := h.compensations[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}